@@ -0,0 +1,294 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+type Metadata struct {
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Filename    string            `json:"filename,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Hash        string            `json:"hash,omitempty"`
+	Size        int64             `json:"size"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Identity describes the token of the client, as seen by the server.
+type Identity struct {
+	Name        string       `json:"name"`
+	TokenId     string       `json:"tokenId,omitempty"`
+	Issuer      string       `json:"issuer,omitempty"`
+	Subject     string       `json:"subject,omitempty"`
+	ExpiresAt   *time.Time   `json:"expiresAt,omitempty"`
+	Namespace   string       `json:"namespace,omitempty"`
+	Prefix      string       `json:"prefix,omitempty"`
+	Permissions []string     `json:"permissions,omitempty"`
+	Grants      []core.Grant `json:"grants,omitempty"`
+}
+
+// ArtifactSummary describes an artifact in the listing of a namespace.
+type ArtifactSummary struct {
+	Name     string `json:"name"`
+	Latest   string `json:"latest"`
+	Versions int    `json:"versions"`
+}
+
+// Versions returns the versions of an artifact, newest first. It returns ErrNotFound, if the artifact has no versions.
+func (c *Client) Versions(ctx context.Context, namespace string, name string) ([]*semver.Version, error) {
+	response := struct {
+		Versions []string `json:"versions"`
+	}{}
+
+	if err := c.getJSON(ctx, artifactPath(namespace, name), &response); err != nil {
+		return nil, err
+	}
+
+	versions := []*semver.Version{}
+	for _, value := range response.Versions {
+		if version, err := semver.NewVersion(value); err == nil {
+			versions = append(versions, version)
+		}
+	}
+
+	return versions, nil
+}
+
+// Resolve returns the version matching spec, which is an exact version, latest, a tag or a constraint like ^1.2.
+// Exact versions are returned as they are, without checking whether they exist.
+func (c *Client) Resolve(ctx context.Context, namespace string, name string, spec string) (*semver.Version, error) {
+	spec = strings.TrimSpace(spec)
+
+	if spec == "" || spec == "latest" {
+		versions, err := c.Versions(ctx, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, &Error{StatusCode: http.StatusNotFound, Status: "404 Not Found", Message: "the artifact has no versions"}
+		}
+
+		return versions[0], nil
+	}
+
+	if !IsConstraint(spec) {
+		if version, err := semver.NewVersion(spec); err == nil {
+			return version, nil
+		}
+
+		if core.ValidateTag(spec) == nil {
+			tags, err := c.Tags(ctx, namespace, name)
+			if err != nil {
+				return nil, err
+			}
+
+			tagged, ok := tags[spec]
+			if !ok {
+				return nil, &Error{StatusCode: http.StatusNotFound, Status: "404 Not Found", Message: "tag " + spec + " not found"}
+			}
+
+			return semver.NewVersion(tagged)
+		}
+	}
+
+	constraint, err := semver.NewConstraint(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version, tag or constraint %s", spec)
+	}
+
+	versions, err := c.Versions(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, version := range versions {
+		if constraint.Check(version) {
+			return version, nil
+		}
+	}
+
+	return nil, &Error{StatusCode: http.StatusNotFound, Status: "404 Not Found", Message: "no version matches " + spec}
+}
+
+// IsConstraint reports whether spec is a version constraint like ^1.2 or 1.x, rather than a version or a tag.
+func IsConstraint(spec string) bool {
+	return core.IsConstraint(spec)
+}
+
+// Filter returns the versions of an artifact matching a constraint, newest first.
+func (c *Client) Filter(ctx context.Context, namespace string, name string, constraint string) ([]*semver.Version, error) {
+	parsed, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid constraint %s", constraint)
+	}
+
+	versions, err := c.Versions(ctx, namespace, name)
+	if errors.Is(err, ErrNotFound) {
+		return []*semver.Version{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return core.FilterArray(versions, parsed.Check), nil
+}
+
+// Metadata returns the metadata of a version, which may also be latest or a tag.
+func (c *Client) Metadata(ctx context.Context, namespace string, name string, version string) (*Metadata, error) {
+	metadata := &Metadata{}
+
+	if err := c.getJSON(ctx, artifactPath(namespace, name, version, "_meta"), metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// WatchMetadata returns the metadata of the version matching spec and its ETag, once it differs from the state identified by etag.
+// The server holds the request for up to wait, before it returns ErrNotModified. Servers without long-polling respond immediately.
+// An HTTP client with a timeout shorter than wait fails the request instead.
+func (c *Client) WatchMetadata(ctx context.Context, namespace string, name string, spec string, etag string, wait time.Duration) (*Metadata, string, error) {
+	r := request{method: http.MethodGet, path: artifactPath(namespace, name, spec, "_meta")}
+
+	if wait > 0 {
+		r.path += "?wait=" + wait.String()
+	}
+	if etag != "" {
+		r.header = http.Header{"If-None-Match": {etag}}
+	}
+
+	response, err := c.do(ctx, r)
+	if err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotModified {
+		return nil, etag, ErrNotModified
+	}
+
+	metadata := &Metadata{}
+	if err := json.NewDecoder(response.Body).Decode(metadata); err != nil {
+		return nil, "", fmt.Errorf("invalid response: %w", err)
+	}
+
+	return metadata, response.Header.Get("ETag"), nil
+}
+
+// Delete deletes a version of an artifact.
+func (c *Client) Delete(ctx context.Context, namespace string, name string, version *semver.Version) error {
+	return c.sendJSON(ctx, http.MethodDelete, artifactPath(namespace, name, version.String()), nil, nil)
+}
+
+// DeleteArtifact deletes all versions of an artifact.
+func (c *Client) DeleteArtifact(ctx context.Context, namespace string, name string) error {
+	return c.sendJSON(ctx, http.MethodDelete, artifactPath(namespace, name), nil, nil)
+}
+
+type TidyResult struct {
+	DryRun  bool     `json:"dryRun"`
+	Deleted []string `json:"deleted"`
+	Kept    []string `json:"kept"`
+}
+
+// Tidy deletes all but the newest keep versions of an artifact on the server.
+// With dryRun, nothing is deleted and the result contains the versions which would be deleted.
+func (c *Client) Tidy(ctx context.Context, namespace string, name string, keep int, dryRun bool) (*TidyResult, error) {
+	path := artifactPath(namespace, name, "_tidy") + "?keep=" + strconv.Itoa(keep)
+	if dryRun {
+		path += "&dryRun=true"
+	}
+
+	result := &TidyResult{}
+	if err := c.sendJSON(ctx, http.MethodPost, path, nil, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+type Reservation struct {
+	Version   string    `json:"version"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Reserve computes the next free version of an artifact on the server and reserves it, so it isn't returned to anyone else.
+// bump is patch (default), minor or major. With a prerelease id like rc, the next numbered prerelease is reserved, eg. 1.3.0-rc.2.
+func (c *Client) Reserve(ctx context.Context, namespace string, name string, bump string, prerelease string) (*Reservation, error) {
+	reservation := &Reservation{}
+
+	err := c.sendJSON(ctx, http.MethodPost, artifactPath(namespace, name, "_reserve"), map[string]string{"bump": bump, "prerelease": prerelease}, reservation)
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+// Tags returns the tags of an artifact and the versions they point to.
+func (c *Client) Tags(ctx context.Context, namespace string, name string) (map[string]string, error) {
+	tags := map[string]string{}
+
+	if err := c.getJSON(ctx, artifactPath(namespace, name, "_tags"), &tags); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// SetTag points a tag to an existing version.
+func (c *Client) SetTag(ctx context.Context, namespace string, name string, tag string, version *semver.Version) error {
+	return c.sendJSON(ctx, http.MethodPut, artifactPath(namespace, name, "_tags", tag), map[string]string{"version": version.String()}, nil)
+}
+
+func (c *Client) DeleteTag(ctx context.Context, namespace string, name string, tag string) error {
+	return c.sendJSON(ctx, http.MethodDelete, artifactPath(namespace, name, "_tags", tag), nil, nil)
+}
+
+// Whoami returns the identity and access of the token of the client.
+func (c *Client) Whoami(ctx context.Context) (*Identity, error) {
+	identity := &Identity{}
+
+	if err := c.getJSON(ctx, "/_whoami", identity); err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+// Namespaces returns the namespaces containing artifacts the token can read.
+func (c *Client) Namespaces(ctx context.Context) ([]string, error) {
+	response := struct {
+		Namespaces []string `json:"namespaces"`
+	}{}
+
+	if err := c.getJSON(ctx, "/", &response); err != nil {
+		return nil, err
+	}
+
+	return response.Namespaces, nil
+}
+
+// Artifacts returns the artifacts of a namespace the token can read. It returns ErrNotFound, if there are none.
+func (c *Client) Artifacts(ctx context.Context, namespace string) ([]ArtifactSummary, error) {
+	response := struct {
+		Artifacts []ArtifactSummary `json:"artifacts"`
+	}{}
+
+	if err := c.getJSON(ctx, artifactPath(namespace), &response); err != nil {
+		return nil, err
+	}
+
+	return response.Artifacts, nil
+}
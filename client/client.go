@@ -0,0 +1,347 @@
+// Package client provides typed access to the HTTP API of a TinyRepo server.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrForbidden      = errors.New("forbidden")
+	ErrQuotaExceeded  = errors.New("quota exceeded")
+	ErrRateLimited    = errors.New("rate limited")
+	ErrDigestMismatch = errors.New("digest mismatch")
+	ErrNotModified    = errors.New("not modified")
+)
+
+// Error is returned for responses with an error status. It matches the Err* variables using errors.Is.
+type Error struct {
+	StatusCode int
+	Status     string
+	Message    string
+
+	retryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Status
+	}
+
+	return e.Status + ": " + e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrQuotaExceeded:
+		return e.StatusCode == http.StatusInsufficientStorage || e.StatusCode == http.StatusRequestEntityTooLarge
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	}
+
+	return false
+}
+
+// rejected reports whether the server refused to process the request, so it can be sent again.
+func (e *Error) rejected() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+}
+
+// temporary reports whether the request may succeed, if it is retried.
+func (e *Error) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 && e.StatusCode != http.StatusNotImplemented && e.StatusCode != http.StatusInsufficientStorage
+}
+
+type Client struct {
+	address    string
+	token      string
+	httpClient *http.Client
+	retries    int
+	retryDelay time.Duration
+	onRetry    func(err error, delay time.Duration)
+}
+
+type Option func(*Client)
+
+// WithToken authenticates all requests with a JWT or an API key.
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries retries requests failing because of network errors, 429 or 5xx responses up to retries times.
+// The delay doubles with every attempt, starting with delay.
+func WithRetries(retries int, delay time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.retryDelay = delay
+	}
+}
+
+// OnRetry is called before a failed request is retried.
+func OnRetry(hook func(err error, delay time.Duration)) Option {
+	return func(c *Client) {
+		c.onRetry = hook
+	}
+}
+
+// New creates a client for the server at address, eg. https://repo.example.com.
+// By default, failed requests are retried 3 times.
+func New(address string, options ...Option) *Client {
+	c := &Client{
+		address:    strings.TrimSuffix(address, "/"),
+		httpClient: http.DefaultClient,
+		retries:    3,
+		retryDelay: time.Second,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+func (c *Client) Address() string {
+	return c.address
+}
+
+// artifactPath builds an escaped path from the given segments.
+func artifactPath(segments ...string) string {
+	return "/" + strings.Join(mapSegments(segments, url.PathEscape), "/")
+}
+
+func mapSegments(segments []string, f func(string) string) []string {
+	result := make([]string, len(segments))
+	for i, segment := range segments {
+		result[i] = f(segment)
+	}
+	return result
+}
+
+// request describes a request, which can be sent multiple times.
+type request struct {
+	method string
+	path   string
+	// url is used instead of the address and path for requests to other hosts, eg. presigned URLs. They don't get the token.
+	url    string
+	header http.Header
+	// body returns the body for every attempt. It may fail, if the body can't be sent again.
+	body          func() (io.Reader, error)
+	contentLength int64
+}
+
+// do sends the request and retries it on temporary failures. Error responses are returned as *Error.
+// POST requests aren't idempotent, eg. reserving a version. They are only retried, if the server can't have processed them.
+func (c *Client) do(ctx context.Context, r request) (*http.Response, error) {
+	idempotent := r.method != http.MethodPost
+
+	var lastErr error
+
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			delay := c.backoff(attempt, lastErr)
+
+			if c.onRetry != nil {
+				c.onRetry(lastErr, delay)
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		response, written, err := c.send(ctx, r)
+		if err == nil {
+			return response, nil
+		}
+
+		lastErr = err
+
+		var responseErr *Error
+		var netErr net.Error
+		switch {
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case errors.As(err, &responseErr):
+			if !responseErr.temporary() || !idempotent && !responseErr.rejected() {
+				return nil, err
+			}
+		case errors.As(err, &netErr), errors.Is(err, io.ErrUnexpectedEOF):
+			// The response may have been lost after the server processed the request.
+			if !idempotent && written {
+				return nil, err
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, lastErr
+}
+
+// send sends the request once. written reports whether the request has been written completely.
+func (c *Client) send(ctx context.Context, r request) (response *http.Response, written bool, err error) {
+	var body io.Reader
+	if r.body != nil {
+		body, err = r.body()
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			written = info.Err == nil
+		},
+	})
+
+	target := c.address + r.path
+	if r.url != "" {
+		target = r.url
+	}
+
+	httpRequest, err := http.NewRequestWithContext(ctx, r.method, target, body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if body != nil {
+		httpRequest.ContentLength = r.contentLength
+	}
+
+	for key, values := range r.header {
+		httpRequest.Header[key] = values
+	}
+
+	if c.token != "" && r.url == "" {
+		httpRequest.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	response, err = c.httpClient.Do(httpRequest)
+	if err != nil {
+		return nil, written, err
+	}
+
+	if response.StatusCode >= 400 {
+		return nil, written, readError(response)
+	}
+
+	return response, written, nil
+}
+
+func (c *Client) backoff(attempt int, err error) time.Duration {
+	var responseErr *Error
+	if errors.As(err, &responseErr) && responseErr.retryAfter > 0 {
+		return responseErr.retryAfter
+	}
+
+	delay := c.retryDelay << (attempt - 1)
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+
+	// Add some jitter, so concurrent clients don't retry at the same time.
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
+
+func readError(response *http.Response) error {
+	defer response.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+
+	err := &Error{StatusCode: response.StatusCode, Status: response.Status}
+
+	message := struct {
+		Message string `json:"message"`
+	}{}
+
+	if json.Unmarshal(body, &message) == nil && message.Message != "" {
+		err.Message = message.Message
+	} else if text := strings.TrimSpace(string(body)); len(text) < 200 {
+		err.Message = text
+	}
+
+	if seconds, parseErr := strconv.Atoi(response.Header.Get("Retry-After")); parseErr == nil {
+		err.retryAfter = time.Duration(seconds) * time.Second
+	}
+
+	return err
+}
+
+// getJSON sends a GET request and decodes the JSON response into result.
+func (c *Client) getJSON(ctx context.Context, path string, result interface{}) error {
+	response, err := c.do(ctx, request{method: http.MethodGet, path: path})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+
+	return nil
+}
+
+// sendJSON sends a request with an optional JSON body and decodes the JSON response into result, unless it is nil.
+func (c *Client) sendJSON(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	r := request{method: method, path: path}
+
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		r.header = http.Header{"Content-Type": {"application/json"}}
+		r.contentLength = int64(len(content))
+		r.body = func() (io.Reader, error) {
+			return strings.NewReader(string(content)), nil
+		}
+	}
+
+	response, err := c.do(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,348 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+func TestRetries(t *testing.T) {
+	attempts := atomic.Int32{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The storage doesn't support presigned uploads.
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+
+		attempt := attempts.Add(1)
+
+		if attempt < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "content" {
+			t.Errorf("unexpected body %q on attempt %d", body, attempt)
+		}
+		if r.Header.Get("X-Content-Hash") != "sha256:ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73" {
+			t.Errorf("unexpected hash %s", r.Header.Get("X-Content-Hash"))
+		}
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithRetries(3, time.Millisecond))
+
+	_, err := c.Push(context.Background(), "ns", "app", semver.MustParse("1.0.0"), strings.NewReader("content"), PushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestPushPresigned(t *testing.T) {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPut || string(body) != "content" || r.Header.Get("Authorization") != "" {
+			t.Errorf("unexpected upload %s %q with authorization %q", r.Method, body, r.Header.Get("Authorization"))
+		}
+	}))
+	defer storage.Close()
+
+	completed := atomic.Bool{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /ns/app/1.0.0/presigned-uploads":
+			w.Write([]byte(`{"uploadId":"abc","method":"PUT","url":"` + storage.URL + `/staged"}`))
+		case "POST /ns/app/1.0.0/presigned-uploads/abc":
+			request := completeUploadRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+			if request.Filename != "app.txt" || request.ContentType != "text/plain; charset=utf-8" || r.URL.Query().Get("keep") != "2" {
+				t.Errorf("unexpected completion %+v %s", request, r.URL.RawQuery)
+			}
+
+			completed.Store(true)
+			w.Write([]byte(`{"hash":"` + request.Hash + `"}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithToken("token"), WithRetries(0, time.Millisecond))
+
+	result, err := c.Push(context.Background(), "ns", "app", semver.MustParse("1.0.0"), strings.NewReader("content"), PushOptions{Filename: "app.txt", Keep: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !completed.Load() || result.Hash != "sha256:ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73" || result.Size != 7 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestPushSession(t *testing.T) {
+	defer func(size int64) { sessionChunkSize = size }(sessionChunkSize)
+	sessionChunkSize = 4
+
+	content := "0123456789"
+	received := ""
+	failed := false
+	mutex := sync.Mutex{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		switch r.Method + " " + r.URL.Path {
+		case "POST /ns/app/1.0.0/presigned-uploads":
+			w.WriteHeader(http.StatusNotImplemented)
+		case "POST /ns/app/1.0.0/uploads":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"abc","offset":0}`))
+		case "PATCH /ns/app/1.0.0/uploads/abc":
+			body, _ := io.ReadAll(r.Body)
+
+			if r.Header.Get("Upload-Offset") != strconv.Itoa(len(received)) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+
+			// The second chunk is written partially, before the connection breaks.
+			if len(received) == 4 && !failed {
+				failed = true
+				received += string(body[:2])
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+
+			received += string(body)
+			w.WriteHeader(http.StatusNoContent)
+		case "GET /ns/app/1.0.0/uploads/abc":
+			w.Write([]byte(`{"id":"abc","offset":` + strconv.Itoa(len(received)) + `}`))
+		case "PUT /ns/app/1.0.0/uploads/abc":
+			request := completeUploadRequest{}
+			json.NewDecoder(r.Body).Decode(&request)
+			w.Write([]byte(`{"hash":"` + request.Hash + `"}`))
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithRetries(3, time.Millisecond))
+
+	result, err := c.Push(context.Background(), "ns", "app", semver.MustParse("1.0.0"), strings.NewReader(content), PushOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if received != content || result.Size != int64(len(content)) {
+		t.Errorf("expected the upload to be resumed, got %q", received)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		expected error
+		attempts int
+	}{
+		{http.StatusNotFound, ErrNotFound, 1},
+		{http.StatusForbidden, ErrForbidden, 1},
+		{http.StatusInsufficientStorage, ErrQuotaExceeded, 1},
+		{http.StatusTooManyRequests, ErrRateLimited, 3},
+	}
+
+	for _, test := range tests {
+		attempts := atomic.Int32{}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(test.status)
+			w.Write([]byte(`{"message":"failed"}`))
+		}))
+
+		c := New(server.URL, WithRetries(2, time.Millisecond))
+
+		_, err := c.Versions(context.Background(), "ns", "app")
+
+		var responseErr *Error
+		if !errors.Is(err, test.expected) || !errors.As(err, &responseErr) || responseErr.Message != "failed" {
+			t.Errorf("status %d: unexpected error %v", test.status, err)
+		}
+		if int(attempts.Load()) != test.attempts {
+			t.Errorf("status %d: expected %d attempts, got %d", test.status, test.attempts, attempts.Load())
+		}
+
+		server.Close()
+	}
+}
+
+func TestReserveRetries(t *testing.T) {
+	attempts := atomic.Int32{}
+	dropConnection := atomic.Bool{}
+	dropConnection.Store(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt := attempts.Add(1)
+
+		// The response is lost after the version has been reserved.
+		if dropConnection.Load() {
+			connection, _, _ := w.(http.Hijacker).Hijack()
+			connection.Close()
+			return
+		}
+
+		if attempt < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte(`{"version":"1.0.0"}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithRetries(3, time.Millisecond))
+
+	if _, err := c.Reserve(context.Background(), "ns", "app", "", ""); err == nil || attempts.Load() != 1 {
+		t.Errorf("expected a single attempt, got %d: %v", attempts.Load(), err)
+	}
+
+	// Rejected requests are retried.
+	attempts.Store(0)
+	dropConnection.Store(false)
+
+	if _, err := c.Reserve(context.Background(), "ns", "app", "", ""); err != nil || attempts.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d: %v", attempts.Load(), err)
+	}
+}
+
+func TestPullVerifiesDigest(t *testing.T) {
+	hash := "sha256:ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ns/app":
+			w.Write([]byte(`{"versions":["1.1.0","1.0.0"]}`))
+		case "/ns/app/1.1.0":
+			w.Header().Set("X-Content-Hash", hash)
+			w.Write([]byte("content"))
+		case "/ns/app/1.0.0":
+			w.Header().Set("X-Content-Hash", hash)
+			w.Write([]byte("corrupt"))
+		}
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+
+	for spec, expected := range map[string]error{"latest": nil, "1.0.0": ErrDigestMismatch} {
+		download, err := c.Pull(context.Background(), "ns", "app", spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = io.ReadAll(download.Body)
+		download.Body.Close()
+
+		if !errors.Is(err, expected) {
+			t.Errorf("%s: expected %v, got %v", spec, expected, err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ns/app":
+			w.Write([]byte(`{"versions":["2.0.0-rc.1","1.2.3","1.1.0","1.0.0"]}`))
+		case "/ns/app/_tags":
+			w.Write([]byte(`{"stable":"1.1.0"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+
+	tests := map[string]string{
+		"latest": "2.0.0-rc.1",
+		"1.0.0":  "1.0.0",
+		"^1.0":   "1.2.3",
+		"~1.1":   "1.1.0",
+		"1.x":    "1.2.3",
+		"stable": "1.1.0",
+	}
+
+	for spec, expected := range tests {
+		version, err := c.Resolve(context.Background(), "ns", "app", spec)
+		if err != nil {
+			t.Errorf("%s: %v", spec, err)
+			continue
+		}
+		if version.String() != expected {
+			t.Errorf("%s: expected %s, got %s", spec, expected, version)
+		}
+	}
+
+	if _, err := c.Resolve(context.Background(), "ns", "app", "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound for an unknown tag, got %v", err)
+	}
+}
+
+func TestWatchMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ns/app/^1.2/_meta" {
+			t.Errorf("unexpected path %s", r.URL.EscapedPath())
+		}
+		if r.URL.Query().Get("wait") != "30s" {
+			t.Errorf("unexpected wait parameter %q", r.URL.Query().Get("wait"))
+		}
+
+		w.Header().Set("ETag", `"v2"`)
+
+		if r.Header.Get("If-None-Match") == `"v2"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Write([]byte(`{"version":"1.3.0","hash":"sha256:abc"}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+
+	metadata, etag, err := c.WatchMetadata(context.Background(), "ns", "app", "^1.2", `"v1"`, 30*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Version != "1.3.0" || etag != `"v2"` {
+		t.Errorf("unexpected result %s %s", metadata.Version, etag)
+	}
+
+	if _, _, err := c.WatchMetadata(context.Background(), "ns", "app", "^1.2", etag, 30*time.Second); !errors.Is(err, ErrNotModified) {
+		t.Errorf("expected ErrNotModified, got %v", err)
+	}
+}
@@ -0,0 +1,419 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+type PushOptions struct {
+	// Filename is used for downloads of the version.
+	Filename    string
+	ContentType string
+	Labels      map[string]string
+	// Keep deletes older versions, so only this number of versions is kept.
+	Keep int
+	// Hash is the sha256 hash of the content, eg. sha256:2c26b4..., which is verified by the server.
+	// If it is empty and the content is an io.Seeker, it is calculated before the upload.
+	Hash string
+	// Size is the size of the content. It is determined automatically for an io.Seeker.
+	Size int64
+}
+
+type PushResult struct {
+	Version *semver.Version
+	Hash    string
+	Size    int64
+}
+
+// sessionChunkSize is the size of the chunks of an upload session. All chunks except the last one must be at least 5 MiB.
+var sessionChunkSize int64 = 16 << 20
+
+// Push uploads content as a version of an artifact.
+// If its size is known, it is uploaded directly to the storage backend, if that supports presigned uploads.
+// Otherwise large content is uploaded in chunks, which are resumed after failures, or with a single request.
+// The upload is only retried, if content is an io.Seeker, because it must be sent again.
+func (c *Client) Push(ctx context.Context, namespace string, name string, version *semver.Version, content io.Reader, options PushOptions) (*PushResult, error) {
+	seeker, seekable := content.(io.Seeker)
+
+	size := int64(-1)
+	if options.Size > 0 {
+		size = options.Size
+	}
+
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		size = end - start
+
+		if options.Hash == "" {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, err
+			}
+
+			hasher := sha256.New()
+			if _, err := io.Copy(hasher, content); err != nil {
+				return nil, err
+			}
+			options.Hash = formatHash(hasher)
+		}
+
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.ContentType == "" {
+		options.ContentType = mime.TypeByExtension(path.Ext(options.Filename))
+	}
+	if options.ContentType == "" {
+		options.ContentType = "application/octet-stream"
+	}
+
+	upload := &pushUpload{
+		versionPath: artifactPath(namespace, name, version.String()),
+		content:     &pushContent{reader: content, start: start, size: size},
+		options:     options,
+	}
+	if seekable {
+		upload.content.seeker = seeker
+	}
+	if options.Keep > 0 {
+		upload.query = "?keep=" + strconv.Itoa(options.Keep)
+	}
+
+	if size >= 0 {
+		result, err := c.pushPresigned(ctx, upload)
+		if !isUnsupported(err) {
+			return withVersion(result, version), err
+		}
+
+		if seekable && size > sessionChunkSize {
+			result, err := c.pushSession(ctx, upload)
+			if !isUnsupported(err) {
+				return withVersion(result, version), err
+			}
+		}
+	}
+
+	result, err := c.pushDirect(ctx, upload)
+
+	return withVersion(result, version), err
+}
+
+type pushUpload struct {
+	versionPath string
+	// query contains the parameters of the request, which commits the version.
+	query   string
+	content *pushContent
+	options PushOptions
+}
+
+// pushContent is the content of a push. Parts of it can only be sent again, if it is seekable.
+type pushContent struct {
+	reader io.Reader
+	seeker io.Seeker
+	start  int64
+	// size is -1, if it is unknown.
+	size int64
+	sent bool
+}
+
+// body returns the bodies of a request sending length bytes from offset. A length of -1 sends the rest of the content.
+func (p *pushContent) body(offset int64, length int64) func() (io.Reader, error) {
+	return func() (io.Reader, error) {
+		if p.seeker == nil {
+			if p.sent {
+				return nil, errors.New("the upload failed and can't be retried, because the content isn't seekable")
+			}
+			p.sent = true
+
+			// Hide Close, the transport must not close the content.
+			return struct{ io.Reader }{p.reader}, nil
+		}
+
+		if _, err := p.seeker.Seek(p.start+offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		if length < 0 {
+			return struct{ io.Reader }{p.reader}, nil
+		}
+
+		return io.LimitReader(p.reader, length), nil
+	}
+}
+
+// completeUploadRequest commits a presigned upload or an upload session.
+type completeUploadRequest struct {
+	Filename    string            `json:"filename,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Hash        string            `json:"hash,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+func (u *pushUpload) completeRequest() *completeUploadRequest {
+	return &completeUploadRequest{
+		Filename:    u.options.Filename,
+		ContentType: u.options.ContentType,
+		Hash:        u.options.Hash,
+		Labels:      u.options.Labels,
+	}
+}
+
+// isUnsupported reports whether the server or its storage backend doesn't support a way of uploading.
+// Older servers respond with 405 Method Not Allowed.
+func isUnsupported(err error) bool {
+	var responseErr *Error
+
+	return errors.As(err, &responseErr) && (responseErr.StatusCode == http.StatusNotImplemented || responseErr.StatusCode == http.StatusMethodNotAllowed)
+}
+
+func withVersion(result *PushResult, version *semver.Version) *PushResult {
+	if result != nil {
+		result.Version = version
+	}
+
+	return result
+}
+
+// pushPresigned uploads the content directly to the storage backend, using a URL presigned by the server.
+func (c *Client) pushPresigned(ctx context.Context, upload *pushUpload) (*PushResult, error) {
+	presigned := struct {
+		UploadId string `json:"uploadId"`
+		Method   string `json:"method"`
+		Url      string `json:"url"`
+	}{}
+
+	if err := c.sendJSON(ctx, http.MethodPost, upload.versionPath+"/presigned-uploads", nil, &presigned); err != nil {
+		return nil, err
+	}
+
+	response, err := c.do(ctx, request{
+		method:        presigned.Method,
+		url:           presigned.Url,
+		contentLength: upload.content.size,
+		body:          upload.content.body(0, -1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	meta := core.BlobMeta{}
+	completePath := upload.versionPath + "/presigned-uploads/" + url.PathEscape(presigned.UploadId) + upload.query
+
+	if err := c.sendJSON(ctx, http.MethodPost, completePath, upload.completeRequest(), &meta); err != nil {
+		return nil, err
+	}
+
+	return &PushResult{Hash: meta.Hash, Size: upload.content.size}, nil
+}
+
+// pushSession uploads the content in chunks. If a chunk fails, the upload is resumed at the offset reported by the server.
+func (c *Client) pushSession(ctx context.Context, upload *pushUpload) (*PushResult, error) {
+	session := struct {
+		Id     string `json:"id"`
+		Offset int64  `json:"offset"`
+	}{}
+
+	if err := c.sendJSON(ctx, http.MethodPost, upload.versionPath+"/uploads", nil, &session); err != nil {
+		return nil, err
+	}
+
+	sessionPath := upload.versionPath + "/uploads/" + url.PathEscape(session.Id)
+
+	result, err := c.writeSession(ctx, upload, sessionPath)
+	if err != nil && !isUnsupported(err) {
+		// Don't leave the session behind until it expires.
+		if abortErr := c.sendJSON(ctx, http.MethodDelete, sessionPath, nil, nil); abortErr != nil && !errors.Is(abortErr, ErrNotFound) {
+			return nil, fmt.Errorf("%w, failed to abort the upload session: %v", err, abortErr)
+		}
+	}
+
+	return result, err
+}
+
+func (c *Client) writeSession(ctx context.Context, upload *pushUpload, sessionPath string) (*PushResult, error) {
+	offset := int64(0)
+
+	for offset < upload.content.size {
+		length := min(sessionChunkSize, upload.content.size-offset)
+
+		response, err := c.do(ctx, request{
+			method:        http.MethodPatch,
+			path:          sessionPath,
+			header:        http.Header{"Upload-Offset": {strconv.FormatInt(offset, 10)}},
+			contentLength: length,
+			body:          upload.content.body(offset, length),
+		})
+
+		var responseErr *Error
+		if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusConflict {
+			// A failed attempt may have been written partially.
+			session := struct {
+				Offset int64 `json:"offset"`
+			}{}
+
+			if getErr := c.getJSON(ctx, sessionPath, &session); getErr != nil {
+				return nil, getErr
+			}
+			if session.Offset == offset || session.Offset > upload.content.size {
+				return nil, err
+			}
+
+			offset = session.Offset
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		response.Body.Close()
+
+		offset += length
+	}
+
+	meta := core.BlobMeta{}
+	if err := c.sendJSON(ctx, http.MethodPut, sessionPath+upload.query, upload.completeRequest(), &meta); err != nil {
+		return nil, err
+	}
+
+	return &PushResult{Hash: meta.Hash, Size: upload.content.size}, nil
+}
+
+// pushDirect sends the content with a single request through the server.
+func (c *Client) pushDirect(ctx context.Context, upload *pushUpload) (*PushResult, error) {
+	segments := []string{upload.versionPath}
+	if upload.options.Filename != "" {
+		segments = append(segments, url.PathEscape(upload.options.Filename))
+	}
+
+	header := http.Header{"Content-Type": {upload.options.ContentType}}
+	if upload.options.Hash != "" {
+		header.Set(core.HeaderContentHash, upload.options.Hash)
+	}
+	for key, value := range upload.options.Labels {
+		header.Add("X-Label", key+"="+value)
+	}
+
+	response, err := c.do(ctx, request{
+		method:        http.MethodPut,
+		path:          strings.Join(segments, "/") + upload.query,
+		header:        header,
+		contentLength: upload.content.size,
+		body:          upload.content.body(0, -1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	response.Body.Close()
+
+	return &PushResult{Hash: upload.options.Hash, Size: upload.content.size}, nil
+}
+
+// Download is the content of a version. Reading Body returns ErrDigestMismatch at the end,
+// if the content doesn't match the hash advertised by the server.
+type Download struct {
+	Version     *semver.Version
+	Filename    string
+	ContentType string
+	// Hash is empty, if the server didn't send it. In this case the content can't be verified.
+	Hash string
+	// Size is -1, if it is unknown.
+	Size int64
+	Body io.ReadCloser
+}
+
+// Pull downloads a version, which may also be latest, a tag or a constraint. The caller must close the body.
+func (c *Client) Pull(ctx context.Context, namespace string, name string, spec string) (*Download, error) {
+	version, err := c.Resolve(ctx, namespace, name, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.do(ctx, request{method: http.MethodGet, path: artifactPath(namespace, name, version.String())})
+	if err != nil {
+		return nil, err
+	}
+
+	download := &Download{
+		Version:     version,
+		Filename:    name,
+		ContentType: response.Header.Get("Content-Type"),
+		Hash:        advertisedHash(response),
+		Size:        response.ContentLength,
+		Body:        response.Body,
+	}
+
+	if _, params, err := mime.ParseMediaType(response.Header.Get("Content-Disposition")); err == nil {
+		// Never trust directories sent by the server.
+		if filename := path.Base(path.Clean("/" + params["filename"])); filename != "/" && filename != "." {
+			download.Filename = filename
+		}
+	}
+
+	if download.Hash != "" {
+		download.Body = &verifyingReader{ReadCloser: response.Body, hasher: sha256.New(), expected: download.Hash}
+	}
+
+	return download, nil
+}
+
+// advertisedHash returns the hash sent by the server. When the server redirected to the storage backend, it is sent with the redirect.
+func advertisedHash(response *http.Response) string {
+	for response != nil {
+		if hash := response.Header.Get(core.HeaderContentHash); hash != "" {
+			return hash
+		}
+		if response.Request == nil {
+			break
+		}
+		response = response.Request.Response
+	}
+
+	return ""
+}
+
+type verifyingReader struct {
+	io.ReadCloser
+	hasher   hash.Hash
+	expected string
+}
+
+func (r *verifyingReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.hasher.Write(b[:n])
+
+	if err == io.EOF {
+		if actual := formatHash(r.hasher); actual != r.expected {
+			return n, fmt.Errorf("%w: expected %s but got %s", ErrDigestMismatch, r.expected, actual)
+		}
+	}
+
+	return n, err
+}
+
+func formatHash(hasher hash.Hash) string {
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil))
+}
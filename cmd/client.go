@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sevensolutions/tiny-repo/client"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/spf13/cobra"
+)
+
+var address string
+var token string
+var tokenFile string
+var retries int
+
+// addClientFlags adds the flags of commands, which talk to a TinyRepo server.
+func addClientFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&address, "address", "", "The TinyRepo server address (default TINYREPO_ADDRESS)")
+	cmd.Flags().StringVar(&token, "token", "", "The access token (default TINYREPO_TOKEN)")
+	cmd.Flags().StringVar(&tokenFile, "token-file", "", "A file containing the access token (default TINYREPO_TOKEN_FILE)")
+	cmd.Flags().IntVar(&retries, "retries", 3, "How often failed requests are retried")
+}
+
+// serverAddress returns the address passed by flag or environment variable, or the default server of the credential store.
+func serverAddress() (string, error) {
+	if address == "" {
+		address = core.GetEnvVar("TINYREPO_ADDRESS", "")
+	}
+
+	if address == "" {
+		store, err := loadCredentialStore()
+		if err != nil {
+			return "", err
+		}
+		address = store.Default
+	}
+
+	if address == "" {
+		return "", errors.New("missing server address, use --address, TINYREPO_ADDRESS or tinyrepo login")
+	}
+
+	address = normalizeAddress(address)
+
+	return address, nil
+}
+
+// accessToken returns the token passed by flag or environment variable, read from the token file,
+// or stored for the server by tinyrepo login.
+func accessToken() (string, error) {
+	if token == "" {
+		token = core.GetEnvVar("TINYREPO_TOKEN", "")
+	}
+	if tokenFile == "" {
+		tokenFile = core.GetEnvVar("TINYREPO_TOKEN_FILE", "")
+	}
+
+	if token == "" && tokenFile != "" {
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the token file: %w", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+
+	if token == "" && address != "" {
+		store, err := loadCredentialStore()
+		if err != nil {
+			return "", err
+		}
+		token = store.Servers[normalizeAddress(address)].Token
+	}
+
+	return token, nil
+}
+
+// newClient creates a client for the server address and token of the command.
+// Retries are reported on stderr.
+func newClient() (*client.Client, error) {
+	baseUrl, err := serverAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := accessToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.New(baseUrl,
+		client.WithToken(token),
+		client.WithRetries(retries, time.Second),
+		client.OnRetry(func(err error, delay time.Duration) {
+			fmt.Fprintf(os.Stderr, "%s, retrying in %s\n", err, delay.Round(time.Millisecond))
+		}),
+	), nil
+}
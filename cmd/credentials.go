@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// credentialStore contains the tokens of the servers the user logged in to.
+// It is stored in the user's config directory, readable only by the user.
+type credentialStore struct {
+	Default string                      `json:"default,omitempty"`
+	Servers map[string]serverCredential `json:"servers"`
+}
+
+type serverCredential struct {
+	Token string `json:"token"`
+}
+
+func credentialStorePath() (string, error) {
+	if path := core.GetEnvVar("TINYREPO_CREDENTIALS_FILE", ""); path != "" {
+		return path, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(configDir, "tinyrepo", "credentials.json"), nil
+}
+
+func loadCredentialStore() (*credentialStore, error) {
+	store := &credentialStore{Servers: map[string]serverCredential{}}
+
+	path, err := credentialStorePath()
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, store); err != nil {
+		return nil, errors.New("invalid credentials file " + path + ": " + err.Error())
+	}
+	if store.Servers == nil {
+		store.Servers = map[string]serverCredential{}
+	}
+
+	return store, nil
+}
+
+func (s *credentialStore) save() error {
+	path, err := credentialStorePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	content, _ := json.MarshalIndent(s, "", "  ")
+
+	// Write a new file and replace the old one, so the permissions are always restricted.
+	if err := os.WriteFile(path+".tmp", content, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// normalizeAddress turns repo.example.com/ into https://repo.example.com, so every server has a single entry.
+func normalizeAddress(address string) string {
+	address = strings.TrimSuffix(strings.TrimSpace(address), "/")
+
+	if !strings.Contains(address, "://") {
+		address = "https://" + address
+	}
+
+	return address
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	cases := map[string]string{
+		"repo.example.com":          "https://repo.example.com",
+		" repo.example.com/ ":       "https://repo.example.com",
+		"http://localhost:8080/":    "http://localhost:8080",
+		"https://repo.example.com":  "https://repo.example.com",
+		"https://example.com/repo/": "https://example.com/repo",
+	}
+
+	for value, expected := range cases {
+		if normalized := normalizeAddress(value); normalized != expected {
+			t.Errorf("%q: expected %s, got %s", value, expected, normalized)
+		}
+	}
+}
+
+func TestCredentialStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config", "tinyrepo", "credentials.json")
+	t.Setenv("TINYREPO_CREDENTIALS_FILE", path)
+
+	store, err := loadCredentialStore()
+	if err != nil || store.Default != "" || len(store.Servers) != 0 {
+		t.Fatalf("expected an empty store, got %+v %v", store, err)
+	}
+
+	store.Servers["https://repo.example.com"] = serverCredential{Token: "secret"}
+	store.Default = "https://repo.example.com"
+
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the store to be readable by the user only, got %v %v", info.Mode(), err)
+	}
+
+	loaded, err := loadCredentialStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(store, loaded) {
+		t.Errorf("expected %+v, got %+v", store, loaded)
+	}
+
+	// Without an address, the default server of the store is used.
+	useTestServer(t, "")
+	t.Setenv("TINYREPO_CREDENTIALS_FILE", path)
+
+	if server, err := serverAddress(); err != nil || server != "https://repo.example.com" {
+		t.Errorf("expected the default server, got %s %v", server, err)
+	}
+	if token, err := accessToken(); err != nil || token != "secret" {
+		t.Errorf("expected the stored token, got %s %v", token, err)
+	}
+}
+
+func TestAccessTokenPrecedence(t *testing.T) {
+	useTestServer(t, "repo.example.com/")
+
+	store, _ := loadCredentialStore()
+	store.Servers["https://repo.example.com"] = serverCredential{Token: "stored"}
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(file, []byte("from-file\n"), 0600)
+
+	cases := []struct {
+		flag     string
+		env      string
+		file     string
+		expected string
+	}{
+		{"from-flag", "from-env", file, "from-flag"},
+		{"", "from-env", file, "from-env"},
+		{"", "", file, "from-file"},
+		{"", "", "", "stored"},
+	}
+
+	for i, c := range cases {
+		token, tokenFile = c.flag, ""
+		t.Setenv("TINYREPO_TOKEN", c.env)
+		t.Setenv("TINYREPO_TOKEN_FILE", c.file)
+
+		if resolved, err := accessToken(); err != nil || resolved != c.expected {
+			t.Errorf("case %d: expected %s, got %s %v", i, c.expected, resolved, err)
+		}
+	}
+}
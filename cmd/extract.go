@@ -0,0 +1,434 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type extractOptions struct {
+	// StripComponents removes leading path components of all entries. Entries with fewer components are skipped.
+	StripComponents int
+	// Atomic extracts into a temporary directory next to the target, which then replaces the target.
+	Atomic bool
+}
+
+// extractTo extracts a ZIP, tar, tar.gz or tar.zst archive into dir, which is created if needed.
+// The format is detected from the content. Tar archives are streamed, ZIP archives are buffered in a temporary file.
+// Entries escaping dir, directly or through symlinks, are rejected.
+func extractTo(reader io.Reader, dir string, options extractOptions) error {
+	if !options.Atomic {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		return extractArchive(reader, dir, options)
+	}
+
+	parent := filepath.Dir(filepath.Clean(dir))
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+
+	temp, err := os.MkdirTemp(parent, "."+filepath.Base(dir)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(temp)
+
+	if err := os.Chmod(temp, 0755); err != nil {
+		return err
+	}
+
+	if err := extractArchive(reader, temp, options); err != nil {
+		return err
+	}
+
+	return swapDir(temp, dir)
+}
+
+// swapDir replaces dir with the directory replacement. Both are exchanged atomically, if the platform and
+// the filesystem support it. Otherwise dir is missing for a short moment between two renames.
+func swapDir(replacement string, dir string) error {
+	if _, err := os.Lstat(dir); errors.Is(err, os.ErrNotExist) {
+		return os.Rename(replacement, dir)
+	}
+
+	if err := exchangeDirs(replacement, dir); err == nil {
+		// replacement now contains the previous content of dir.
+		return os.RemoveAll(replacement)
+	}
+
+	old := filepath.Join(filepath.Dir(filepath.Clean(dir)), "."+filepath.Base(dir)+".old")
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+
+	if err := os.Rename(dir, old); err != nil {
+		return err
+	}
+
+	if err := os.Rename(replacement, dir); err != nil {
+		os.Rename(old, dir)
+		return err
+	}
+
+	return os.RemoveAll(old)
+}
+
+func extractArchive(reader io.Reader, dir string, options extractOptions) error {
+	buffered := bufio.NewReaderSize(reader, 64*1024)
+
+	header, err := buffered.Peek(512)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")) || bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return extractZip(buffered, dir, options)
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		return extractTar(decompressed, dir, options)
+	case bytes.HasPrefix(header, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		decompressed, err := zstd.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer decompressed.Close()
+		return extractTar(decompressed, dir, options)
+	case len(header) > 262 && string(header[257:262]) == "ustar":
+		return extractTar(buffered, dir, options)
+	}
+
+	return errors.New("unsupported archive format, expected ZIP, tar, tar.gz or tar.zst")
+}
+
+// stripComponents returns the name without its leading count components, or false if nothing is left.
+// Absolute names and parent references are kept, so entryPath rejects them.
+func stripComponents(name string, count int) (string, bool) {
+	if count == 0 || path.IsAbs(name) {
+		return name, true
+	}
+
+	segments := strings.FieldsFunc(name, func(r rune) bool { return r == '/' })
+	if len(segments) <= count {
+		return "", false
+	}
+
+	return strings.Join(segments[count:], "/"), true
+}
+
+// entryPath returns the path of an archive entry inside dir. It rejects entries escaping dir
+// and entries, which would be written through a symlink created by an earlier entry.
+func entryPath(dir string, name string) (string, error) {
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("archive entry %s has an absolute path", name)
+	}
+
+	relative := filepath.Clean(filepath.FromSlash(name))
+	if relative == ".." || strings.HasPrefix(relative, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %s escapes the target directory", name)
+	}
+
+	target := filepath.Join(dir, relative)
+
+	current := filepath.Clean(dir)
+	for _, segment := range strings.Split(filepath.Dir(relative), string(os.PathSeparator)) {
+		if segment == "." {
+			continue
+		}
+
+		current = filepath.Join(current, segment)
+
+		info, err := os.Lstat(current)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive entry %s is located below a symlink", name)
+		}
+	}
+
+	return target, nil
+}
+
+// checkSymlink makes sure, a symlink created at target points to a location inside dir.
+func checkSymlink(dir string, target string, linkname string) error {
+	if filepath.IsAbs(linkname) || path.IsAbs(linkname) {
+		return fmt.Errorf("symlink %s has an absolute target %s", target, linkname)
+	}
+
+	resolved := filepath.Join(filepath.Dir(target), filepath.FromSlash(linkname))
+	if resolved != filepath.Clean(dir) && !strings.HasPrefix(resolved, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return fmt.Errorf("symlink %s points outside of the target directory", target)
+	}
+
+	return nil
+}
+
+// removeExisting removes a symlink or file at target, so new content is never written through a symlink.
+func removeExisting(target string) error {
+	info, err := os.Lstat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return nil
+	}
+
+	return os.Remove(target)
+}
+
+func extractTar(reader io.Reader, dir string, options extractOptions) error {
+	archive := tar.NewReader(reader)
+
+	symlinks := []string{}
+
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if header.Typeflag == tar.TypeXGlobalHeader {
+			// Metadata only, eg. the commit written by git archive.
+			continue
+		}
+
+		name, ok := stripComponents(header.Name, options.StripComponents)
+		if !ok {
+			continue
+		}
+
+		target, err := entryPath(dir, name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0755)
+		case tar.TypeReg:
+			err = writeEntry(target, archive, header.FileInfo().Mode())
+		case tar.TypeSymlink:
+			err = createSymlink(dir, target, header.Linkname)
+			symlinks = append(symlinks, target)
+		case tar.TypeLink:
+			err = createHardlink(dir, target, header.Linkname, options)
+		default:
+			err = fmt.Errorf("archive entry %s has an unsupported type", header.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Drain the rest of the stream, eg. the padding of the archive, so the digest of the download can be verified.
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return err
+	}
+
+	return checkResolvedSymlinks(dir, symlinks)
+}
+
+func extractZip(reader io.Reader, dir string, options extractOptions) error {
+	// ZIP archives have their directory at the end, so they need random access.
+	spool, err := os.CreateTemp("", "tinyrepo-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, reader)
+	if err != nil {
+		return err
+	}
+
+	archive, err := zip.NewReader(spool, size)
+	if err != nil {
+		return err
+	}
+
+	symlinks := []string{}
+
+	for _, file := range archive.File {
+		name, ok := stripComponents(file.Name, options.StripComponents)
+		if !ok {
+			continue
+		}
+
+		target, err := entryPath(dir, name)
+		if err != nil {
+			return err
+		}
+
+		mode := file.Mode()
+
+		switch {
+		case mode.IsDir():
+			err = os.MkdirAll(target, 0755)
+		case mode.IsRegular():
+			err = extractZipFile(file, target)
+		case mode&os.ModeSymlink != 0:
+			err = extractZipSymlink(dir, file, target)
+			symlinks = append(symlinks, target)
+		default:
+			err = fmt.Errorf("archive entry %s has an unsupported type", file.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return checkResolvedSymlinks(dir, symlinks)
+}
+
+func extractZipFile(file *zip.File, target string) error {
+	content, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return writeEntry(target, content, file.Mode())
+}
+
+// extractZipSymlink creates a symlink, whose target is stored as content of the entry.
+func extractZipSymlink(dir string, file *zip.File, target string) error {
+	content, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	linkname, err := io.ReadAll(io.LimitReader(content, 4096))
+	if err != nil {
+		return err
+	}
+
+	return createSymlink(dir, target, string(linkname))
+}
+
+// writeEntry writes a regular file, keeping only the permission bits of the archive entry.
+func writeEntry(target string, content io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func createSymlink(dir string, target string, linkname string) error {
+	if err := checkSymlink(dir, target, linkname); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+
+	return os.Symlink(linkname, target)
+}
+
+// createHardlink links target to another entry of the archive.
+func createHardlink(dir string, target string, linkname string, options extractOptions) error {
+	name, ok := stripComponents(linkname, options.StripComponents)
+	if !ok {
+		return fmt.Errorf("hardlink %s points to a stripped entry %s", target, linkname)
+	}
+
+	source, err := entryPath(dir, name)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(source)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("hardlink %s must point to a regular file", target)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+
+	return os.Link(source, target)
+}
+
+// checkResolvedSymlinks makes sure, that chains of symlinks don't point outside of dir, once all of them exist.
+func checkResolvedSymlinks(dir string, symlinks []string) error {
+	if len(symlinks) == 0 {
+		return nil
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, symlink := range symlinks {
+		resolved, err := filepath.EvalSymlinks(symlink)
+		if errors.Is(err, os.ErrNotExist) {
+			// Dangling symlinks have been checked lexically already.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if resolved != root && !strings.HasPrefix(resolved, root+string(os.PathSeparator)) {
+			os.Remove(symlink)
+			return fmt.Errorf("symlink %s points outside of the target directory", symlink)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,8 @@
+package cmd
+
+import "golang.org/x/sys/unix"
+
+// exchangeDirs atomically swaps two directories, so neither of them is missing at any time.
+func exchangeDirs(a string, b string) error {
+	return unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package cmd
+
+import "errors"
+
+// exchangeDirs atomically swaps two directories, which is only supported on Linux.
+func exchangeDirs(a string, b string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,223 @@
+package cmd
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name     string
+	content  string
+	linkname string
+	typeflag byte
+}
+
+func fileEntry(name string, content string) testEntry {
+	return testEntry{name: name, content: content, typeflag: tar.TypeReg}
+}
+
+func symlinkEntry(name string, linkname string) testEntry {
+	return testEntry{name: name, linkname: linkname, typeflag: tar.TypeSymlink}
+}
+
+func hardlinkEntry(name string, linkname string) testEntry {
+	return testEntry{name: name, linkname: linkname, typeflag: tar.TypeLink}
+}
+
+func tarArchive(t *testing.T, entries ...testEntry) *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	writer := tar.NewWriter(buffer)
+
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Linkname: entry.linkname, Typeflag: entry.typeflag, Mode: 0644, Size: int64(len(entry.content))}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer
+}
+
+// zipArchive stores symlinks as entries with the symlink mode and the target as content, like the zip command.
+func zipArchive(t *testing.T, entries ...testEntry) *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	writer := zip.NewWriter(buffer)
+
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		content := entry.content
+
+		switch entry.typeflag {
+		case tar.TypeSymlink:
+			header.SetMode(os.ModeSymlink | 0777)
+			content = entry.linkname
+		case tar.TypeDir:
+			header.SetMode(os.ModeDir | 0755)
+		default:
+			header.SetMode(0644)
+		}
+
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer
+}
+
+func TestExtractRejectsEscapes(t *testing.T) {
+	cases := []struct {
+		name    string
+		entries []testEntry
+		zip     bool
+		message string
+	}{
+		{"zip-slip", []testEntry{fileEntry("../evil", "x")}, true, "escapes the target directory"},
+		{"tar-slip", []testEntry{fileEntry("a/../../evil", "x")}, false, "escapes the target directory"},
+		{"absolute path", []testEntry{fileEntry("/tmp/evil", "x")}, false, "absolute path"},
+		{"absolute symlink", []testEntry{symlinkEntry("link", "/etc")}, false, "absolute target"},
+		{"escaping symlink", []testEntry{symlinkEntry("a/link", "../..")}, true, "points outside"},
+		{"symlink parent", []testEntry{
+			{name: "sub/", typeflag: tar.TypeDir},
+			symlinkEntry("link", "sub"),
+			fileEntry("link/evil", "x"),
+		}, false, "below a symlink"},
+		{"zip symlink parent", []testEntry{
+			{name: "sub/", typeflag: tar.TypeDir},
+			symlinkEntry("link", "sub"),
+			fileEntry("link/evil", "x"),
+		}, true, "below a symlink"},
+		// Each symlink stays inside on its own, but c resolves to a/b/.. with a/b pointing to the root.
+		{"symlink chain", []testEntry{
+			{name: "a/", typeflag: tar.TypeDir},
+			symlinkEntry("a/b", ".."),
+			symlinkEntry("c", "a/b/.."),
+		}, false, "points outside"},
+		{"hardlink outside", []testEntry{hardlinkEntry("link", "../evil")}, false, "escapes the target directory"},
+		{"hardlink to symlink", []testEntry{
+			symlinkEntry("link", "."),
+			hardlinkEntry("hard", "link"),
+		}, false, "regular file"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "target")
+
+			archive := tarArchive(t, c.entries...)
+			if c.zip {
+				archive = zipArchive(t, c.entries...)
+			}
+
+			err := extractTo(archive, dir, extractOptions{})
+			if err == nil || !strings.Contains(err.Error(), c.message) {
+				t.Fatalf("expected an error containing %q, got %v", c.message, err)
+			}
+
+			if _, err := os.Lstat(filepath.Join(parent, "evil")); !os.IsNotExist(err) {
+				t.Error("expected nothing to be written outside of the target directory")
+			}
+			if _, err := os.Lstat(filepath.Join(dir, "c")); !os.IsNotExist(err) {
+				t.Error("expected the escaping symlink chain to be removed")
+			}
+		})
+	}
+}
+
+func TestExtractStripComponents(t *testing.T) {
+	for _, useZip := range []bool{false, true} {
+		entries := []testEntry{
+			fileEntry("README", "skipped"),
+			{name: "app-1.0/", typeflag: tar.TypeDir},
+			fileEntry("app-1.0/bin/app", "binary"),
+			symlinkEntry("app-1.0/current", "bin/app"),
+		}
+
+		archive := tarArchive(t, append(entries, hardlinkEntry("app-1.0/bin/copy", "app-1.0/bin/app"))...)
+		if useZip {
+			archive = zipArchive(t, entries...)
+		}
+
+		dir := t.TempDir()
+		if err := extractTo(archive, dir, extractOptions{StripComponents: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		names := []string{}
+		filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+			if path != dir {
+				relative, _ := filepath.Rel(dir, path)
+				names = append(names, filepath.ToSlash(relative))
+			}
+			return err
+		})
+
+		expected := "bin,bin/app,bin/copy,current"
+		if useZip {
+			expected = "bin,bin/app,current"
+		}
+
+		if strings.Join(names, ",") != expected {
+			t.Errorf("zip %t: expected %s, got %s", useZip, expected, strings.Join(names, ","))
+		}
+
+		if content, err := os.ReadFile(filepath.Join(dir, "current")); err != nil || string(content) != "binary" {
+			t.Errorf("zip %t: expected the symlink to resolve to the binary, got %q %v", useZip, content, err)
+		}
+	}
+}
+
+func TestExtractAtomic(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "target")
+
+	if err := extractTo(tarArchive(t, fileEntry("old", "1")), dir, extractOptions{Atomic: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed extraction keeps the previous content.
+	if err := extractTo(tarArchive(t, fileEntry("new", "2"), fileEntry("../evil", "x")), dir, extractOptions{Atomic: true}); err == nil {
+		t.Fatal("expected the extraction to fail")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "old")); err != nil {
+		t.Errorf("expected the previous content to be kept, got %v", err)
+	}
+
+	if err := extractTo(tarArchive(t, fileEntry("new", "2")), dir, extractOptions{Atomic: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(dir))
+	if len(entries) != 1 {
+		t.Errorf("expected only the target directory to be left, got %d entries", len(entries))
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
+		t.Error("expected the previous content to be replaced")
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sevensolutions/tiny-repo/client"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/spf13/cobra"
+)
+
+var infoJson bool
+
+type infoResult struct {
+	client.Metadata
+	Tags []string `json:"tags,omitempty"`
+}
+
+var infoCmd = &cobra.Command{
+	Use:   "info <namespace>/<name>[/<version>]",
+	Short: "Show the metadata of a version",
+	Long:  `Show the filename, content type, size, digest, labels and tags of a version. The version may be an exact version, latest (default), a tag or a constraint.`,
+	Args:  cobra.ExactArgs(1),
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		namespace, name, versionSpec, err := splitPullSpec(args[0])
+		if err != nil {
+			return err
+		}
+
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+
+		version, err := c.Resolve(ctx, namespace, name, versionSpec)
+		if err != nil {
+			return err
+		}
+
+		metadata, err := c.Metadata(ctx, namespace, name, version.String())
+		if err != nil {
+			return err
+		}
+
+		tags, err := tagsByVersion(c, namespace, name)
+		if err != nil {
+			return err
+		}
+
+		result := infoResult{Metadata: *metadata, Tags: tags[metadata.Version]}
+
+		if infoJson {
+			return printJson(result)
+		}
+
+		fmt.Printf("Artifact:     %s/%s\n", result.Namespace, result.Name)
+		fmt.Printf("Version:      %s\n", result.Version)
+		if len(result.Tags) > 0 {
+			fmt.Printf("Tags:         %s\n", strings.Join(result.Tags, ", "))
+		}
+		if result.Filename != "" {
+			fmt.Printf("Filename:     %s\n", result.Filename)
+		}
+		if result.ContentType != "" {
+			fmt.Printf("Content type: %s\n", result.ContentType)
+		}
+		fmt.Printf("Size:         %s (%d bytes)\n", core.ByteSize(result.Size), result.Size)
+		if result.Hash != "" {
+			fmt.Printf("Digest:       %s\n", result.Hash)
+		}
+
+		keys := make([]string, 0, len(result.Labels))
+		for key := range result.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Printf("Label:        %s=%s\n", key, result.Labels[key])
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	addClientFlags(infoCmd)
+
+	infoCmd.Flags().BoolVar(&infoJson, "json", false, "Print the result as JSON")
+
+	rootCmd.AddCommand(infoCmd)
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sevensolutions/tiny-repo/client"
+	"github.com/spf13/cobra"
+)
+
+var loginTokenStdin bool
+var whoamiJson bool
+
+var loginCmd = &cobra.Command{
+	Use:   "login <server>",
+	Short: "Store a token for a server",
+	Long: `Verify a token and store it for the server in the credentials file of the user.
+The server becomes the default server of all client commands. Without --token or --token-stdin, the token is prompted for.`,
+	Args: cobra.ExactArgs(1),
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		address = normalizeAddress(args[0])
+
+		if loginTokenStdin {
+			content, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			token = strings.TrimSpace(string(content))
+		} else if token == "" {
+			fmt.Fprint(os.Stderr, "Token: ")
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return err
+			}
+			token = strings.TrimSpace(line)
+		}
+
+		if token == "" {
+			return errors.New("missing token")
+		}
+
+		identity, err := fetchWhoami()
+		if err != nil {
+			return fmt.Errorf("login to %s failed: %w", address, err)
+		}
+
+		store, err := loadCredentialStore()
+		if err != nil {
+			return err
+		}
+
+		store.Servers[address] = serverCredential{Token: token}
+		store.Default = address
+
+		if err := store.save(); err != nil {
+			return err
+		}
+
+		fmt.Printf("Logged in to %s as %s\n", address, identity.Name)
+
+		return nil
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout [server]",
+	Short: "Remove the stored token of a server",
+	Long:  `Remove the stored token of a server, by default of the default server`,
+	Args:  cobra.MaximumNArgs(1),
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := loadCredentialStore()
+		if err != nil {
+			return err
+		}
+
+		server := store.Default
+		if len(args) > 0 {
+			server = normalizeAddress(args[0])
+		}
+
+		if _, ok := store.Servers[server]; !ok {
+			return fmt.Errorf("not logged in to %s", server)
+		}
+
+		delete(store.Servers, server)
+		if store.Default == server {
+			store.Default = ""
+		}
+
+		if err := store.save(); err != nil {
+			return err
+		}
+
+		fmt.Printf("Logged out of %s\n", server)
+
+		return nil
+	},
+}
+
+var whoamiCmd = &cobra.Command{
+	Use:   "whoami",
+	Short: "Show the identity and access of the current token",
+	Long:  `Show the identity and access of the current token, as seen by the server`,
+	Args:  cobra.NoArgs,
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, err := serverAddress(); err != nil {
+			return err
+		}
+
+		identity, err := fetchWhoami()
+		if err != nil {
+			return err
+		}
+
+		if whoamiJson {
+			content, _ := json.MarshalIndent(identity, "", "  ")
+			fmt.Println(string(content))
+			return nil
+		}
+
+		fmt.Printf("Server:      %s\n", address)
+		fmt.Printf("Name:        %s\n", identity.Name)
+		if identity.TokenId != "" {
+			fmt.Printf("Token id:    %s\n", identity.TokenId)
+		}
+		if identity.Issuer != "" {
+			fmt.Printf("Issuer:      %s\n", identity.Issuer)
+		}
+		if identity.ExpiresAt != nil {
+			fmt.Printf("Expires:     %s (in %s)\n", identity.ExpiresAt.Local().Format(time.RFC3339), formatDuration(time.Until(*identity.ExpiresAt)))
+		}
+		if identity.Namespace != "" {
+			fmt.Printf("Namespace:   %s\n", identity.Namespace)
+		}
+		if len(identity.Grants) > 0 {
+			for _, grant := range identity.Grants {
+				fmt.Printf("Grant:       %s\n", grant)
+			}
+		} else {
+			fmt.Printf("Prefix:      %s\n", identity.Prefix)
+			fmt.Printf("Permissions: %s\n", strings.Join(identity.Permissions, ", "))
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	loginCmd.Flags().StringVar(&token, "token", "", "The token to store. Prefer --token-stdin, to keep it out of the shell history")
+	loginCmd.Flags().BoolVar(&loginTokenStdin, "token-stdin", false, "Read the token from stdin")
+
+	addClientFlags(whoamiCmd)
+	whoamiCmd.Flags().BoolVar(&whoamiJson, "json", false, "Print the result as JSON")
+
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
+	rootCmd.AddCommand(whoamiCmd)
+}
+
+func fetchWhoami() (*client.Identity, error) {
+	c, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Whoami(context.Background())
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestLogin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_whoami" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{"name": "ci"})
+	}))
+	defer server.Close()
+
+	useTestServer(t, "")
+
+	login := func(value string) error {
+		token = value
+		return loginCmd.RunE(loginCmd, []string{server.URL + "/"})
+	}
+
+	if err := login("wrong"); err == nil {
+		t.Error("expected the login with an invalid token to fail")
+	}
+
+	store, _ := loadCredentialStore()
+	if len(store.Servers) != 0 {
+		t.Errorf("expected the invalid token not to be stored, got %+v", store)
+	}
+
+	if err := login("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	store, _ = loadCredentialStore()
+	if store.Default != server.URL || store.Servers[server.URL].Token != "secret" {
+		t.Errorf("expected the token to be stored for the normalized address, got %+v", store)
+	}
+
+	path, _ := credentialStorePath()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the store to be readable by the user only, got %v %v", info.Mode(), err)
+	}
+
+	if err := logoutCmd.RunE(logoutCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	store, _ = loadCredentialStore()
+	if store.Default != "" || len(store.Servers) != 0 {
+		t.Errorf("expected the token to be removed, got %+v", store)
+	}
+
+	if err := logoutCmd.RunE(logoutCmd, []string{server.URL}); err == nil {
+		t.Error("expected the logout of an unknown server to fail")
+	}
+}
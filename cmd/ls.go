@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/client"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/spf13/cobra"
+)
+
+var lsJson bool
+var lsQuiet bool
+var lsLong bool
+var lsConstraint string
+var lsLimit int
+
+var lsCmd = &cobra.Command{
+	Use:     "ls [<namespace>[/<name>]]",
+	Aliases: []string{"versions"},
+	Short:   "List namespaces, artifacts or versions",
+	Long: `Without arguments, list the namespaces. Given a namespace, list its artifacts and given an artifact, list its versions, newest first.
+Only what the token can read is listed.`,
+	Example: `  tinyrepo ls
+  tinyrepo ls foo
+  tinyrepo ls foo/bar --constraint "^1.2" --long
+  tinyrepo ls foo/bar --quiet --limit 1`,
+	Args: cobra.MaximumNArgs(1),
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		namespace, name := "", ""
+		if len(args) > 0 {
+			namespace, name, _ = strings.Cut(strings.Trim(args[0], "/"), "/")
+		}
+
+		if lsConstraint != "" && name == "" {
+			return errors.New("--constraint can only be used when listing the versions of an artifact")
+		}
+
+		switch {
+		case namespace == "":
+			return listNamespaces(c)
+		case name == "":
+			return listArtifacts(c, namespace)
+		default:
+			return listVersions(c, namespace, name)
+		}
+	},
+}
+
+func init() {
+	addClientFlags(lsCmd)
+
+	lsCmd.Flags().BoolVar(&lsJson, "json", false, "Print the result as JSON")
+	lsCmd.Flags().BoolVarP(&lsQuiet, "quiet", "q", false, "Only print the names or versions, one per line")
+	lsCmd.Flags().BoolVarP(&lsLong, "long", "l", false, "Include the size and digest of every version")
+	lsCmd.Flags().StringVarP(&lsConstraint, "constraint", "c", "", "Only list versions matching a constraint, eg. ^1.2")
+	lsCmd.Flags().IntVarP(&lsLimit, "limit", "n", 0, "List at most this number of entries")
+
+	rootCmd.AddCommand(lsCmd)
+}
+
+func listNamespaces(c *client.Client) error {
+	namespaces, err := c.Namespaces(context.Background())
+	if err != nil {
+		return err
+	}
+
+	namespaces = limitEntries(namespaces)
+
+	if lsJson {
+		return printJson(namespaces)
+	}
+
+	for _, namespace := range namespaces {
+		fmt.Println(namespace)
+	}
+
+	return nil
+}
+
+func listArtifacts(c *client.Client, namespace string) error {
+	artifacts, err := c.Artifacts(context.Background(), namespace)
+	if err != nil {
+		return err
+	}
+
+	artifacts = limitEntries(artifacts)
+
+	if lsJson {
+		return printJson(artifacts)
+	}
+
+	if lsQuiet {
+		for _, artifact := range artifacts {
+			fmt.Println(artifact.Name)
+		}
+		return nil
+	}
+
+	table := newTable()
+	fmt.Fprintln(table, "NAME\tLATEST\tVERSIONS")
+	for _, artifact := range artifacts {
+		fmt.Fprintf(table, "%s\t%s\t%d\n", artifact.Name, artifact.Latest, artifact.Versions)
+	}
+
+	return table.Flush()
+}
+
+type versionEntry struct {
+	Version  string   `json:"version"`
+	Tags     []string `json:"tags,omitempty"`
+	Filename string   `json:"filename,omitempty"`
+	Size     int64    `json:"size,omitempty"`
+	Hash     string   `json:"hash,omitempty"`
+}
+
+func listVersions(c *client.Client, namespace string, name string) error {
+	ctx := context.Background()
+
+	var versions []*semver.Version
+	var err error
+
+	if lsConstraint != "" {
+		versions, err = c.Filter(ctx, namespace, name, lsConstraint)
+	} else {
+		versions, err = c.Versions(ctx, namespace, name)
+	}
+	if err != nil {
+		return err
+	}
+
+	versions = limitEntries(versions)
+
+	if lsQuiet {
+		for _, version := range versions {
+			fmt.Println(version)
+		}
+		return nil
+	}
+
+	tags, err := tagsByVersion(c, namespace, name)
+	if err != nil {
+		return err
+	}
+
+	entries := []versionEntry{}
+
+	for _, version := range versions {
+		entry := versionEntry{Version: version.String(), Tags: tags[version.String()]}
+
+		if lsLong {
+			metadata, err := c.Metadata(ctx, namespace, name, version.String())
+			if err != nil {
+				return err
+			}
+
+			entry.Filename = metadata.Filename
+			entry.Size = metadata.Size
+			entry.Hash = metadata.Hash
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if lsJson {
+		return printJson(entries)
+	}
+
+	table := newTable()
+
+	if lsLong {
+		fmt.Fprintln(table, "VERSION\tTAGS\tSIZE\tFILENAME\tDIGEST")
+	} else {
+		fmt.Fprintln(table, "VERSION\tTAGS")
+	}
+
+	for _, entry := range entries {
+		if lsLong {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", entry.Version, strings.Join(entry.Tags, ","), core.ByteSize(entry.Size), entry.Filename, entry.Hash)
+		} else {
+			fmt.Fprintf(table, "%s\t%s\n", entry.Version, strings.Join(entry.Tags, ","))
+		}
+	}
+
+	return table.Flush()
+}
+
+// tagsByVersion returns the sorted tags of every version. Servers without tag support have no tags.
+func tagsByVersion(c *client.Client, namespace string, name string) (map[string][]string, error) {
+	tags, err := c.Tags(context.Background(), namespace, name)
+
+	var responseErr *client.Error
+	if errors.As(err, &responseErr) && responseErr.StatusCode == http.StatusNotImplemented {
+		return map[string][]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string][]string{}
+	for tag, version := range tags {
+		result[version] = append(result[version], tag)
+	}
+	for _, versionTags := range result {
+		sort.Strings(versionTags)
+	}
+
+	return result, nil
+}
+
+func limitEntries[T any](entries []T) []T {
+	if lsLimit > 0 && len(entries) > lsLimit {
+		return entries[:lsLimit]
+	}
+
+	return entries
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+}
+
+func printJson(value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(content))
+
+	return nil
+}
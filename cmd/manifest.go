@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// manifest lists the artifacts installed by tinyrepo sync.
+type manifest struct {
+	// Server is used, if no address is given by flag or environment variable.
+	Server    string             `yaml:"server,omitempty" toml:"server,omitempty"`
+	Artifacts []manifestArtifact `yaml:"artifacts" toml:"artifacts"`
+}
+
+type manifestArtifact struct {
+	// Artifact is namespace/name.
+	Artifact string `yaml:"artifact" toml:"artifact"`
+	// Version is an exact version, latest (default), a tag or a constraint.
+	Version string `yaml:"version,omitempty" toml:"version,omitempty"`
+	// Path is the destination file, or the destination directory when extracting. It is relative to the manifest.
+	Path    string `yaml:"path" toml:"path"`
+	Extract bool   `yaml:"extract,omitempty" toml:"extract,omitempty"`
+}
+
+// lockfile pins the artifacts of a manifest to exact versions and digests.
+type lockfile struct {
+	Artifacts []lockedArtifact `yaml:"artifacts"`
+}
+
+type lockedArtifact struct {
+	Artifact   string `yaml:"artifact"`
+	Constraint string `yaml:"constraint"`
+	Version    string `yaml:"version"`
+	Digest     string `yaml:"digest"`
+	Path       string `yaml:"path"`
+	Extract    bool   `yaml:"extract,omitempty"`
+}
+
+// matches checks whether a locked artifact still corresponds to an entry of the manifest.
+func (l lockedArtifact) matches(entry manifestArtifact) bool {
+	return l.Artifact == entry.Artifact && l.Constraint == entry.Version && l.Path == entry.Path && l.Extract == entry.Extract
+}
+
+// loadManifest reads a YAML manifest, or a TOML manifest if the file ends with .toml.
+func loadManifest(path string) (*manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &manifest{}
+
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(content, result)
+	} else {
+		err = yaml.Unmarshal(content, result)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+
+	paths := map[string]bool{}
+
+	for i := range result.Artifacts {
+		entry := &result.Artifacts[i]
+
+		if entry.Version == "" {
+			entry.Version = "latest"
+		}
+
+		if namespace, name, found := strings.Cut(entry.Artifact, "/"); !found || namespace == "" || name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid artifact %q in the manifest, expected namespace/name", entry.Artifact)
+		}
+
+		if entry.Path == "" {
+			return nil, fmt.Errorf("missing path of %s in the manifest", entry.Artifact)
+		}
+
+		clean := filepath.Clean(entry.Path)
+		if paths[clean] {
+			return nil, fmt.Errorf("the path %s is used more than once in the manifest", entry.Path)
+		}
+		paths[clean] = true
+	}
+
+	return result, nil
+}
+
+// lockfilePath returns the lockfile next to the manifest, eg. tinyrepo.lock for tinyrepo.yaml.
+func lockfilePath(manifestPath string) string {
+	return strings.TrimSuffix(manifestPath, filepath.Ext(manifestPath)) + ".lock"
+}
+
+// loadLockfile returns nil, if the lockfile doesn't exist.
+func loadLockfile(path string) (*lockfile, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &lockfile{}
+	if err := yaml.Unmarshal(content, result); err != nil {
+		return nil, fmt.Errorf("invalid lockfile %s: %w", path, err)
+	}
+
+	return result, nil
+}
+
+func (l *lockfile) save(path string) error {
+	content, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	content = append([]byte("# Generated by tinyrepo sync, don't edit this file.\n"), content...)
+
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
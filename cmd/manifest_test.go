@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+
+	os.WriteFile(filepath.Join(dir, "tinyrepo.yaml"), []byte(`server: https://repo.example.com
+artifacts:
+  - artifact: tools/cli
+    version: ^1.0
+    path: bin/cli
+  - artifact: tools/docs
+    path: docs
+    extract: true
+`), 0644)
+
+	os.WriteFile(filepath.Join(dir, "tinyrepo.toml"), []byte(`server = "https://repo.example.com"
+
+[[artifacts]]
+artifact = "tools/cli"
+version = "^1.0"
+path = "bin/cli"
+
+[[artifacts]]
+artifact = "tools/docs"
+path = "docs"
+extract = true
+`), 0644)
+
+	yamlManifest, err := loadManifest(filepath.Join(dir, "tinyrepo.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tomlManifest, err := loadManifest(filepath.Join(dir, "tinyrepo.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(yamlManifest, tomlManifest) {
+		t.Errorf("expected both formats to be read the same, got %+v and %+v", yamlManifest, tomlManifest)
+	}
+
+	if yamlManifest.Artifacts[1].Version != "latest" {
+		t.Errorf("expected the version to default to latest, got %q", yamlManifest.Artifacts[1].Version)
+	}
+
+	cases := []struct {
+		manifest string
+		message  string
+	}{
+		{"artifacts:\n  - artifact: cli\n    path: cli\n", "expected namespace/name"},
+		{"artifacts:\n  - artifact: tools/cli/x\n    path: cli\n", "expected namespace/name"},
+		{"artifacts:\n  - artifact: tools/cli\n", "missing path"},
+		{"artifacts:\n  - artifact: tools/cli\n    path: bin/cli\n  - artifact: tools/other\n    path: bin/../bin/cli\n", "more than once"},
+		{"artifacts: [", "invalid manifest"},
+	}
+
+	for i, c := range cases {
+		path := filepath.Join(dir, "invalid.yaml")
+		os.WriteFile(path, []byte(c.manifest), 0644)
+
+		if _, err := loadManifest(path); err == nil || !strings.Contains(err.Error(), c.message) {
+			t.Errorf("case %d: expected an error containing %q, got %v", i, c.message, err)
+		}
+	}
+}
+
+func TestLockfileRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := lockfilePath(filepath.Join(dir, "tinyrepo.yaml"))
+
+	if path != filepath.Join(dir, "tinyrepo.lock") {
+		t.Errorf("unexpected lockfile path %s", path)
+	}
+
+	if locked, err := loadLockfile(path); locked != nil || err != nil {
+		t.Fatalf("expected no lockfile, got %+v %v", locked, err)
+	}
+
+	locked := &lockfile{Artifacts: []lockedArtifact{
+		{Artifact: "tools/cli", Constraint: "^1.0", Version: "1.1.0", Digest: "sha256:abc", Path: "bin/cli"},
+		{Artifact: "tools/docs", Constraint: "latest", Version: "2.0.0", Digest: "sha256:def", Path: "docs", Extract: true},
+	}}
+
+	if err := locked.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := loadLockfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(locked, loaded) {
+		t.Errorf("expected %+v, got %+v", locked, loaded)
+	}
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("expected the temporary file to be renamed")
+	}
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sevensolutions/tiny-repo/client"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/spf13/cobra"
+)
+
+var nextPatch bool
+var nextMinor bool
+var nextMajor bool
+var nextPrerelease string
+var nextDryRun bool
+
+var nextCmd = &cobra.Command{
+	Use:     "next <namespace>/<name>",
+	Aliases: []string{"bump"},
+	Short:   "Print the next free version of an artifact",
+	Long: `Compute the next free version of an artifact and print it. By default, the version is reserved on the server,
+so concurrent pipelines never get the same version. The patch version is incremented, unless --minor or --major is given.
+With --prerelease, the next numbered prerelease is printed, eg. 1.3.0-rc.2.`,
+	Example: `  VERSION=$(tinyrepo next foo/bar --minor)
+  tinyrepo next foo/bar --prerelease rc
+  tinyrepo next foo/bar --major --dry-run`,
+	Args: cobra.ExactArgs(1),
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		namespace, name, versionSpec, err := splitPullSpec(args[0])
+		if err != nil {
+			return err
+		}
+		if versionSpec != "latest" {
+			return fmt.Errorf("invalid artifact %s, expected <namespace>/<name>", args[0])
+		}
+
+		bump := core.Bump("")
+		for flag, value := range map[core.Bump]bool{core.BumpPatch: nextPatch, core.BumpMinor: nextMinor, core.BumpMajor: nextMajor} {
+			if value && bump != "" {
+				return errors.New("only one of --patch, --minor and --major can be given")
+			}
+			if value {
+				bump = flag
+			}
+		}
+
+		if err := core.ValidatePrereleaseId(nextPrerelease); err != nil {
+			return err
+		}
+
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+
+		if nextDryRun {
+			versions, err := c.Versions(ctx, namespace, name)
+			if err != nil && !errors.Is(err, client.ErrNotFound) {
+				return err
+			}
+
+			next, err := core.NextVersion(versions, bump, nextPrerelease)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println(next)
+			return nil
+		}
+
+		reservation, err := c.Reserve(ctx, namespace, name, string(bump), nextPrerelease)
+		if err != nil {
+			return fmt.Errorf("failed to reserve the next version: %w", err)
+		}
+
+		fmt.Println(reservation.Version)
+
+		return nil
+	},
+}
+
+func init() {
+	addClientFlags(nextCmd)
+
+	nextCmd.Flags().BoolVar(&nextPatch, "patch", false, "Increment the patch version (default)")
+	nextCmd.Flags().BoolVar(&nextMinor, "minor", false, "Increment the minor version")
+	nextCmd.Flags().BoolVar(&nextMajor, "major", false, "Increment the major version")
+	nextCmd.Flags().StringVar(&nextPrerelease, "prerelease", "", "Compute the next prerelease with this id, eg. rc")
+	nextCmd.Flags().BoolVar(&nextDryRun, "dry-run", false, "Only compute the version from the existing versions, without reserving it")
+
+	rootCmd.AddCommand(nextCmd)
+}
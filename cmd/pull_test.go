@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+func TestSplitPullSpec(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected string
+		valid    bool
+	}{
+		{"tools/cli", "tools cli latest", true},
+		{"tools/cli/", "tools cli latest", true},
+		{"tools/cli/1.2.3", "tools cli 1.2.3", true},
+		{"tools/cli/^1.2", "tools cli ^1.2", true},
+		{"tools", "", false},
+		{"/cli", "", false},
+		{"tools//1.0.0", "", false},
+	}
+
+	for _, c := range cases {
+		namespace, name, version, err := splitPullSpec(c.value)
+		if (err == nil) != c.valid {
+			t.Errorf("%s: expected valid %t, got %v", c.value, c.valid, err)
+			continue
+		}
+		if got := strings.Join([]string{namespace, name, version}, " "); c.valid && got != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.value, c.expected, got)
+		}
+	}
+}
+
+func TestPullReplacesFiles(t *testing.T) {
+	server, _ := fakeRepository(t, map[string]string{"tools/cli/1.0.0": "cli 1.0.0"})
+	useTestServer(t, server.URL)
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "cli")
+	os.WriteFile(target, []byte("old"), 0644)
+
+	pullOutput = target
+	t.Cleanup(func() { pullOutput, pullForce = "", false })
+
+	pull := func() error {
+		return pullCmd.RunE(pullCmd, []string{"tools/cli/1.0.0"})
+	}
+
+	if err := pull(); err == nil || !strings.Contains(err.Error(), "use --force") {
+		t.Errorf("expected the existing file to be kept, got %v", err)
+	}
+	if content, _ := os.ReadFile(target); string(content) != "old" {
+		t.Errorf("expected the file not to be overwritten, got %q", content)
+	}
+
+	pullForce = true
+	if err := pull(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(target); string(content) != "cli 1.0.0" {
+		t.Errorf("expected the file to be replaced, got %q", content)
+	}
+
+	// A corrupted download never replaces the target.
+	corrupted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(core.HeaderContentHash, "sha256:0000")
+		w.Write([]byte("corrupted"))
+	}))
+	defer corrupted.Close()
+
+	address = corrupted.URL
+
+	if err := pull(); err == nil {
+		t.Error("expected the digest mismatch to fail the pull")
+	}
+	if content, _ := os.ReadFile(target); string(content) != "cli 1.0.0" {
+		t.Errorf("expected the previous file to be kept, got %q", content)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected the temporary file to be removed, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sevensolutions/tiny-repo/client"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/spf13/cobra"
+)
+
+var pushKeep int
+var pushContentType string
+var pushFilename string
+var pushLabels []string
+
+var pushCmd = &cobra.Command{
+	Use:   "push <namespace>/<name>/<version> <file>",
+	Short: "Push an artifact",
+	Long:  `Push a file as a new version of an artifact. The server verifies the sha256 hash of the uploaded content.`,
+	Args:  cobra.ExactArgs(2),
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		spec, err := core.ParseVersionSpec(args[0])
+		if err != nil {
+			return err
+		}
+		if spec.Latest {
+			return errors.New("pushing to latest is not allowed, specify a version")
+		}
+
+		labels, err := core.ParseLabels(pushLabels)
+		if err != nil {
+			return err
+		}
+
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		filePath := args[1]
+
+		file, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory", filePath)
+		}
+
+		hash, err := hashFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		filename := pushFilename
+		if filename == "" {
+			filename = filepath.Base(filePath)
+		}
+
+		// The progress reader is seekable, so the client can send the file again on retries.
+		content := newProgressReader(file, info.Size(), "Pushing "+filename)
+
+		_, err = c.Push(context.Background(), spec.Namespace, spec.Name, spec.Version, content, client.PushOptions{
+			Filename:    filename,
+			ContentType: pushContentType,
+			Labels:      labels,
+			Keep:        pushKeep,
+			Hash:        hash,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to push %s: %w", args[0], err)
+		}
+
+		fmt.Printf("Pushed %s/%s/%s (%s)\n", spec.Namespace, spec.Name, spec.Version, hash)
+
+		return nil
+	},
+}
+
+func init() {
+	addClientFlags(pushCmd)
+
+	pushCmd.Flags().IntVar(&pushKeep, "keep", 0, "Delete older versions, so only this number of versions is kept")
+	pushCmd.Flags().StringVar(&pushContentType, "content-type", "", "The content type (default based on the file extension)")
+	pushCmd.Flags().StringVar(&pushFilename, "filename", "", "The filename used for downloads (default the name of the file)")
+	pushCmd.Flags().StringArrayVar(&pushLabels, "label", nil, "A label in the form key=value. May be repeated")
+
+	rootCmd.AddCommand(pushCmd)
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// progressReader prints the progress of a transfer to stderr. total is -1, if the size is unknown.
+type progressReader struct {
+	io.ReadCloser
+	label    string
+	total    int64
+	done     int64
+	reported int64
+	finished bool
+}
+
+func newProgressReader(reader io.ReadCloser, total int64, label string) *progressReader {
+	return &progressReader{ReadCloser: reader, label: label, total: total, reported: -1}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.done += int64(n)
+
+	if p.finished {
+		return n, err
+	}
+
+	complete := err == io.EOF || (p.total > 0 && p.done >= p.total)
+
+	if p.total > 0 {
+		if percent := p.done * 100 / p.total; percent != p.reported || complete {
+			p.reported = percent
+			fmt.Fprintf(os.Stderr, "\r%s %3d%% (%d/%d bytes)", p.label, percent, p.done, p.total)
+		}
+	} else if p.done-p.reported >= 1<<20 || complete {
+		p.reported = p.done
+		fmt.Fprintf(os.Stderr, "\r%s %d bytes", p.label, p.done)
+	}
+
+	if complete {
+		p.finished = true
+		fmt.Fprintln(os.Stderr)
+	}
+
+	return n, err
+}
+
+// Seek restarts the progress, if the underlying reader is seekable.
+func (p *progressReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := p.ReadCloser.(io.Seeker)
+	if !ok {
+		return 0, errors.New("the content isn't seekable")
+	}
+
+	position, err := seeker.Seek(offset, whence)
+	if err == nil {
+		p.done = position
+		p.reported = -1
+		p.finished = false
+	}
+
+	return position, err
+}
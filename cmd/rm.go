@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/client"
+	"github.com/spf13/cobra"
+)
+
+var rmDryRun bool
+var rmYes bool
+
+var rmCmd = &cobra.Command{
+	Use:   "rm <namespace>/<name>/<version>",
+	Short: "Delete versions of an artifact",
+	Long: `Delete a version of an artifact. The version may be an exact version, latest, a tag or a constraint like <1.0.0,
+which deletes all matching versions. The versions are listed and have to be confirmed, unless --yes is given.`,
+	Example: `  tinyrepo rm foo/bar/1.0.0
+  tinyrepo rm "foo/bar/<1.0.0" --dry-run
+  tinyrepo rm "foo/bar/1.x" --yes`,
+	Args: cobra.ExactArgs(1),
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		parts := strings.SplitN(args[0], "/", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return fmt.Errorf("invalid artifact %s, expected <namespace>/<name>/<version>", args[0])
+		}
+		namespace, name, versionSpec := parts[0], parts[1], parts[2]
+
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+
+		versions, err := matchingVersions(ctx, c, namespace, name, versionSpec)
+		if err != nil {
+			return err
+		}
+
+		if len(versions) == 0 {
+			return fmt.Errorf("no version of %s/%s matches %s", namespace, name, versionSpec)
+		}
+
+		if rmDryRun {
+			for _, version := range versions {
+				fmt.Printf("Would delete %s/%s/%s\n", namespace, name, version)
+			}
+			return nil
+		}
+
+		for _, version := range versions {
+			fmt.Fprintf(os.Stderr, "%s/%s/%s\n", namespace, name, version)
+		}
+
+		if err := confirm(fmt.Sprintf("Delete %d version(s) of %s/%s?", len(versions), namespace, name), rmYes); err != nil {
+			return err
+		}
+
+		for _, version := range versions {
+			if err := c.Delete(ctx, namespace, name, version); err != nil {
+				return fmt.Errorf("failed to delete %s/%s/%s: %w", namespace, name, version, err)
+			}
+
+			fmt.Printf("Deleted %s/%s/%s\n", namespace, name, version)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	addClientFlags(rmCmd)
+
+	rmCmd.Flags().BoolVar(&rmDryRun, "dry-run", false, "Only list the versions which would be deleted")
+	rmCmd.Flags().BoolVarP(&rmYes, "yes", "y", false, "Don't ask for confirmation")
+
+	rootCmd.AddCommand(rmCmd)
+}
+
+// matchingVersions returns the existing versions matching a version, latest, a tag or a constraint.
+func matchingVersions(ctx context.Context, c *client.Client, namespace string, name string, spec string) ([]*semver.Version, error) {
+	if client.IsConstraint(spec) {
+		return c.Filter(ctx, namespace, name, spec)
+	}
+
+	version, err := c.Resolve(ctx, namespace, name, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := c.Versions(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, existing := range versions {
+		if existing.Equal(version) {
+			return []*semver.Version{existing}, nil
+		}
+	}
+
+	return []*semver.Version{}, nil
+}
+
+// confirm asks the user to confirm a destructive action on the terminal.
+// Without a terminal, the action must be confirmed using --yes.
+func confirm(question string, yes bool) error {
+	if yes {
+		return nil
+	}
+
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return errors.New("confirmation required, use --yes to confirm without a terminal")
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+
+	return errors.New("aborted")
+}
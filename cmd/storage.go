@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/sevensolutions/tiny-repo/storage"
+	"github.com/spf13/cobra"
+)
+
+var storageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Maintain the storage backend",
+	Long:  `Maintain the storage backend`,
+}
+
+var storageRotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "Re-wrap all data keys with the current encryption key",
+	Long: `Re-wrap all data keys with the current encryption key.
+
+Configure the new key as STORAGE_ENCRYPTION_KEY and the old one as STORAGE_ENCRYPTION_PREVIOUS_KEY,
+then run this command. Afterwards the previous key can be removed.
+Only the data keys are re-wrapped, the blobs themselves are not re-encrypted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		adapter := storage.LocalDirectory()
+
+		rotated, err := adapter.RotateEncryptionKeys()
+		if err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("Re-wrapped the data keys of %d blobs.\n", rotated)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(storageCmd)
+
+	storageCmd.AddCommand(storageRotateKeyCmd)
+}
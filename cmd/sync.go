@@ -0,0 +1,325 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/client"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/spf13/cobra"
+)
+
+// syncMarkerFile is written into extracted directories, to detect whether they are up to date.
+const syncMarkerFile = ".tinyrepo-digest"
+
+var syncManifest string
+var syncFrozen bool
+var syncParallel int
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Install the artifacts of a manifest",
+	Long: `Resolve the artifacts listed in a manifest (tinyrepo.yaml or tinyrepo.toml) against the server,
+write the exact versions and digests to a lockfile next to it and download them in parallel.
+Files and directories, which already match the locked digest, are skipped.
+With --frozen, the artifacts are installed strictly from the lockfile.`,
+	Example: `  tinyrepo sync
+  tinyrepo sync -f deploy/tinyrepo.yaml --frozen`,
+	Args: cobra.NoArgs,
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifestPath := syncManifest
+		if manifestPath == "" {
+			manifestPath = findManifest()
+		}
+
+		m, err := loadManifest(manifestPath)
+		if err != nil {
+			return err
+		}
+
+		if address == "" && core.GetEnvVar("TINYREPO_ADDRESS", "") == "" {
+			address = m.Server
+		}
+
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		lockPath := lockfilePath(manifestPath)
+
+		var locked *lockfile
+		if syncFrozen {
+			locked, err = frozenLockfile(m, lockPath)
+		} else {
+			locked, err = resolveManifest(ctx, c, m)
+		}
+		if err != nil {
+			return err
+		}
+
+		baseDir := filepath.Dir(manifestPath)
+
+		if err := installAll(ctx, c, locked, baseDir); err != nil {
+			return err
+		}
+
+		if !syncFrozen {
+			return locked.save(lockPath)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	addClientFlags(syncCmd)
+
+	syncCmd.Flags().StringVarP(&syncManifest, "file", "f", "", "The manifest (default tinyrepo.yaml, tinyrepo.yml or tinyrepo.toml)")
+	syncCmd.Flags().BoolVar(&syncFrozen, "frozen", false, "Install strictly from the lockfile and fail, if it doesn't match the manifest")
+	syncCmd.Flags().IntVar(&syncParallel, "parallel", 4, "The number of parallel downloads")
+
+	rootCmd.AddCommand(syncCmd)
+}
+
+func findManifest() string {
+	for _, name := range []string{"tinyrepo.yaml", "tinyrepo.yml", "tinyrepo.toml"} {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+
+	return "tinyrepo.yaml"
+}
+
+// resolveManifest resolves every artifact of the manifest to an exact version and its digest.
+func resolveManifest(ctx context.Context, c *client.Client, m *manifest) (*lockfile, error) {
+	locked := &lockfile{Artifacts: make([]lockedArtifact, len(m.Artifacts))}
+
+	err := forEachParallel(len(m.Artifacts), func(i int) error {
+		entry := m.Artifacts[i]
+		namespace, name, _ := strings.Cut(entry.Artifact, "/")
+
+		version, err := c.Resolve(ctx, namespace, name, entry.Version)
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s %s: %w", entry.Artifact, entry.Version, err)
+		}
+
+		metadata, err := c.Metadata(ctx, namespace, name, version.String())
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s %s: %w", entry.Artifact, entry.Version, err)
+		}
+
+		if metadata.Hash == "" {
+			return fmt.Errorf("the server has no digest of %s/%s", entry.Artifact, version)
+		}
+
+		locked.Artifacts[i] = lockedArtifact{
+			Artifact:   entry.Artifact,
+			Constraint: entry.Version,
+			Version:    version.String(),
+			Digest:     metadata.Hash,
+			Path:       entry.Path,
+			Extract:    entry.Extract,
+		}
+
+		return nil
+	})
+
+	return locked, err
+}
+
+// frozenLockfile loads the lockfile and makes sure it contains exactly the artifacts of the manifest.
+func frozenLockfile(m *manifest, path string) (*lockfile, error) {
+	locked, err := loadLockfile(path)
+	if err != nil {
+		return nil, err
+	}
+	if locked == nil {
+		return nil, fmt.Errorf("the lockfile %s doesn't exist, run tinyrepo sync without --frozen first", path)
+	}
+
+	outdated := len(locked.Artifacts) != len(m.Artifacts)
+
+	for _, entry := range m.Artifacts {
+		found := false
+		for _, l := range locked.Artifacts {
+			if l.matches(entry) {
+				found = true
+				break
+			}
+		}
+		outdated = outdated || !found
+	}
+
+	if outdated {
+		return nil, fmt.Errorf("the lockfile %s doesn't match the manifest, run tinyrepo sync without --frozen", path)
+	}
+
+	return locked, nil
+}
+
+func installAll(ctx context.Context, c *client.Client, locked *lockfile, baseDir string) error {
+	return forEachParallel(len(locked.Artifacts), func(i int) error {
+		l := locked.Artifacts[i]
+
+		target := l.Path
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(baseDir, target)
+		}
+
+		upToDate, err := isInstalled(l, target)
+		if err != nil {
+			return err
+		}
+		if upToDate {
+			fmt.Printf("Up to date %s %s (%s)\n", l.Artifact, l.Version, l.Path)
+			return nil
+		}
+
+		if err := install(ctx, c, l, target); err != nil {
+			return fmt.Errorf("failed to install %s %s: %w", l.Artifact, l.Version, err)
+		}
+
+		fmt.Printf("Installed %s %s (%s)\n", l.Artifact, l.Version, l.Path)
+
+		return nil
+	})
+}
+
+// isInstalled checks whether the target file has the locked digest, or was extracted from an archive with the locked digest.
+func isInstalled(l lockedArtifact, target string) (bool, error) {
+	path := target
+	if l.Extract {
+		path = filepath.Join(target, syncMarkerFile)
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+
+	if l.Extract {
+		content, err := os.ReadFile(path)
+		return strings.TrimSpace(string(content)) == l.Digest, err
+	}
+
+	hash, err := hashFile(path)
+
+	return hash == l.Digest, err
+}
+
+// install downloads a locked artifact into a temporary file and verifies its digest,
+// before it replaces the target file or is extracted to the target directory.
+func install(ctx context.Context, c *client.Client, l lockedArtifact, target string) error {
+	namespace, name, _ := strings.Cut(l.Artifact, "/")
+
+	version, err := semver.NewVersion(l.Version)
+	if err != nil {
+		return err
+	}
+
+	download, err := c.Pull(ctx, namespace, name, version.String())
+	if err != nil {
+		return err
+	}
+	defer download.Body.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	defer temp.Close()
+
+	hasher := sha256.New()
+
+	if _, err := io.Copy(io.MultiWriter(temp, hasher), download.Body); err != nil {
+		return err
+	}
+
+	if hash := fmt.Sprintf("sha256:%x", hasher.Sum(nil)); hash != l.Digest {
+		return fmt.Errorf("%w: the lockfile expects %s but got %s", client.ErrDigestMismatch, l.Digest, hash)
+	}
+
+	if !l.Extract {
+		if err := temp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(temp.Name(), target)
+	}
+
+	if _, err := temp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// Extract next to the target first, so a failed extraction keeps the previous files.
+	extracted, err := os.MkdirTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(extracted)
+
+	if err := os.Chmod(extracted, 0755); err != nil {
+		return err
+	}
+
+	if err := extractArchive(temp, extracted, extractOptions{}); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(extracted, syncMarkerFile), []byte(l.Digest+"\n"), 0644); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+
+	return os.Rename(extracted, target)
+}
+
+// forEachParallel calls f for the indexes 0 to n-1, running up to --parallel calls at the same time.
+// It returns the errors of all calls, after they have finished.
+func forEachParallel(n int, f func(i int) error) error {
+	limit := syncParallel
+	if limit < 1 {
+		limit = 1
+	}
+
+	semaphore := make(chan struct{}, limit)
+	errs := make([]error, n)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		semaphore <- struct{}{}
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			errs[i] = f(i)
+		}(i)
+	}
+
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// useTestServer points the client flags to the address, without any token or stored credentials.
+func useTestServer(t *testing.T, serverAddress string) {
+	t.Setenv("TINYREPO_ADDRESS", "")
+	t.Setenv("TINYREPO_TOKEN", "")
+	t.Setenv("TINYREPO_TOKEN_FILE", "")
+	t.Setenv("TINYREPO_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials.json"))
+
+	address, token, tokenFile, retries = serverAddress, "", "", 0
+
+	t.Cleanup(func() {
+		address, token, tokenFile, retries = "", "", "", 0
+	})
+}
+
+// fakeRepository serves the versions, metadata and blobs of the given artifacts, keyed by namespace/name/version.
+// downloads counts the downloaded blobs.
+func fakeRepository(t *testing.T, blobs map[string]string) (server *httptest.Server, downloads *atomic.Int32) {
+	downloads = &atomic.Int32{}
+
+	hashOf := func(content string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+	}
+
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(r.URL.Path, "/")
+
+		if content, ok := blobs[path]; ok {
+			downloads.Add(1)
+			w.Header().Set(core.HeaderContentHash, hashOf(content))
+			w.Write([]byte(content))
+			return
+		}
+
+		if version, found := strings.CutSuffix(path, "/_meta"); found {
+			if content, ok := blobs[version]; ok {
+				json.NewEncoder(w).Encode(map[string]string{"hash": hashOf(content)})
+				return
+			}
+		}
+
+		versions := []*semver.Version{}
+		for key := range blobs {
+			if v, found := strings.CutPrefix(key, path+"/"); found {
+				versions = append(versions, semver.MustParse(v))
+			}
+		}
+		if len(versions) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		sort.Sort(sort.Reverse(semver.Collection(versions)))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"latest":   versions[0].String(),
+			"versions": core.MapArray(versions, func(v *semver.Version) string { return v.String() }),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server, downloads
+}
+
+func TestSync(t *testing.T) {
+	docs := tarArchive(t, fileEntry("index.html", "docs")).String()
+
+	server, downloads := fakeRepository(t, map[string]string{
+		"tools/cli/1.0.0":  "cli 1.0.0",
+		"tools/cli/1.1.0":  "cli 1.1.0",
+		"tools/cli/2.0.0":  "cli 2.0.0",
+		"tools/docs/3.0.0": docs,
+	})
+	useTestServer(t, server.URL)
+
+	dir := t.TempDir()
+	manifestPath := filepath.Join(dir, "tinyrepo.yaml")
+
+	os.WriteFile(manifestPath, []byte(`artifacts:
+  - artifact: tools/cli
+    version: ^1.0
+    path: bin/cli
+  - artifact: tools/docs
+    path: docs
+    extract: true
+`), 0644)
+
+	syncManifest, syncFrozen, syncParallel = manifestPath, false, 2
+	t.Cleanup(func() { syncManifest, syncFrozen, syncParallel = "", false, 4 })
+
+	if err := syncCmd.RunE(syncCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := os.ReadFile(filepath.Join(dir, "bin", "cli")); string(content) != "cli 1.1.0" {
+		t.Errorf("expected the newest matching version, got %q", content)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "docs", "index.html")); string(content) != "docs" {
+		t.Errorf("expected the archive to be extracted, got %q", content)
+	}
+
+	locked, err := loadLockfile(filepath.Join(dir, "tinyrepo.lock"))
+	if err != nil || locked == nil || len(locked.Artifacts) != 2 {
+		t.Fatalf("expected a lockfile with both artifacts, got %+v %v", locked, err)
+	}
+	if l := locked.Artifacts[0]; l.Version != "1.1.0" || l.Digest != fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("cli 1.1.0"))) {
+		t.Errorf("unexpected locked artifact %+v", l)
+	}
+
+	// Installed artifacts are skipped, also when installing from the lockfile.
+	syncFrozen = true
+	if err := syncCmd.RunE(syncCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if count := downloads.Load(); count != 2 {
+		t.Errorf("expected the installed artifacts not to be downloaded again, got %d downloads", count)
+	}
+
+	// A changed manifest doesn't match the lockfile anymore.
+	os.WriteFile(manifestPath, []byte(`artifacts:
+  - artifact: tools/cli
+    version: ^2.0
+    path: bin/cli
+  - artifact: tools/docs
+    path: docs
+    extract: true
+`), 0644)
+
+	if err := syncCmd.RunE(syncCmd, nil); err == nil || !strings.Contains(err.Error(), "doesn't match the manifest") {
+		t.Errorf("expected the frozen sync to fail, got %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "bin", "cli")); string(content) != "cli 1.1.0" {
+		t.Errorf("expected the failed sync to keep the installed version, got %q", content)
+	}
+
+	syncFrozen = false
+	if err := syncCmd.RunE(syncCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(dir, "bin", "cli")); string(content) != "cli 2.0.0" {
+		t.Errorf("expected the updated version, got %q", content)
+	}
+
+	os.Remove(filepath.Join(dir, "tinyrepo.lock"))
+	syncFrozen = true
+	if err := syncCmd.RunE(syncCmd, nil); err == nil || !strings.Contains(err.Error(), "doesn't exist") {
+		t.Errorf("expected the frozen sync to fail without a lockfile, got %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/spf13/cobra"
+)
+
+var tagDelete bool
+
+var tagCmd = &cobra.Command{
+	Use:   "tag <namespace>/<name>[/<version>] <tag>",
+	Short: "Tag a version of an artifact",
+	Long: `Point a tag to a version of an artifact. The version may be an exact version, latest (default), another tag or a constraint.
+Use --delete to remove a tag.`,
+	Args: cobra.ExactArgs(2),
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		namespace, name, versionSpec, err := splitPullSpec(args[0])
+		if err != nil {
+			return err
+		}
+
+		tag := args[1]
+		if err := core.ValidateTag(tag); err != nil {
+			return err
+		}
+
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+
+		if tagDelete {
+			if err := c.DeleteTag(ctx, namespace, name, tag); err != nil {
+				return fmt.Errorf("failed to delete the tag %s: %w", tag, err)
+			}
+
+			fmt.Printf("Deleted tag %s of %s/%s\n", tag, namespace, name)
+			return nil
+		}
+
+		version, err := c.Resolve(ctx, namespace, name, versionSpec)
+		if err != nil {
+			return err
+		}
+
+		if err := c.SetTag(ctx, namespace, name, tag, version); err != nil {
+			return fmt.Errorf("failed to set the tag %s: %w", tag, err)
+		}
+
+		fmt.Printf("Tagged %s/%s/%s as %s\n", namespace, name, version, tag)
+
+		return nil
+	},
+}
+
+func init() {
+	addClientFlags(tagCmd)
+
+	tagCmd.Flags().BoolVar(&tagDelete, "delete", false, "Delete the tag")
+
+	rootCmd.AddCommand(tagCmd)
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/spf13/cobra"
+)
+
+var tidyKeep int
+var tidyDryRun bool
+var tidyYes bool
+
+var tidyCmd = &cobra.Command{
+	Use:   "tidy <namespace>/<name> --keep <n>",
+	Short: "Delete all but the newest versions of an artifact",
+	Long: `Delete all but the newest n versions of an artifact on the server.
+The versions are listed and have to be confirmed, unless --yes is given.`,
+	Example: `  tinyrepo tidy foo/bar --keep 5 --dry-run
+  tinyrepo tidy foo/bar --keep 5 --yes`,
+	Args: cobra.ExactArgs(1),
+
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if tidyKeep <= 0 {
+			return errors.New("--keep must be at least 1")
+		}
+
+		namespace, name, versionSpec, err := splitPullSpec(args[0])
+		if err != nil {
+			return err
+		}
+		if versionSpec != "latest" {
+			return fmt.Errorf("invalid artifact %s, expected <namespace>/<name>", args[0])
+		}
+
+		c, err := newClient()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+
+		if tidyYes && !tidyDryRun {
+			result, err := c.Tidy(ctx, namespace, name, tidyKeep, false)
+			if err != nil {
+				return err
+			}
+
+			for _, version := range result.Deleted {
+				fmt.Printf("Deleted %s/%s/%s\n", namespace, name, version)
+			}
+			return nil
+		}
+
+		plan, err := c.Tidy(ctx, namespace, name, tidyKeep, true)
+		if err != nil {
+			return err
+		}
+
+		if len(plan.Deleted) == 0 {
+			fmt.Printf("Nothing to delete, %s/%s has %d version(s)\n", namespace, name, len(plan.Kept))
+			return nil
+		}
+
+		if tidyDryRun {
+			for _, version := range plan.Deleted {
+				fmt.Printf("Would delete %s/%s/%s\n", namespace, name, version)
+			}
+			return nil
+		}
+
+		for _, version := range plan.Deleted {
+			fmt.Fprintf(os.Stderr, "%s/%s/%s\n", namespace, name, version)
+		}
+
+		if err := confirm(fmt.Sprintf("Delete %d version(s) of %s/%s, keeping %d?", len(plan.Deleted), namespace, name, len(plan.Kept)), false); err != nil {
+			return err
+		}
+
+		// Only the confirmed versions are deleted, even if versions have been pushed in the meantime.
+		for _, value := range plan.Deleted {
+			version, err := semver.NewVersion(value)
+			if err != nil {
+				return err
+			}
+
+			if err := c.Delete(ctx, namespace, name, version); err != nil {
+				return fmt.Errorf("failed to delete %s/%s/%s: %w", namespace, name, version, err)
+			}
+
+			fmt.Printf("Deleted %s/%s/%s\n", namespace, name, version)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	addClientFlags(tidyCmd)
+
+	tidyCmd.Flags().IntVar(&tidyKeep, "keep", 0, "The number of versions to keep")
+	tidyCmd.Flags().BoolVar(&tidyDryRun, "dry-run", false, "Only list the versions which would be deleted")
+	tidyCmd.Flags().BoolVarP(&tidyYes, "yes", "y", false, "Don't ask for confirmation")
+
+	tidyCmd.MarkFlagRequired("keep")
+
+	rootCmd.AddCommand(tidyCmd)
+}
@@ -1,18 +1,31 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/sevensolutions/tiny-repo/middleware"
+	"github.com/sevensolutions/tiny-repo/server"
 	"github.com/spf13/cobra"
 )
 
 var namespace string
 var name string
 var prefix string
+var permissions []string
+var apiKeyPermissions []string
+var expiresIn string
+var notBefore string
+var audience []string
+var issuer string
+var grants []string
 
 var tokenCmd = &cobra.Command{
 	Use:   "token",
@@ -30,18 +43,79 @@ var tokenCreateCmd = &cobra.Command{
 			name = "Unknown"
 		}
 		if prefix == "" {
-			name = "/"
+			prefix = "/"
 		}
 		if !strings.HasPrefix(prefix, "/") {
 			prefix = "/" + prefix
 		}
+		if issuer == "" {
+			issuer = core.GetEnvVar("JWT_ISSUER", "")
+		}
+		if len(audience) == 0 && core.GetEnvVar("JWT_AUDIENCE", "") != "" {
+			audience = []string{core.GetEnvVar("JWT_AUDIENCE", "")}
+		}
 
-		jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"namespace": namespace,
-			"name":      name,
-			"prefix":    prefix,
-			"iat":       time.Now().Unix(),
-		})
+		parsedPermissions, err := core.ParsePermissions(permissions)
+		if err != nil {
+			panic(err)
+		}
+
+		now := time.Now()
+
+		claims := jwt.MapClaims{
+			"namespace":   namespace,
+			"name":        name,
+			"prefix":      prefix,
+			"permissions": parsedPermissions,
+			"iat":         now.Unix(),
+			"jti":         core.RandomId(),
+		}
+
+		// Grants replace prefix and permissions.
+		if len(grants) > 0 {
+			parsedGrants := []core.Grant{}
+			for _, value := range grants {
+				grant, err := core.ParseGrant(value)
+				if err != nil {
+					panic(err)
+				}
+				parsedGrants = append(parsedGrants, grant)
+			}
+
+			claims["grants"] = parsedGrants
+			delete(claims, "prefix")
+			delete(claims, "permissions")
+		}
+
+		if expiresIn != "" {
+			duration, err := parseDuration(expiresIn)
+			if err != nil {
+				panic(err)
+			}
+			claims["exp"] = now.Add(duration).Unix()
+		} else if core.GetEnvVarBool("JWT_REQUIRE_EXPIRY", false) {
+			panic("JWT_REQUIRE_EXPIRY is enabled, so --expires-in is required")
+		}
+
+		if notBefore != "" {
+			nbf, err := parseTimeOrDuration(notBefore, now)
+			if err != nil {
+				panic(err)
+			}
+			claims["nbf"] = nbf.Unix()
+		}
+
+		if len(audience) == 1 {
+			claims["aud"] = audience[0]
+		} else if len(audience) > 1 {
+			claims["aud"] = audience
+		}
+
+		if issuer != "" {
+			claims["iss"] = issuer
+		}
+
+		jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
 		secret := []byte(core.GetRequiredEnvVar("JWT_SECRET"))
 
@@ -69,30 +143,204 @@ var tokenInspectCmd = &cobra.Command{
 			return secret, nil
 		})
 
-		if err != nil {
+		var validationError *jwt.ValidationError
+		if err != nil && !errors.As(err, &validationError) {
 			panic(err)
 		}
 
 		if jwtToken.Valid {
 			println("The token is valid!")
 		} else {
-			println("The token is invalid!")
+			println("The token is invalid: " + err.Error())
 		}
 
 		println("Claims:")
-		for key, val := range claims {
-			fmt.Printf("%v: %v\n", key, val)
+
+		keys := make([]string, 0, len(claims))
+		for key := range claims {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fmt.Printf("%v: %v\n", key, claims[key])
+		}
+
+		println()
+
+		now := time.Now()
+
+		if iat, ok := claimTime(claims, "iat"); ok {
+			fmt.Printf("Issued:     %s (%s ago)\n", iat.Format(time.RFC3339), formatDuration(now.Sub(iat)))
+		}
+
+		if nbf, ok := claimTime(claims, "nbf"); ok {
+			if nbf.After(now) {
+				fmt.Printf("Not before: %s (in %s)\n", nbf.Format(time.RFC3339), formatDuration(nbf.Sub(now)))
+			} else {
+				fmt.Printf("Not before: %s\n", nbf.Format(time.RFC3339))
+			}
+		}
+
+		if exp, ok := claimTime(claims, "exp"); ok {
+			if exp.After(now) {
+				fmt.Printf("Expires:    %s (in %s)\n", exp.Format(time.RFC3339), formatDuration(exp.Sub(now)))
+			} else {
+				fmt.Printf("Expired:    %s (%s ago)\n", exp.Format(time.RFC3339), formatDuration(now.Sub(exp)))
+			}
+		} else {
+			println("Expires:    never")
 		}
 	},
 }
 
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <token|id>",
+	Short: "Revoke an access token",
+	Long:  `Revoke an access token, either by passing the token itself or its id (jti claim)`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		revokedToken := middleware.RevokedToken{Id: args[0]}
+
+		if strings.Count(args[0], ".") == 2 {
+			var err error
+			revokedToken, err = middleware.RevokedTokenFromJWT(args[0], middleware.LoadTrustedIssuers().KeyFunc([]byte(core.GetEnvVar("JWT_SECRET", ""))))
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		revocations := middleware.Revocations(server.CreateStorageAdapter())
+
+		if err := revocations.Revoke(revokedToken); err != nil {
+			panic(err)
+		}
+
+		fmt.Printf("Revoked token %s\n", revokedToken.Id)
+	},
+}
+
+var tokenApiKeyCmd = &cobra.Command{
+	Use:   "api-key",
+	Short: "Generate a new API key",
+	Long:  `Generate a new API key and the entry to add to the API_KEYS_FILE`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if name == "" {
+			name = "Unknown"
+		}
+
+		key := "tr_" + core.RandomId()
+
+		apiKey := middleware.ApiKey{
+			Name: name,
+			Key:  middleware.HashApiKey(key),
+			Access: middleware.Access{
+				Namespace:   namespace,
+				Prefix:      prefix,
+				Permissions: apiKeyPermissions,
+			},
+		}
+
+		if len(grants) > 0 {
+			apiKey.Permissions = nil
+			for _, value := range grants {
+				grant, err := core.ParseGrant(value)
+				if err != nil {
+					panic(err)
+				}
+				apiKey.Grants = append(apiKey.Grants, grant)
+			}
+		}
+
+		if err := apiKey.Validate(); err != nil {
+			panic(fmt.Sprintf("%s, use --prefix / to grant access to all artifacts", err))
+		}
+
+		entry, _ := json.MarshalIndent(apiKey, "", "  ")
+
+		fmt.Printf("API key: %s\n\nAdd the following entry to the API_KEYS_FILE:\n%s\n", key, entry)
+	},
+}
+
+// parseDuration extends time.ParseDuration by days, eg. 30d.
+func parseDuration(value string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %s", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// parseTimeOrDuration accepts either an RFC3339 timestamp or a duration relative to now.
+func parseTimeOrDuration(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	duration, err := parseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s, expected an RFC3339 timestamp or a duration", value)
+	}
+
+	return now.Add(duration), nil
+}
+
+func claimTime(claims jwt.MapClaims, key string) (time.Time, bool) {
+	switch value := claims[key].(type) {
+	case float64:
+		return time.Unix(int64(value), 0), true
+	case json.Number:
+		n, err := value.Int64()
+		return time.Unix(n, 0), err == nil
+	}
+
+	return time.Time{}, false
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh", days, hours)
+	}
+	if hours > 0 {
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+
+	return fmt.Sprintf("%dm", minutes)
+}
+
 func init() {
 	rootCmd.AddCommand(tokenCmd)
 
 	tokenCreateCmd.PersistentFlags().StringVar(&namespace, "namespace", "", "The namespace the token should have access to")
 	tokenCreateCmd.PersistentFlags().StringVar(&name, "name", "", "The name for the token")
 	tokenCreateCmd.PersistentFlags().StringVar(&prefix, "prefix", "", "The prefix the token should have access to")
+	tokenCreateCmd.PersistentFlags().StringVar(&expiresIn, "expires-in", "", "The lifetime of the token, eg. 12h or 30d. By default the token never expires")
+	tokenCreateCmd.PersistentFlags().StringVar(&notBefore, "not-before", "", "The token is not valid before this RFC3339 timestamp or duration from now")
+	tokenCreateCmd.PersistentFlags().StringSliceVar(&audience, "audience", nil, "The audience of the token (default JWT_AUDIENCE)")
+	tokenCreateCmd.PersistentFlags().StringVar(&issuer, "issuer", "", "The issuer of the token (default JWT_ISSUER)")
+	tokenCreateCmd.PersistentFlags().StringArrayVar(&grants, "grant", nil, "Grants access to artifacts matching a pattern, eg. team/*:read,write. May be repeated and replaces --prefix and --permissions")
+	tokenCreateCmd.PersistentFlags().StringSliceVar(&permissions, "permissions", []string{"read", "write", "delete"}, "The permissions of the token: read, write, delete and/or admin")
+
+	tokenApiKeyCmd.Flags().StringVar(&namespace, "namespace", "", "The namespace the key should have access to")
+	tokenApiKeyCmd.Flags().StringVar(&name, "name", "", "The name for the key")
+	tokenApiKeyCmd.Flags().StringVar(&prefix, "prefix", "", "The prefix the key should have access to")
+	tokenApiKeyCmd.Flags().StringArrayVar(&grants, "grant", nil, "Grants access to artifacts matching a pattern, eg. team/*:read,write. May be repeated and replaces --prefix and --permissions")
+	tokenApiKeyCmd.Flags().StringSliceVar(&apiKeyPermissions, "permissions", []string{"read"}, "The permissions of the key: read, write, delete and/or admin")
 
 	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenApiKeyCmd)
 	tokenCmd.AddCommand(tokenInspectCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
 }
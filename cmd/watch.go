@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/sevensolutions/tiny-repo/client"
+)
+
+// watchWait is how long the server may hold a request, before it responds that nothing has changed.
+const watchWait = time.Minute
+
+// watchState is stored next to the target, so a restarted watch doesn't download the installed version again.
+type watchState struct {
+	Artifact  string    `json:"artifact"`
+	Spec      string    `json:"spec"`
+	Version   string    `json:"version"`
+	Digest    string    `json:"digest"`
+	ETag      string    `json:"etag,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func watchStatePath(target string) string {
+	if pullStateFile != "" {
+		return pullStateFile
+	}
+
+	return filepath.Join(filepath.Dir(filepath.Clean(target)), "."+filepath.Base(target)+".tinyrepo-state.json")
+}
+
+// loadWatchState returns the stored state or an empty state, if there is none.
+func loadWatchState(path string) (*watchState, error) {
+	state := &watchState{}
+
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+
+	return state, nil
+}
+
+func (s *watchState) save(path string) error {
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp, path)
+}
+
+// watch keeps the target up to date with the version matching spec, until it is interrupted.
+// It uses long-polling, if the server supports it, and polls in the --interval otherwise.
+func watch(c *client.Client, namespace string, name string, spec string, target string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	artifact := namespace + "/" + name
+	statePath := watchStatePath(target)
+
+	state, err := loadWatchState(statePath)
+	if err != nil {
+		return err
+	}
+
+	// The state is outdated, if the target has been removed or the artifact has changed.
+	if _, err := os.Stat(target); err != nil || state.Artifact != artifact || state.Spec != spec {
+		state = &watchState{}
+	}
+
+	if state.Version != "" {
+		fmt.Printf("Watching %s/%s, %s is installed\n", artifact, spec, state.Version)
+	} else {
+		fmt.Printf("Watching %s/%s\n", artifact, spec)
+	}
+
+	for {
+		started := time.Now()
+
+		changed, err := update(ctx, c, namespace, name, spec, target, state, statePath)
+
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case errors.Is(err, client.ErrUnauthorized), errors.Is(err, client.ErrForbidden):
+			return err
+		case errors.Is(err, client.ErrNotModified):
+		case err != nil:
+			fmt.Fprintln(os.Stderr, "Warning:", err)
+			started = time.Now()
+		case changed:
+			continue
+		}
+
+		// Servers without long-polling respond immediately, so the interval applies.
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(pullInterval - time.Since(started)):
+		}
+	}
+}
+
+// update installs the version matching spec, if it differs from the installed version.
+// It reports whether the state has changed, which doesn't happen for servers without conditional requests.
+func update(ctx context.Context, c *client.Client, namespace string, name string, spec string, target string, state *watchState, statePath string) (bool, error) {
+	metadata, etag, err := c.WatchMetadata(ctx, namespace, name, spec, state.ETag, watchWait)
+	if err != nil {
+		return false, err
+	}
+
+	if metadata.Version == state.Version && metadata.Hash == state.Digest {
+		if etag == state.ETag {
+			return false, nil
+		}
+
+		// Something else, like the labels, has changed.
+		state.ETag = etag
+		return true, state.save(statePath)
+	}
+
+	download, err := c.Pull(ctx, namespace, name, metadata.Version)
+	if err != nil {
+		return false, err
+	}
+	defer download.Body.Close()
+
+	if pullExtract != "" {
+		err = extractDownload(download, target)
+	} else {
+		err = writeDownload(download, target)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update %s: %w", target, err)
+	}
+
+	digest := download.Hash
+	if digest == "" {
+		digest = metadata.Hash
+	}
+
+	*state = watchState{
+		Artifact:  namespace + "/" + name,
+		Spec:      spec,
+		Version:   download.Version.String(),
+		Digest:    digest,
+		ETag:      etag,
+		UpdatedAt: time.Now().UTC(),
+	}
+
+	if err := state.save(statePath); err != nil {
+		return false, err
+	}
+
+	fmt.Printf("Updated %s to %s/%s/%s\n", target, namespace, name, state.Version)
+
+	if pullExec == "" {
+		return true, nil
+	}
+
+	if err := runHook(ctx, state, target); err != nil {
+		return true, fmt.Errorf("the --exec command failed: %w", err)
+	}
+
+	return true, nil
+}
+
+// runHook runs the --exec command using the shell, passing the update in environment variables.
+func runHook(ctx context.Context, state *watchState, target string) error {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	hook := exec.CommandContext(ctx, shell, flag, pullExec)
+	hook.Stdout = os.Stdout
+	hook.Stderr = os.Stderr
+	hook.Env = append(os.Environ(),
+		"TINYREPO_ARTIFACT="+state.Artifact,
+		"TINYREPO_VERSION="+state.Version,
+		"TINYREPO_DIGEST="+state.Digest,
+		"TINYREPO_TARGET="+target,
+	)
+
+	return hook.Run()
+}
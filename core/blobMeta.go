@@ -1,7 +1,48 @@
 package core
 
+import (
+	"fmt"
+	"strings"
+)
+
+// HeaderContentHash contains the sha256 hash of a blob, eg. sha256:2c26b4..., on uploads and downloads.
+const HeaderContentHash = "X-Content-Hash"
+
 type BlobMeta struct {
-	OriginalFilename string `json:"originalFilename"`
-	ContentType      string `json:"contentType"`
-	Hash             string `json:"hash"`
+	OriginalFilename string            `json:"originalFilename"`
+	ContentType      string            `json:"contentType"`
+	Hash             string            `json:"hash"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Size             int64             `json:"size,omitempty"`
+	ContentEncoding  string            `json:"contentEncoding,omitempty"`
+	Encryption       *EncryptionMeta   `json:"encryption,omitempty"`
+}
+
+type EncryptionMeta struct {
+	Algorithm  string `json:"algorithm"`
+	ChunkSize  int    `json:"chunkSize"`
+	KeyId      string `json:"keyId"`
+	WrappedKey string `json:"wrappedKey"`
+}
+
+// ParseLabels parses labels in the form key=value.
+func ParseLabels(values []string) (map[string]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	labels := map[string]string{}
+
+	for _, value := range values {
+		key, labelValue, found := strings.Cut(value, "=")
+		key = strings.TrimSpace(key)
+
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid label %s, expected key=value", value)
+		}
+
+		labels[key] = strings.TrimSpace(labelValue)
+	}
+
+	return labels, nil
 }
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+type Bump string
+
+const (
+	BumpPatch Bump = "patch"
+	BumpMinor Bump = "minor"
+	BumpMajor Bump = "major"
+)
+
+var prereleaseIdPattern = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+
+func ParseBump(value string) (Bump, error) {
+	switch bump := Bump(strings.ToLower(value)); bump {
+	case BumpPatch, BumpMinor, BumpMajor:
+		return bump, nil
+	case "":
+		return "", nil
+	}
+
+	return "", fmt.Errorf("invalid bump %s, expected patch, minor or major", value)
+}
+
+// ValidatePrereleaseId checks an id like rc or beta, which is numbered by NextVersion. An empty id is valid.
+func ValidatePrereleaseId(id string) error {
+	if id != "" && !prereleaseIdPattern.MatchString(id) {
+		return fmt.Errorf("invalid prerelease id %s", id)
+	}
+
+	return nil
+}
+
+// NextVersion computes the next free version after the highest of the taken versions.
+// Without a prerelease id, the bump defaults to patch, and a prerelease, eg. 1.3.0-rc.2, is released as 1.3.0.
+// With a prerelease id like rc, the next prerelease is numbered, eg. 1.3.0-rc.3. Then the bump, if any, is applied to the highest release.
+func NextVersion(taken []*semver.Version, bump Bump, prerelease string) (*semver.Version, error) {
+	if err := ValidatePrereleaseId(prerelease); err != nil {
+		return nil, err
+	}
+
+	highest := semver.New(0, 0, 0, "", "")
+	highestRelease := semver.New(0, 0, 0, "", "")
+
+	for _, v := range taken {
+		if v.GreaterThan(highest) {
+			highest = v
+		}
+		if v.Prerelease() == "" && v.GreaterThan(highestRelease) {
+			highestRelease = v
+		}
+	}
+
+	if prerelease == "" {
+		next := bumpVersion(*highest, bump)
+		return &next, nil
+	}
+
+	var base semver.Version
+	switch {
+	case bump != "":
+		base = bumpVersion(*highestRelease, bump)
+	case highest.Prerelease() != "":
+		base = *semver.New(highest.Major(), highest.Minor(), highest.Patch(), "", "")
+	default:
+		base = bumpVersion(*highest, BumpPatch)
+	}
+
+	// Continue the numbering of existing prereleases of the same version and id.
+	number := 1
+	for _, v := range taken {
+		if v.Major() != base.Major() || v.Minor() != base.Minor() || v.Patch() != base.Patch() {
+			continue
+		}
+
+		id, value, found := strings.Cut(v.Prerelease(), ".")
+		if n, err := strconv.Atoi(value); found && id == prerelease && err == nil && n >= number {
+			number = n + 1
+		}
+	}
+
+	next := semver.New(base.Major(), base.Minor(), base.Patch(), prerelease+"."+strconv.Itoa(number), "")
+
+	return next, nil
+}
+
+func bumpVersion(v semver.Version, bump Bump) semver.Version {
+	switch bump {
+	case BumpMajor:
+		return v.IncMajor()
+	case BumpMinor:
+		return v.IncMinor()
+	default:
+		return v.IncPatch()
+	}
+}
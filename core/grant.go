@@ -0,0 +1,80 @@
+package core
+
+import (
+	"fmt"
+	ospath "path"
+	"strings"
+)
+
+// Grant allows access to all artifacts matching a glob pattern, eg. team/* or team/app-*.
+// A pattern with a single segment matches all artifacts of the matching namespaces.
+type Grant struct {
+	Pattern     string       `json:"pattern"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// ParseGrant parses a grant in the form pattern:permission,permission, eg. team/*:read,write.
+func ParseGrant(value string) (Grant, error) {
+	pattern, rawPermissions, found := strings.Cut(value, ":")
+	if !found || pattern == "" {
+		return Grant{}, fmt.Errorf("invalid grant %s, expected pattern:permissions", value)
+	}
+
+	permissions, err := ParsePermissions(strings.Split(rawPermissions, ","))
+	if err != nil {
+		return Grant{}, err
+	}
+
+	grant := Grant{Pattern: strings.Trim(pattern, "/"), Permissions: permissions}
+
+	if err := grant.Validate(); err != nil {
+		return Grant{}, err
+	}
+
+	return grant, nil
+}
+
+// String formats the grant the way ParseGrant expects it.
+func (g Grant) String() string {
+	return g.Pattern + ":" + strings.Join(MapArray(g.Permissions, func(p Permission) string { return string(p) }), ",")
+}
+
+func (g Grant) Validate() error {
+	segments := strings.Split(g.Pattern, "/")
+
+	if len(segments) > 2 {
+		return fmt.Errorf("invalid grant pattern %s, expected namespace or namespace/name", g.Pattern)
+	}
+
+	for _, segment := range segments {
+		if _, err := ospath.Match(segment, ""); err != nil || segment == "" {
+			return fmt.Errorf("invalid grant pattern %s", g.Pattern)
+		}
+	}
+
+	return nil
+}
+
+// Matches checks whether the grant covers the artifact. Each segment of the pattern is matched separately.
+func (g Grant) Matches(namespace string, name string) bool {
+	segments := strings.Split(g.Pattern, "/")
+
+	if matched, _ := ospath.Match(segments[0], namespace); !matched {
+		return false
+	}
+
+	if len(segments) == 1 {
+		return true
+	}
+
+	matched, _ := ospath.Match(segments[1], name)
+
+	return matched
+}
+
+// MatchesNamespace checks whether the grant covers any artifact of the namespace.
+func (g Grant) MatchesNamespace(namespace string) bool {
+	matched, _ := ospath.Match(strings.Split(g.Pattern, "/")[0], namespace)
+
+	return matched
+}
@@ -75,6 +75,9 @@ func ParseVersionSpecFromEcho(c echo.Context) (ArtifactVersionSpec, error) {
 
 func ParseArtifactSpec(value string) (ArtifactSpec, error) {
 	parts := strings.Split(value, "/")
+	if len(parts) < 2 {
+		return ArtifactSpec{}, fmt.Errorf("invalid artifact %s, expected <namespace>/<name>", value)
+	}
 
 	namespace := parts[0]
 	name := parts[1]
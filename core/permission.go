@@ -0,0 +1,55 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Permission string
+
+const (
+	PermissionRead   Permission = "read"
+	PermissionWrite  Permission = "write"
+	PermissionDelete Permission = "delete"
+	PermissionAdmin  Permission = "admin"
+)
+
+// DefaultPermissions are granted to tokens without a permissions claim, which were issued before permissions existed.
+var DefaultPermissions = []Permission{PermissionRead, PermissionWrite, PermissionDelete}
+
+func ParsePermission(value string) (Permission, error) {
+	permission := Permission(strings.ToLower(strings.TrimSpace(value)))
+
+	switch permission {
+	case PermissionRead, PermissionWrite, PermissionDelete, PermissionAdmin:
+		return permission, nil
+	}
+
+	return "", fmt.Errorf("invalid permission %s", value)
+}
+
+func ParsePermissions(values []string) ([]Permission, error) {
+	permissions := []Permission{}
+
+	for _, value := range values {
+		permission, err := ParsePermission(value)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions = append(permissions, permission)
+	}
+
+	return permissions, nil
+}
+
+// HasPermission checks whether the required permission is granted. The admin permission implies all others.
+func HasPermission(granted []Permission, required Permission) bool {
+	for _, p := range granted {
+		if p == required || p == PermissionAdmin {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes, which can be written with a unit in JSON, eg. "10GB" or "512MiB".
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"B", 1},
+}
+
+func ParseByteSize(value string) (ByteSize, error) {
+	value = strings.TrimSpace(value)
+
+	factor := int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(strings.ToUpper(value), strings.ToUpper(unit.suffix)) {
+			factor = unit.factor
+			value = strings.TrimSpace(value[:len(value)-len(unit.suffix)])
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %s", value)
+	}
+
+	return ByteSize(number * float64(factor)), nil
+}
+
+func (s *ByteSize) UnmarshalJSON(data []byte) error {
+	var number int64
+	if err := json.Unmarshal(data, &number); err == nil {
+		*s = ByteSize(number)
+		return nil
+	}
+
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+
+	*s = size
+	return nil
+}
+
+// String formats the size with a binary unit, eg. 1.5 MiB.
+func (s ByteSize) String() string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+
+	if s < 1024 {
+		return fmt.Sprintf("%d B", int64(s))
+	}
+
+	value := float64(s)
+	unit := ""
+	for _, unit = range units {
+		value /= 1024
+		if value < 1024 {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%.1f %s", value, unit)
+}
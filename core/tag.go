@@ -0,0 +1,39 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+var tagPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9._-]{0,127}$`)
+
+// ValidateTag checks the name of a tag. Tags can't look like versions, because they are used in place of them.
+func ValidateTag(tag string) error {
+	if !tagPattern.MatchString(tag) || tag == "latest" {
+		return fmt.Errorf("invalid tag %s", tag)
+	}
+
+	if _, err := semver.NewVersion(tag); err == nil {
+		return fmt.Errorf("invalid tag %s, tags must not be versions", tag)
+	}
+
+	return nil
+}
+
+// IsConstraint reports whether spec is a version constraint like ^1.2 or 1.x, rather than a version or a tag.
+func IsConstraint(spec string) bool {
+	if strings.ContainsAny(spec, "^~<>=*|, ") || strings.EqualFold(spec, "x") {
+		return true
+	}
+
+	if _, err := semver.NewVersion(spec); err == nil || ValidateTag(spec) == nil {
+		return false
+	}
+
+	_, err := semver.NewConstraint(spec)
+
+	return err == nil
+}
@@ -1,8 +1,11 @@
 package core
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"os"
 	"strconv"
+	"time"
 )
 
 func GetRequiredEnvVar(name string) string {
@@ -26,6 +29,56 @@ func GetRequiredEnvVarBool(name string) bool {
 	return boolValue
 }
 
+func GetEnvVar(name string, defaultValue string) string {
+	value := os.Getenv(name)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	return value
+}
+
+func GetEnvVarBool(name string, defaultValue bool) bool {
+	value := os.Getenv(name)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		panic("Invalid value for boolean environment variable " + name)
+	}
+
+	return boolValue
+}
+
+func GetEnvVarDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		panic("Invalid value for duration environment variable " + name)
+	}
+
+	return duration
+}
+
+func RandomId() string {
+	bytes := make([]byte, 16)
+
+	if _, err := rand.Read(bytes); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(bytes)
+}
+
 func FilterArray[T any](ss []T, test func(T) bool) (ret []T) {
 	for _, s := range ss {
 		if test(s) {
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+type AuthenticationConfig struct {
+	// KeyFunc resolves the verification key of JWTs.
+	KeyFunc jwt.Keyfunc
+	// ApiKeys, if set, are accepted in the X-API-Key header, as bearer token or as Basic password.
+	ApiKeys ApiKeys
+	// Htpasswd, if set, verifies Basic credentials of its users.
+	Htpasswd *Htpasswd
+	// ClientCertificates map verified TLS client certificates to prefixes and permissions.
+	// They are only used, if the request has no other credentials.
+	ClientCertificates ClaimMappings
+}
+
+// AuthenticateWithConfig accepts a JWT or API key as bearer token, an API key in the X-API-Key header,
+// Basic credentials, whose password is either the password of an htpasswd user, a JWT or an API key,
+// or a verified TLS client certificate.
+// The authenticated token is stored as "user" in the context. Requests without credentials are passed on,
+// so ValidateAuth can decide whether anonymous access is allowed.
+func AuthenticateWithConfig(config AuthenticationConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, err := config.authenticate(c.Request())
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"message": err.Error()})
+			}
+
+			if user != nil {
+				c.Set("user", user)
+			}
+
+			return next(c)
+		}
+	}
+}
+
+func (config AuthenticationConfig) authenticate(request *http.Request) (*jwt.Token, error) {
+	if apiKey := request.Header.Get("X-API-Key"); apiKey != "" {
+		return config.ApiKeys.authenticate(apiKey)
+	}
+
+	if username, password, ok := request.BasicAuth(); ok {
+		if config.Htpasswd != nil && config.Htpasswd.hasUser(username) {
+			return config.Htpasswd.authenticate(username, password)
+		}
+
+		return config.authenticateSecret(password)
+	}
+
+	authorization := request.Header.Get(echo.HeaderAuthorization)
+	if authorization == "" {
+		if len(config.ClientCertificates) > 0 && request.TLS != nil && len(request.TLS.VerifiedChains) > 0 {
+			return config.ClientCertificates.authenticateCertificate(request.TLS.VerifiedChains[0][0])
+		}
+
+		return nil, nil
+	}
+
+	scheme, credentials, _ := strings.Cut(authorization, " ")
+	if !strings.EqualFold(scheme, "Bearer") || credentials == "" {
+		return nil, errors.New("unsupported authorization scheme")
+	}
+
+	return config.authenticateSecret(credentials)
+}
+
+// authenticateSecret accepts either a JWT or an API key.
+func (config AuthenticationConfig) authenticateSecret(secret string) (*jwt.Token, error) {
+	if strings.Count(secret, ".") != 2 {
+		return config.ApiKeys.authenticate(secret)
+	}
+
+	token, err := jwt.ParseWithClaims(secret, jwt.MapClaims{}, config.KeyFunc)
+	if err != nil {
+		return nil, errors.New("invalid or expired jwt")
+	}
+
+	return token, nil
+}
@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticationChain(t *testing.T) {
+	directory := t.TempDir()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+
+	os.WriteFile(filepath.Join(directory, "keys.json"), []byte(`[
+		{"name": "deploy", "key": "`+HashApiKey("tr_deploy")+`", "prefix": "/foo", "permissions": ["read"]}
+	]`), 0600)
+	os.WriteFile(filepath.Join(directory, "htpasswd"), []byte("alice:"+string(hash)+"\n"), 0600)
+	os.WriteFile(filepath.Join(directory, "access.json"), []byte(`{
+		"alice": {"grants": [{"pattern": "foo/*", "permissions": ["read", "write"]}]}
+	}`), 0600)
+
+	t.Setenv("API_KEYS_FILE", filepath.Join(directory, "keys.json"))
+	t.Setenv("HTPASSWD_FILE", filepath.Join(directory, "htpasswd"))
+	t.Setenv("HTPASSWD_ACCESS_FILE", filepath.Join(directory, "access.json"))
+
+	e := echo.New()
+
+	e.Use(AuthenticateWithConfig(AuthenticationConfig{
+		KeyFunc:  TrustedIssuers{}.KeyFunc([]byte(testSecret)),
+		ApiKeys:  LoadApiKeys(),
+		Htpasswd: LoadHtpasswd(),
+	}))
+	e.Use(ValidateAuthWithConfig(AuthConfig{RequireExpiry: true}))
+
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+
+	e.GET("/:namespace/:name/:version", ok)
+	e.PUT("/:namespace/:name/:version", ok)
+
+	token := signTestToken(t, jwt.MapClaims{"name": "ci", "prefix": "/foo"})
+
+	cases := []struct {
+		method   string
+		header   string
+		value    string
+		basic    []string
+		expected int
+	}{
+		{"GET", "X-API-Key", "tr_deploy", nil, http.StatusOK},
+		{"PUT", "X-API-Key", "tr_deploy", nil, http.StatusForbidden},
+		{"GET", "X-API-Key", "tr_wrong", nil, http.StatusUnauthorized},
+		{"GET", "Authorization", "Bearer tr_deploy", nil, http.StatusOK},
+		{"PUT", "", "", []string{"alice", "secret"}, http.StatusOK},
+		{"PUT", "", "", []string{"alice", "wrong"}, http.StatusUnauthorized},
+		{"GET", "", "", []string{"anyone", "tr_deploy"}, http.StatusOK},
+		// The token has no expiry, which is required for JWTs only.
+		{"GET", "", "", []string{"anyone", token}, http.StatusUnauthorized},
+		{"GET", "", "", nil, http.StatusUnauthorized},
+	}
+
+	for i, c := range cases {
+		request := httptest.NewRequest(c.method, "/foo/bar/1.0.0", nil)
+		if c.header != "" {
+			request.Header.Set(c.header, c.value)
+		}
+		if c.basic != nil {
+			request.SetBasicAuth(c.basic[0], c.basic[1])
+		}
+
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+
+		if recorder.Code != c.expected {
+			t.Errorf("case %d: expected %d, got %d", i, c.expected, recorder.Code)
+		}
+	}
+}
+
+func TestAccessRequiresPrefixOrGrants(t *testing.T) {
+	cases := []struct {
+		access Access
+		valid  bool
+	}{
+		{Access{Permissions: []string{"read"}}, false},
+		{Access{Namespace: "foo", Permissions: []string{"read"}}, false},
+		{Access{Prefix: "/", Permissions: []string{"read"}}, true},
+		{Access{Grants: []core.Grant{{Pattern: "foo/*", Permissions: []core.Permission{core.PermissionRead}}}}, true},
+	}
+
+	for i, c := range cases {
+		if err := c.access.Validate(); (err == nil) != c.valid {
+			t.Errorf("case %d: expected valid %t, got %v", i, c.valid, err)
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(file, []byte(`[{"name": "deploy", "key": "`+HashApiKey("tr_deploy")+`", "permissions": ["read"]}]`), 0600)
+	t.Setenv("API_KEYS_FILE", file)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected an API key without a prefix to be rejected")
+		}
+	}()
+
+	LoadApiKeys()
+}
+
+func TestClientCertificate(t *testing.T) {
+	e := echo.New()
+
+	e.Use(AuthenticateWithConfig(AuthenticationConfig{
+		ClientCertificates: ClaimMappings{{
+			Claims:      map[string]string{"ou": "build"},
+			Name:        "{cn}",
+			Prefix:      "/ci/{cn}",
+			Permissions: []string{"read", "write"},
+		}},
+	}))
+	e.Use(ValidateAuth)
+
+	e.PUT("/:namespace/:name/:version", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	certificate := func(cn string, ou string) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: []string{ou}},
+			SerialNumber: big.NewInt(1),
+		}
+	}
+
+	cases := []struct {
+		path        string
+		certificate *x509.Certificate
+		expected    int
+	}{
+		{"/ci/runner/1.0.0", certificate("runner", "build"), http.StatusOK},
+		{"/ci/other/1.0.0", certificate("runner", "build"), http.StatusUnauthorized},
+		{"/ci/runner/1.0.0", certificate("runner", "sales"), http.StatusUnauthorized},
+		{"/ci/runner/1.0.0", nil, http.StatusUnauthorized},
+	}
+
+	for i, c := range cases {
+		request := httptest.NewRequest("PUT", c.path, nil)
+		if c.certificate != nil {
+			request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{c.certificate}}}
+		}
+
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+
+		if recorder.Code != c.expected {
+			t.Errorf("case %d: expected %d, got %d", i, c.expected, recorder.Code)
+		}
+	}
+}
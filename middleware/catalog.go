@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// CatalogPath lists the namespaces and CatalogNamespacePath the artifacts of a namespace.
+// They can be requested with any valid token, the handlers only return what the caller can read, using CanRead.
+const CatalogPath = "/"
+const CatalogNamespacePath = "/:namespace"
+
+func isCatalogRequest(c echo.Context) bool {
+	return c.Request().Method == http.MethodGet && (c.Path() == CatalogPath || c.Path() == CatalogNamespacePath)
+}
+
+// CanRead checks whether the caller of an already validated request may read the artifact.
+// If name is empty, it checks whether the caller may read any artifact of the namespace.
+func CanRead(c echo.Context, publicRead PublicRead, namespace string, name string) bool {
+	for _, grant := range publicRead {
+		if grantCovers(grant, namespace, name) {
+			return true
+		}
+	}
+
+	user, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return false
+	}
+
+	claims, ok := user.Claims.(jwt.MapClaims)
+	if !ok {
+		return false
+	}
+
+	if tokenNamespace, _ := claims["namespace"].(string); tokenNamespace != "" && tokenNamespace != namespace {
+		return false
+	}
+
+	if _, ok := claims["grants"]; ok {
+		grants, err := grantsFromClaims(claims)
+		if err != nil {
+			return false
+		}
+
+		for _, grant := range grants {
+			if core.HasPermission(grant.Permissions, core.PermissionRead) && grantCovers(grant, namespace, name) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	permissions, err := permissionsFromClaims(claims)
+	if err != nil || !core.HasPermission(permissions, core.PermissionRead) {
+		return false
+	}
+
+	prefix, err := prefixFromClaims(claims)
+	if err != nil {
+		return false
+	}
+
+	path := "/" + namespace
+	if name != "" {
+		path += "/" + name
+	}
+
+	// The prefix may also point into the artifact, eg. to a single version.
+	return pathHasPrefix(path, prefix) || pathHasPrefix(prefix, path)
+}
+
+func grantCovers(grant core.Grant, namespace string, name string) bool {
+	if name == "" {
+		return grant.MatchesNamespace(namespace)
+	}
+
+	return grant.Matches(namespace, name)
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+func TestCanRead(t *testing.T) {
+	publicRead := PublicRead{{Pattern: "public"}}
+
+	team := jwt.MapClaims{"name": "team", "prefix": "/team/app", "permissions": []interface{}{"read"}}
+	pushOnly := jwt.MapClaims{"name": "ci", "prefix": "/", "permissions": []interface{}{"write"}}
+	granted := jwt.MapClaims{"name": "ci", "grants": []interface{}{
+		map[string]interface{}{"pattern": "team/lib-*", "permissions": []interface{}{"read"}},
+	}}
+
+	cases := []struct {
+		claims    jwt.MapClaims
+		namespace string
+		name      string
+		expected  bool
+	}{
+		{team, "team", "", true},
+		{team, "team", "app", true},
+		{team, "team", "other", false},
+		{team, "other", "", false},
+		{team, "public", "tool", true},
+		{pushOnly, "team", "", false},
+		{granted, "team", "", true},
+		{granted, "team", "lib-core", true},
+		{granted, "team", "app", false},
+		{nil, "public", "", true},
+		{nil, "team", "", false},
+	}
+
+	e := echo.New()
+
+	for _, test := range cases {
+		c := e.NewContext(httptest.NewRequest("GET", "/", nil), httptest.NewRecorder())
+		if test.claims != nil {
+			c.Set("user", &jwt.Token{Claims: test.claims, Valid: true})
+		}
+
+		if result := CanRead(c, publicRead, test.namespace, test.name); result != test.expected {
+			t.Errorf("%v %s/%s: expected %v, got %v", test.claims["name"], test.namespace, test.name, test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// LoadClientCertificateMappings reads the mappings of client certificates from TLS_CLIENT_MAPPINGS_FILE.
+func LoadClientCertificateMappings() ClaimMappings {
+	file := core.GetEnvVar("TLS_CLIENT_MAPPINGS_FILE", "")
+	if file == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+
+	mappings := ClaimMappings{}
+	if err := json.Unmarshal(content, &mappings); err != nil {
+		panic(fmt.Sprintf("Invalid TLS_CLIENT_MAPPINGS_FILE: %s", err))
+	}
+
+	if err := mappings.validate(); err != nil {
+		panic(fmt.Sprintf("Invalid TLS_CLIENT_MAPPINGS_FILE: %s", err))
+	}
+
+	return mappings
+}
+
+// certificateClaims exposes the subject and SANs of a client certificate as claims, so they can be mapped like the claims of a token.
+func certificateClaims(certificate *x509.Certificate) jwt.MapClaims {
+	toArray := func(values []string) []interface{} {
+		return core.MapArray(values, func(v string) interface{} { return v })
+	}
+
+	uris := []string{}
+	for _, uri := range certificate.URIs {
+		uris = append(uris, uri.String())
+	}
+
+	return jwt.MapClaims{
+		"sub":    certificate.Subject.String(),
+		"cn":     certificate.Subject.CommonName,
+		"o":      toArray(certificate.Subject.Organization),
+		"ou":     toArray(certificate.Subject.OrganizationalUnit),
+		"dns":    toArray(certificate.DNSNames),
+		"email":  toArray(certificate.EmailAddresses),
+		"uri":    toArray(uris),
+		"serial": certificate.SerialNumber.Text(16),
+	}
+}
+
+func (mappings ClaimMappings) authenticateCertificate(certificate *x509.Certificate) (*jwt.Token, error) {
+	claims, err := mappings.apply(certificateClaims(certificate))
+	if err != nil {
+		return nil, fmt.Errorf("client certificate %s: %s", certificate.Subject, err)
+	}
+
+	return &jwt.Token{Claims: claims, Valid: true}, nil
+}
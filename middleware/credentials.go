@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/gommon/log"
+	"github.com/sevensolutions/tiny-repo/core"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Access describes what a static credential may access, the same way the corresponding claims of a token do.
+type Access struct {
+	Namespace   string       `json:"namespace,omitempty"`
+	Prefix      string       `json:"prefix,omitempty"`
+	Permissions []string     `json:"permissions,omitempty"`
+	Grants      []core.Grant `json:"grants,omitempty"`
+}
+
+// Validate checks that the access is restricted by a prefix or grants. Use the prefix / for access to all artifacts.
+func (a Access) Validate() error {
+	if len(a.Grants) == 0 && a.Prefix == "" {
+		return errors.New("either a prefix or grants are required")
+	}
+
+	if len(a.Permissions) == 0 && len(a.Grants) == 0 {
+		return errors.New("either permissions or grants are required")
+	}
+
+	if _, err := core.ParsePermissions(a.Permissions); err != nil {
+		return err
+	}
+
+	for _, grant := range a.Grants {
+		if err := grant.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// token converts the access into the claims of an unsigned token, which is validated by ValidateAuth like any other token.
+func (a Access) token(name string) *jwt.Token {
+	claims := jwt.MapClaims{
+		"name":      name,
+		"namespace": a.Namespace,
+	}
+
+	if len(a.Grants) > 0 {
+		claims["grants"] = a.Grants
+	} else {
+		claims["prefix"] = a.Prefix
+		claims["permissions"] = core.MapArray(a.Permissions, func(p string) interface{} { return p })
+	}
+
+	return &jwt.Token{Claims: claims, Valid: true}
+}
+
+// AccessOfClaims describes what a validated token may access.
+func AccessOfClaims(claims jwt.MapClaims) (Access, error) {
+	access := Access{}
+	access.Namespace, _ = claims["namespace"].(string)
+
+	if _, ok := claims["grants"]; ok {
+		grants, err := grantsFromClaims(claims)
+		access.Grants = grants
+		return access, err
+	}
+
+	prefix, err := prefixFromClaims(claims)
+	if err != nil {
+		return access, err
+	}
+	access.Prefix = prefix
+
+	permissions, err := permissionsFromClaims(claims)
+	access.Permissions = core.MapArray(permissions, func(p core.Permission) string { return string(p) })
+
+	return access, err
+}
+
+// isStaticCredential checks whether the token has been created for an API key, an htpasswd user or a client certificate.
+func isStaticCredential(token *jwt.Token) bool {
+	return token.Method == nil
+}
+
+type ApiKey struct {
+	Name string `json:"name"`
+	// Key is the sha256 hash of the key, eg. sha256:2c26b4...
+	Key string `json:"key"`
+	Access
+}
+
+type ApiKeys map[string]ApiKey
+
+// LoadApiKeys reads the API keys configured in API_KEYS_FILE.
+func LoadApiKeys() ApiKeys {
+	file := core.GetEnvVar("API_KEYS_FILE", "")
+	if file == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+
+	keys := []ApiKey{}
+	if err := json.Unmarshal(content, &keys); err != nil {
+		panic(fmt.Sprintf("Invalid API_KEYS_FILE: %s", err))
+	}
+
+	apiKeys := ApiKeys{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key.Key, "sha256:") {
+			panic(fmt.Sprintf("Invalid API key %s: the key must be a sha256 hash", key.Name))
+		}
+		if err := key.Validate(); err != nil {
+			panic(fmt.Sprintf("Invalid API key %s: %s", key.Name, err))
+		}
+
+		apiKeys[key.Key] = key
+	}
+
+	return apiKeys
+}
+
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (k ApiKeys) authenticate(key string) (*jwt.Token, error) {
+	apiKey, ok := k[HashApiKey(key)]
+	if !ok {
+		return nil, errors.New("invalid api key")
+	}
+
+	return apiKey.token(apiKey.Name), nil
+}
+
+// Htpasswd verifies Basic credentials against an htpasswd file with bcrypt hashes.
+// Successful verifications are cached for a few minutes, because bcrypt is deliberately slow.
+type Htpasswd struct {
+	users    map[string][]byte
+	access   map[string]Access
+	mutex    sync.Mutex
+	verified map[string]time.Time
+}
+
+// LoadHtpasswd reads HTPASSWD_FILE and the access of its users from HTPASSWD_ACCESS_FILE.
+func LoadHtpasswd() *Htpasswd {
+	file := core.GetEnvVar("HTPASSWD_FILE", "")
+	if file == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+
+	htpasswd := &Htpasswd{
+		users:    map[string][]byte{},
+		access:   map[string]Access{},
+		verified: map[string]time.Time{},
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		username, hash, found := strings.Cut(line, ":")
+		if !found {
+			panic("Invalid HTPASSWD_FILE: " + line)
+		}
+
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			log.Warn("Ignoring htpasswd user ", username, ", only bcrypt hashes are supported")
+			continue
+		}
+
+		htpasswd.users[username] = []byte(hash)
+	}
+
+	accessFile := core.GetRequiredEnvVar("HTPASSWD_ACCESS_FILE")
+
+	content, err = os.ReadFile(accessFile)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := json.Unmarshal(content, &htpasswd.access); err != nil {
+		panic(fmt.Sprintf("Invalid HTPASSWD_ACCESS_FILE: %s", err))
+	}
+
+	for username, access := range htpasswd.access {
+		if err := access.Validate(); err != nil {
+			panic(fmt.Sprintf("Invalid access of htpasswd user %s: %s", username, err))
+		}
+	}
+
+	return htpasswd
+}
+
+func (h *Htpasswd) hasUser(username string) bool {
+	_, ok := h.users[username]
+	return ok
+}
+
+func (h *Htpasswd) authenticate(username string, password string) (*jwt.Token, error) {
+	sum := sha256.Sum256([]byte(username + ":" + password))
+	cacheKey := hex.EncodeToString(sum[:])
+
+	h.mutex.Lock()
+	verifiedAt, cached := h.verified[cacheKey]
+	h.mutex.Unlock()
+
+	if !cached || time.Since(verifiedAt) > 5*time.Minute {
+		if err := bcrypt.CompareHashAndPassword(h.users[username], []byte(password)); err != nil {
+			return nil, errors.New("invalid username or password")
+		}
+
+		h.mutex.Lock()
+		h.verified[cacheKey] = time.Now()
+		h.mutex.Unlock()
+	}
+
+	// Users without an own entry get the access of the * entry, if there is one.
+	access, ok := h.access[username]
+	if !ok {
+		access, ok = h.access["*"]
+	}
+	if !ok {
+		return nil, errors.New("no access configured for user " + username)
+	}
+
+	return access.token(username), nil
+}
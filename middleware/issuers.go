@@ -0,0 +1,316 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	ospath "path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// TrustedIssuer is an external identity provider, eg. GitHub Actions or GitLab CI, whose tokens are accepted.
+type TrustedIssuer struct {
+	Issuer string `json:"issuer"`
+	// JwksUrl is optional. By default it is discovered from the OpenID configuration of the issuer.
+	JwksUrl string `json:"jwksUrl"`
+	// PublicKeyFile contains static PEM encoded public keys or a JWKS document and is used instead of JwksUrl.
+	PublicKeyFile string `json:"publicKeyFile"`
+	// Audience, if set, must be contained in the aud claim of every token.
+	Audience string `json:"audience"`
+	// Mappings translate the claims of the issuer into a TinyRepo name, prefix and permissions.
+	// At least one is required, tokens matching no mapping are rejected.
+	Mappings []ClaimMapping `json:"mappings"`
+
+	jwks       *JWKS
+	publicKeys []publicKey
+}
+
+type ClaimMapping struct {
+	// Claims contains glob patterns, which all must match the claims of a token. Array claims match if any element matches.
+	Claims map[string]string `json:"claims"`
+	// Name and Prefix may reference claims, eg. /ci/{repository}. Name defaults to {sub}.
+	Name        string   `json:"name"`
+	Prefix      string   `json:"prefix"`
+	Permissions []string `json:"permissions"`
+}
+
+// ClaimMappings are applied in order, the first matching mapping wins.
+type ClaimMappings []ClaimMapping
+
+type TrustedIssuers []*TrustedIssuer
+
+var placeholderPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// LoadTrustedIssuers reads the issuers configured in JWT_TRUSTED_ISSUERS_FILE.
+func LoadTrustedIssuers() TrustedIssuers {
+	file := core.GetEnvVar("JWT_TRUSTED_ISSUERS_FILE", "")
+	if file == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+
+	issuers := TrustedIssuers{}
+	if err := json.Unmarshal(content, &issuers); err != nil {
+		panic(fmt.Sprintf("Invalid JWT_TRUSTED_ISSUERS_FILE: %s", err))
+	}
+
+	ttl := core.GetEnvVarDuration("JWT_JWKS_CACHE_TTL", time.Hour)
+
+	for _, issuer := range issuers {
+		if err := issuer.init(ttl); err != nil {
+			panic(fmt.Sprintf("Invalid trusted issuer %s: %s", issuer.Issuer, err))
+		}
+	}
+
+	return issuers
+}
+
+func (i *TrustedIssuer) init(ttl time.Duration) error {
+	if i.Issuer == "" {
+		return errors.New("issuer is required")
+	}
+
+	// Tokens of an identity provider are issued to many parties, so they never get access without a mapping.
+	if len(i.Mappings) == 0 {
+		return errors.New("at least one mapping is required")
+	}
+
+	if err := ClaimMappings(i.Mappings).validate(); err != nil {
+		return err
+	}
+
+	if i.PublicKeyFile != "" {
+		content, err := os.ReadFile(i.PublicKeyFile)
+		if err != nil {
+			return err
+		}
+		i.publicKeys, err = parsePublicKeys(content)
+		return err
+	}
+
+	i.jwks = NewJWKS(i.JwksUrl, i.Issuer, ttl)
+
+	return nil
+}
+
+func (issuers TrustedIssuers) find(iss string) *TrustedIssuer {
+	for _, issuer := range issuers {
+		if issuer.Issuer == iss {
+			return issuer
+		}
+	}
+
+	return nil
+}
+
+// KeyFunc resolves the verification key of a token.
+// Tokens of trusted issuers must be signed asymmetrically, all others with HS256 and the secret.
+func (issuers TrustedIssuers) KeyFunc(secret []byte) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		claims, _ := token.Claims.(jwt.MapClaims)
+		iss, _ := claims["iss"].(string)
+
+		if issuer := issuers.find(iss); issuer != nil {
+			return issuer.key(token)
+		}
+
+		if len(secret) == 0 || token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+
+		return secret, nil
+	}
+}
+
+func (i *TrustedIssuer) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA, *jwt.SigningMethodEd25519:
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+
+	kid, _ := token.Header["kid"].(string)
+
+	keys := i.publicKeys
+	if i.jwks != nil {
+		var err error
+		keys, err = i.jwks.Keys(kid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if kid != "" {
+		if key := findKey(keys, kid); key != nil {
+			return key.key, nil
+		}
+		return nil, fmt.Errorf("unknown key id %s", kid)
+	}
+
+	// Without a key id, the key is only unambiguous if the issuer has a single key of the right type.
+	var candidate interface{}
+	for _, key := range keys {
+		if keyMatchesMethod(key.key, token.Method) {
+			if candidate != nil {
+				return nil, errors.New("token has no key id")
+			}
+			candidate = key.key
+		}
+	}
+
+	if candidate == nil {
+		return nil, errors.New("no matching key found")
+	}
+
+	return candidate, nil
+}
+
+func keyMatchesMethod(key interface{}, method jwt.SigningMethod) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		return isKeyType[*rsa.PublicKey](key)
+	case *jwt.SigningMethodECDSA:
+		return isKeyType[*ecdsa.PublicKey](key)
+	case *jwt.SigningMethodEd25519:
+		return isKeyType[ed25519.PublicKey](key)
+	}
+
+	return false
+}
+
+func isKeyType[T any](key interface{}) bool {
+	_, ok := key.(T)
+	return ok
+}
+
+// validate checks the registered claims of a token of this issuer. Tokens of external issuers must always expire.
+func (i *TrustedIssuer) validate(claims jwt.MapClaims) error {
+	if _, ok := claims["exp"]; !ok {
+		return errors.New("token has no expiry")
+	}
+
+	if i.Audience != "" && !claims.VerifyAudience(i.Audience, true) {
+		return errors.New("invalid token audience")
+	}
+
+	return nil
+}
+
+// mapClaims applies the mappings of the issuer. Tokens matching no mapping are rejected.
+func (i *TrustedIssuer) mapClaims(claims jwt.MapClaims) (jwt.MapClaims, error) {
+	return ClaimMappings(i.Mappings).apply(claims)
+}
+
+func (mappings ClaimMappings) validate() error {
+	for _, mapping := range mappings {
+		if mapping.Prefix == "" {
+			return errors.New("every mapping needs a prefix")
+		}
+		if _, err := core.ParsePermissions(mapping.Permissions); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply uses the first matching mapping and returns a new claim set with the mapped name, prefix and permissions.
+// Only the registered claims identifying the token are kept, so foreign claims like grants can't widen the access.
+func (mappings ClaimMappings) apply(claims jwt.MapClaims) (jwt.MapClaims, error) {
+	for _, mapping := range mappings {
+		if !mapping.matches(claims) {
+			continue
+		}
+
+		nameTemplate := mapping.Name
+		if nameTemplate == "" {
+			nameTemplate = "{sub}"
+		}
+
+		name, err := expandClaims(nameTemplate, claims)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix, err := expandClaims(mapping.Prefix, claims)
+		if err != nil {
+			return nil, err
+		}
+
+		permissions := mapping.Permissions
+		if len(permissions) == 0 {
+			permissions = []string{string(core.PermissionRead)}
+		}
+
+		mapped := jwt.MapClaims{}
+		for _, key := range []string{"iss", "sub", "exp", "jti"} {
+			if value, ok := claims[key]; ok {
+				mapped[key] = value
+			}
+		}
+
+		mapped["name"] = name
+		mapped["prefix"] = prefix
+		mapped["permissions"] = core.MapArray(permissions, func(p string) interface{} { return p })
+
+		return mapped, nil
+	}
+
+	return nil, errors.New("no claim mapping matches")
+}
+
+func (m ClaimMapping) matches(claims jwt.MapClaims) bool {
+	for claim, pattern := range m.Claims {
+		if !claimMatches(claims[claim], pattern) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func claimMatches(value interface{}, pattern string) bool {
+	switch value := value.(type) {
+	case string:
+		matched, _ := ospath.Match(pattern, value)
+		return matched
+	case []interface{}:
+		for _, item := range value {
+			if claimMatches(item, pattern) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func expandClaims(template string, claims jwt.MapClaims) (string, error) {
+	var err error
+
+	result := placeholderPattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		claim := strings.Trim(placeholder, "{}")
+		value, _ := claims[claim].(string)
+
+		if value == "" || strings.Contains(value, "..") {
+			err = fmt.Errorf("invalid or missing claim %s", claim)
+		}
+
+		return value
+	})
+
+	return result, err
+}
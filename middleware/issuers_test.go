@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	echojwt "github.com/labstack/echo-jwt"
+	"github.com/labstack/echo/v4"
+)
+
+func TestTrustedIssuer(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	jwks := []jsonWebKey{{
+		Kid: "rsa",
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+	}}
+
+	var issuerUrl string
+	identityProvider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"jwks_uri": issuerUrl + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": jwks})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer identityProvider.Close()
+	issuerUrl = identityProvider.URL
+
+	// Without mappings, every token of the issuer would be accepted.
+	if err := (&TrustedIssuer{Issuer: issuerUrl}).init(time.Hour); err == nil {
+		t.Error("expected an issuer without mappings to be rejected")
+	}
+
+	issuer := &TrustedIssuer{
+		Issuer:   issuerUrl,
+		Audience: "tinyrepo",
+		Mappings: []ClaimMapping{{
+			Claims:      map[string]string{"repository_owner": "myorg", "ref": "refs/heads/*"},
+			Prefix:      "/ci/{repository}",
+			Permissions: []string{"read", "write"},
+		}},
+	}
+	if err := issuer.init(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	issuer.jwks.refetchInterval = 0
+
+	issuers := TrustedIssuers{issuer}
+
+	e := echo.New()
+	e.Use(echojwt.WithConfig(echojwt.Config{KeyFunc: issuers.KeyFunc([]byte(testSecret))}))
+	e.Use(ValidateAuthWithConfig(AuthConfig{Issuers: issuers}))
+	e.PUT("/:namespace/:name/:version", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	claims := func(owner string) jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":              issuerUrl,
+			"aud":              "tinyrepo",
+			"exp":              time.Now().Add(time.Hour).Unix(),
+			"sub":              "repo:" + owner + "/app:ref:refs/heads/main",
+			"repository":       owner + "/app",
+			"repository_owner": owner,
+			"ref":              "refs/heads/main",
+		}
+	}
+
+	withClaim := func(claims jwt.MapClaims, key string, value interface{}) jwt.MapClaims {
+		claims[key] = value
+		return claims
+	}
+
+	valid := sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims("myorg"))
+
+	cases := []struct {
+		path     string
+		token    string
+		expected int
+	}{
+		{"/ci/myorg/app/1.0.0", valid, http.StatusOK},
+		{"/ci/other/app/1.0.0", valid, http.StatusUnauthorized},
+		{"/ci/other/app/1.0.0", sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims("other")), http.StatusUnauthorized},
+		{"/ci/other/app/1.0.0", sign(jwt.SigningMethodRS256, "rsa", rsaKey, withClaim(claims("other"), "prefix", "/")), http.StatusUnauthorized},
+		// Claims of the issuer which tinyrepo would interpret itself must not widen the mapped access.
+		{"/ci/other/app/1.0.0", sign(jwt.SigningMethodRS256, "rsa", rsaKey, withClaim(withClaim(claims("myorg"), "namespace", "ci"), "grants", []map[string]interface{}{{"pattern": "*", "permissions": []string{"read", "write"}}})), http.StatusUnauthorized},
+		{"/ci/myorg/app/1.0.0", sign(jwt.SigningMethodHS256, "rsa", []byte(testSecret), claims("myorg")), http.StatusUnauthorized},
+		{"/ci/myorg/app/1.0.0", sign(jwt.SigningMethodEdDSA, "ed", edKey, claims("myorg")), http.StatusUnauthorized},
+	}
+
+	for i, c := range cases {
+		if code := doRequest(e, "PUT", c.path, c.token); code != c.expected {
+			t.Errorf("case %d: expected %d, got %d", i, c.expected, code)
+		}
+	}
+
+	// Rotate the keys, the new key id must trigger a refetch.
+	jwks = append(jwks, jsonWebKey{
+		Kid: "ed",
+		Kty: "OKP",
+		Crv: "Ed25519",
+		X:   base64.RawURLEncoding.EncodeToString(edKey.Public().(ed25519.PublicKey)),
+	})
+
+	if code := doRequest(e, "PUT", "/ci/myorg/app/1.0.0", sign(jwt.SigningMethodEdDSA, "ed", edKey, claims("myorg"))); code != http.StatusOK {
+		t.Errorf("expected the rotated key to be accepted, got %d", code)
+	}
+}
+
+func TestJWKSFetchWithoutLock(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	fetches := 0
+
+	identityProvider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if fetches > 1 {
+			requested <- struct{}{}
+			<-release
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jsonWebKey{{
+			Kid: "rsa",
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer identityProvider.Close()
+
+	jwks := NewJWKS(identityProvider.URL, identityProvider.URL, 0)
+
+	if keys, err := jwks.Keys("rsa"); err != nil || len(keys) != 1 {
+		t.Fatalf("expected the key to be fetched, got %v", err)
+	}
+
+	// The keys are stale, a second request fetches them again and hangs.
+	done := make(chan struct{})
+	go func() {
+		jwks.Keys("rsa")
+		close(done)
+	}()
+	<-requested
+
+	// Requests for known keys don't wait for the slow fetch.
+	if keys, err := jwks.Keys("rsa"); err != nil || len(keys) != 1 {
+		t.Errorf("expected the cached key, got %v", err)
+	}
+
+	close(release)
+	<-done
+
+	if fetches != 2 {
+		t.Errorf("expected 2 fetches, got %d", fetches)
+	}
+}
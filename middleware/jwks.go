@@ -0,0 +1,314 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/gommon/log"
+)
+
+type publicKey struct {
+	id  string
+	key interface{}
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS caches the public keys published by an issuer.
+// The keys are fetched again after the cache TTL, or when a token references an unknown key id,
+// so key rotations of the issuer are picked up automatically.
+// The keys are fetched without holding the lock, so a slow issuer only delays requests, which need the new keys.
+type JWKS struct {
+	url             string
+	issuer          string
+	ttl             time.Duration
+	refetchInterval time.Duration
+	client          *http.Client
+	mutex           sync.Mutex
+	keys            []publicKey
+	fetchedAt       time.Time
+	// fetching is closed, when the running fetch completes. It is nil, if no fetch is running.
+	fetching chan struct{}
+	fetchErr error
+}
+
+// NewJWKS creates a key set for the given url.
+// If the url is empty, it is discovered from the OpenID configuration of the issuer.
+func NewJWKS(url string, issuer string, ttl time.Duration) *JWKS {
+	return &JWKS{
+		url:             url,
+		issuer:          issuer,
+		ttl:             ttl,
+		refetchInterval: time.Minute,
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (j *JWKS) Keys(kid string) ([]publicKey, error) {
+	j.mutex.Lock()
+
+	stale := time.Since(j.fetchedAt) > j.ttl
+	unknown := kid != "" && findKey(j.keys, kid) == nil && time.Since(j.fetchedAt) > j.refetchInterval
+	keys := j.keys
+
+	if !stale && !unknown {
+		j.mutex.Unlock()
+		return keys, nil
+	}
+
+	// Only one request fetches the keys, the others wait for it, if they can't use the cached keys.
+	fetching := j.fetching
+	if fetching == nil {
+		j.fetching = make(chan struct{})
+		j.mutex.Unlock()
+
+		return j.refresh()
+	}
+
+	j.mutex.Unlock()
+
+	if !unknown && len(keys) > 0 {
+		return keys, nil
+	}
+
+	<-fetching
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if len(j.keys) == 0 {
+		return nil, j.fetchErr
+	}
+
+	return j.keys, nil
+}
+
+// refresh fetches the keys and swaps them in. It must only be called by the request, which started the fetch.
+func (j *JWKS) refresh() ([]publicKey, error) {
+	keys, err := j.fetch()
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err == nil {
+		j.keys = keys
+	} else if len(j.keys) > 0 {
+		// Keep using the last known keys, if the issuer is temporarily unavailable.
+		log.Warn("Failed to refresh JWKS of ", j.issuer, ": ", err)
+	}
+
+	j.fetchErr = err
+	j.fetchedAt = time.Now()
+	close(j.fetching)
+	j.fetching = nil
+
+	if len(j.keys) == 0 {
+		return nil, err
+	}
+
+	return j.keys, nil
+}
+
+// fetch downloads the keys. It is only called by one request at a time, so the discovered url isn't locked.
+func (j *JWKS) fetch() ([]publicKey, error) {
+	if j.url == "" {
+		url, err := j.discover()
+		if err != nil {
+			return nil, err
+		}
+		j.url = url
+	}
+
+	content := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+
+	if err := j.getJSON(j.url, &content); err != nil {
+		return nil, err
+	}
+
+	return parseJWKs(content.Keys), nil
+}
+
+func (j *JWKS) discover() (string, error) {
+	configuration := struct {
+		JwksUri string `json:"jwks_uri"`
+	}{}
+
+	if err := j.getJSON(strings.TrimSuffix(j.issuer, "/")+"/.well-known/openid-configuration", &configuration); err != nil {
+		return "", err
+	}
+
+	if configuration.JwksUri == "" {
+		return "", fmt.Errorf("the issuer %s doesn't publish a jwks_uri", j.issuer)
+	}
+
+	return configuration.JwksUri, nil
+}
+
+func (j *JWKS) getJSON(url string, target interface{}) error {
+	response, err := j.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch %s: %s", url, response.Status)
+	}
+
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+func findKey(keys []publicKey, kid string) *publicKey {
+	for i := range keys {
+		if keys[i].id == kid {
+			return &keys[i]
+		}
+	}
+
+	return nil
+}
+
+// parseJWKs converts all supported signing keys. Unsupported keys are skipped.
+func parseJWKs(jwks []jsonWebKey) []publicKey {
+	keys := []publicKey{}
+
+	for _, jwk := range jwks {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := parseJWK(jwk)
+		if err != nil {
+			log.Warn("Skipping JWK ", jwk.Kid, ": ", err)
+			continue
+		}
+
+		keys = append(keys, publicKey{id: jwk.Kid, key: key})
+	}
+
+	return keys
+}
+
+func parseJWK(jwk jsonWebKey) (interface{}, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeBigInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := decodeBigInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if jwk.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
+
+// parsePublicKeys reads either a JWKS document or PEM encoded public keys and certificates.
+func parsePublicKeys(content []byte) ([]publicKey, error) {
+	if strings.HasPrefix(strings.TrimSpace(string(content)), "{") {
+		document := struct {
+			Keys []jsonWebKey `json:"keys"`
+		}{}
+		if err := json.Unmarshal(content, &document); err != nil {
+			return nil, err
+		}
+		return parseJWKs(document.Keys), nil
+	}
+
+	keys := []publicKey{}
+
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, publicKey{key: key})
+		case "CERTIFICATE":
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, publicKey{key: certificate.PublicKey})
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found")
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// PublicRead contains patterns of artifacts, eg. public or public/tool-*, which can be read anonymously.
+type PublicRead []core.Grant
+
+// LoadPublicRead reads the comma separated patterns of PUBLIC_READ.
+func LoadPublicRead() PublicRead {
+	publicRead := PublicRead{}
+
+	for _, pattern := range strings.Split(core.GetEnvVar("PUBLIC_READ", ""), ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		grant := core.Grant{Pattern: strings.Trim(pattern, "/"), Permissions: []core.Permission{core.PermissionRead}}
+		if err := grant.Validate(); err != nil {
+			panic(fmt.Sprintf("Invalid PUBLIC_READ: %s", err))
+		}
+
+		publicRead = append(publicRead, grant)
+	}
+
+	return publicRead
+}
+
+// Allows checks whether the request only reads a public artifact.
+func (p PublicRead) Allows(c echo.Context) bool {
+	if RequiredPermission(c) != core.PermissionRead {
+		return false
+	}
+
+	namespace, artifact := artifactOfPath(c.Request().URL.Path)
+
+	for _, grant := range p {
+		if grant.Matches(namespace, artifact) {
+			return true
+		}
+	}
+
+	return false
+}
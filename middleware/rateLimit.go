@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/sevensolutions/tiny-repo/core"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit allows a number of requests per period, eg. 600/1m. Up to Requests requests may be sent at once.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+func ParseRateLimit(value string) (RateLimit, error) {
+	requests, period, found := strings.Cut(value, "/")
+	if !found {
+		return RateLimit{}, errors.New("expected a rate limit like 600/1m")
+	}
+
+	limit := RateLimit{}
+
+	var err error
+	limit.Requests, err = strconv.Atoi(requests)
+	if err != nil || limit.Requests <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid number of requests %s", requests)
+	}
+
+	// Allow 10/s instead of 10/1s
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+
+	limit.Period, err = time.ParseDuration(period)
+	if err != nil || limit.Period <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid period %s", period)
+	}
+
+	return limit, nil
+}
+
+func loadRateLimit(name string) *RateLimit {
+	value := core.GetEnvVar(name, "")
+	if value == "" {
+		return nil
+	}
+
+	limit, err := ParseRateLimit(value)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid %s: %s", name, err))
+	}
+
+	return &limit
+}
+
+// RateLimitByIp limits the requests of every client IP to RATE_LIMIT_IP.
+func RateLimitByIp() echo.MiddlewareFunc {
+	return rateLimiter(loadRateLimit("RATE_LIMIT_IP"), func(c echo.Context) (string, error) {
+		return c.RealIP(), nil
+	})
+}
+
+// RateLimitByToken limits the requests of every authenticated token to RATE_LIMIT_TOKEN.
+// It must be used after AuthenticateWithConfig. Anonymous requests are only limited by IP.
+func RateLimitByToken() echo.MiddlewareFunc {
+	return rateLimiter(loadRateLimit("RATE_LIMIT_TOKEN"), func(c echo.Context) (string, error) {
+		return tokenIdentifier(c), nil
+	})
+}
+
+// tokenIdentifier returns the id of the token, or its name or subject, if it has no id.
+func tokenIdentifier(c echo.Context) string {
+	user, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return ""
+	}
+
+	claims, _ := user.Claims.(jwt.MapClaims)
+	for _, claim := range []string{"jti", "name", "sub"} {
+		if value, _ := claims[claim].(string); value != "" {
+			return claim + ":" + value
+		}
+	}
+
+	return ""
+}
+
+func rateLimiter(limit *RateLimit, identify echoMiddleware.Extractor) echo.MiddlewareFunc {
+	if limit == nil {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return next
+		}
+	}
+
+	retryAfter := strconv.Itoa(int(math.Ceil(limit.Period.Seconds() / float64(limit.Requests))))
+
+	return echoMiddleware.RateLimiterWithConfig(echoMiddleware.RateLimiterConfig{
+		Skipper: func(c echo.Context) bool {
+			id, _ := identify(c)
+			return id == ""
+		},
+		IdentifierExtractor: identify,
+		Store: echoMiddleware.NewRateLimiterMemoryStoreWithConfig(echoMiddleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(float64(limit.Requests) / limit.Period.Seconds()),
+			Burst:     limit.Requests,
+			ExpiresIn: max(limit.Period, 3*time.Minute),
+		}),
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			c.Response().Header().Set("Retry-After", retryAfter)
+			return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
+		},
+	})
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+func TestRateLimitByToken(t *testing.T) {
+	limit, err := ParseRateLimit("2/h")
+	if err != nil || limit.Requests != 2 || limit.Period != time.Hour {
+		t.Fatalf("unexpected rate limit %+v, %v", limit, err)
+	}
+
+	e := echo.New()
+	e.Use(AuthenticateWithConfig(AuthenticationConfig{KeyFunc: TrustedIssuers{}.KeyFunc([]byte(testSecret))}))
+	e.Use(rateLimiter(&limit, func(c echo.Context) (string, error) {
+		return tokenIdentifier(c), nil
+	}))
+	e.GET("/", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	first := signTestToken(t, jwt.MapClaims{"jti": "first"})
+	second := signTestToken(t, jwt.MapClaims{"jti": "second"})
+
+	cases := []struct {
+		token    string
+		expected int
+	}{
+		{first, http.StatusOK},
+		{first, http.StatusOK},
+		{first, http.StatusTooManyRequests},
+		{second, http.StatusOK},
+		{"", http.StatusOK},
+		{"", http.StatusOK},
+		{"", http.StatusOK},
+	}
+
+	for i, c := range cases {
+		if code := doRequest(e, "GET", "/", c.token); code != c.expected {
+			t.Errorf("case %d: expected %d, got %d", i, c.expected, code)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+const revocationDocument = "revoked-tokens.json"
+
+type RevokedToken struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	RevokedAt time.Time  `json:"revokedAt"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// RevocationStore keeps the list of revoked token ids.
+// The list is cached in memory and reloaded periodically, so revocations by other processes are picked up.
+type RevocationStore struct {
+	read            func() ([]byte, error)
+	write           func([]byte) error
+	refreshInterval time.Duration
+	mutex           sync.RWMutex
+	revoked         map[string]RevokedToken
+	// checkedAt is the time of the last attempt to load the list, even if it failed.
+	checkedAt time.Time
+}
+
+// Revocations creates a RevocationStore persisted in TOKEN_REVOCATION_FILE,
+// or through the storage backend, if no file is configured.
+func Revocations(adapter storage.StorageAdapter) *RevocationStore {
+	store := &RevocationStore{
+		refreshInterval: core.GetEnvVarDuration("TOKEN_REVOCATION_REFRESH_INTERVAL", 10*time.Second),
+		revoked:         map[string]RevokedToken{},
+	}
+
+	if file := core.GetEnvVar("TOKEN_REVOCATION_FILE", ""); file != "" {
+		store.read = func() ([]byte, error) {
+			content, err := os.ReadFile(file)
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return content, err
+		}
+		store.write = func(content []byte) error {
+			return os.WriteFile(file, content, 0600)
+		}
+	} else {
+		documents, ok := adapter.(storage.DocumentStore)
+		if !ok {
+			panic("The storage backend can't persist revoked tokens. Please configure TOKEN_REVOCATION_FILE.")
+		}
+
+		store.read = func() ([]byte, error) {
+			return documents.ReadDocument(revocationDocument)
+		}
+		store.write = func(content []byte) error {
+			return documents.WriteDocument(revocationDocument, content)
+		}
+	}
+
+	if err := store.reload(); err != nil {
+		panic(err)
+	}
+
+	return store
+}
+
+func (s *RevocationStore) reload() error {
+	content, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	tokens := []RevokedToken{}
+	if content != nil {
+		if err := json.Unmarshal(content, &tokens); err != nil {
+			return err
+		}
+	}
+
+	revoked := map[string]RevokedToken{}
+	for _, token := range tokens {
+		revoked[token.Id] = token
+	}
+
+	s.mutex.Lock()
+	s.revoked = revoked
+	s.checkedAt = time.Now()
+	s.mutex.Unlock()
+
+	return nil
+}
+
+func (s *RevocationStore) refreshIfStale() {
+	// The attempt is recorded upfront, so concurrent requests and requests after a failure don't reload the list as well.
+	s.mutex.Lock()
+	stale := time.Since(s.checkedAt) > s.refreshInterval
+	if stale {
+		s.checkedAt = time.Now()
+	}
+	s.mutex.Unlock()
+
+	if !stale {
+		return
+	}
+
+	// Keep using the last known list, if the store is temporarily unavailable.
+	if err := s.reload(); err != nil {
+		log.Println("Failed to reload revoked tokens:", err)
+	}
+}
+
+func (s *RevocationStore) IsRevoked(id string) bool {
+	s.refreshIfStale()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, revoked := s.revoked[id]
+
+	return revoked
+}
+
+func (s *RevocationStore) List() []RevokedToken {
+	s.refreshIfStale()
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	result := make([]RevokedToken, 0, len(s.revoked))
+	for _, token := range s.revoked {
+		result = append(result, token)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RevokedAt.Before(result[j].RevokedAt)
+	})
+
+	return result
+}
+
+func (s *RevocationStore) Revoke(token RevokedToken) error {
+	if token.Id == "" {
+		return errors.New("the token has no id")
+	}
+
+	if token.RevokedAt.IsZero() {
+		token.RevokedAt = time.Now()
+	}
+
+	// Always start from the persisted list, it may have been changed by another process.
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	tokens := []RevokedToken{token}
+	for _, existing := range s.revoked {
+		// Expired tokens are rejected anyway, so there is no need to remember them.
+		if existing.Id == token.Id || (existing.ExpiresAt != nil && existing.ExpiresAt.Before(time.Now())) {
+			continue
+		}
+		tokens = append(tokens, existing)
+	}
+
+	content, _ := json.MarshalIndent(tokens, "", "  ")
+
+	if err := s.write(content); err != nil {
+		return err
+	}
+
+	s.revoked = map[string]RevokedToken{}
+	for _, t := range tokens {
+		s.revoked[t.Id] = t
+	}
+
+	return nil
+}
+
+// RevokedTokenFromJWT extracts id, name and expiry of a token, which is about to be revoked.
+// The signature is verified with keyFunc, eg. TrustedIssuers.KeyFunc, but nothing else,
+// because expired or otherwise invalid tokens may be revoked as well.
+func RevokedTokenFromJWT(tokenString string, keyFunc jwt.Keyfunc) (RevokedToken, error) {
+	claims := jwt.MapClaims{}
+
+	_, err := jwt.ParseWithClaims(tokenString, claims, keyFunc)
+
+	var validationError *jwt.ValidationError
+	if err != nil && (!errors.As(err, &validationError) || validationError.Errors&(jwt.ValidationErrorMalformed|jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable) != 0) {
+		return RevokedToken{}, err
+	}
+
+	id, _ := claims["jti"].(string)
+	if id == "" {
+		return RevokedToken{}, errors.New("the token has no jti claim and can't be revoked individually")
+	}
+
+	name, _ := claims["name"].(string)
+
+	token := RevokedToken{
+		Id:   id,
+		Name: name,
+	}
+
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt := time.Unix(int64(exp), 0)
+		token.ExpiresAt = &expiresAt
+	}
+
+	return token, nil
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRevocationReloadFailure(t *testing.T) {
+	reads := 0
+
+	store := &RevocationStore{
+		read: func() ([]byte, error) {
+			reads++
+			if reads > 1 {
+				return nil, errors.New("unavailable")
+			}
+			return []byte(`[{"id":"abc"}]`), nil
+		},
+		refreshInterval: time.Hour,
+	}
+
+	if err := store.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The list is stale, but the store is unavailable. It is only queried once per interval.
+	store.checkedAt = time.Now().Add(-2 * time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if !store.IsRevoked("abc") {
+			t.Error("expected the last known list to be used")
+		}
+	}
+
+	if reads != 2 {
+		t.Errorf("expected 2 reads, got %d", reads)
+	}
+}
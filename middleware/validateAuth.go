@@ -1,38 +1,322 @@
 package middleware
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/gommon/log"
+	"github.com/sevensolutions/tiny-repo/core"
 )
 
+type AuthConfig struct {
+	// Issuer, if set, must match the iss claim of every token.
+	Issuer string
+	// Audience, if set, must be contained in the aud claim of every token.
+	Audience string
+	// RequireExpiry rejects tokens without an exp claim.
+	RequireExpiry bool
+	// Revocations, if set, is checked for the jti claim of every token.
+	Revocations *RevocationStore
+	// Issuers are external identity providers. Issuer, Audience and RequireExpiry don't apply to their tokens.
+	Issuers TrustedIssuers
+	// PublicRead contains the artifacts, which can be read without a token.
+	PublicRead PublicRead
+}
+
+// WhoamiPath can be requested with any valid token, regardless of its prefix and permissions.
+const WhoamiPath = "/_whoami"
+
 func ValidateAuth(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
+	return ValidateAuthWithConfig(AuthConfig{})(next)
+}
+
+func ValidateAuthWithConfig(config AuthConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return validateAuth(c, next, config)
+		}
+	}
+}
 
-		if c.Get("user") == nil {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"message": "unauthorized"})
+func validateAuth(c echo.Context, next echo.HandlerFunc, config AuthConfig) error {
+	if c.Get("user") == nil {
+		if config.PublicRead.Allows(c) {
+			return next(c)
+		}
+		// Anonymous callers can list the public artifacts.
+		if len(config.PublicRead) > 0 && isCatalogRequest(c) {
+			return next(c)
 		}
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="TinyRepo"`)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "unauthorized"})
+	}
+
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
 
-		user := c.Get("user").(*jwt.Token)
-		claims := user.Claims.(jwt.MapClaims)
-		name := claims["name"].(string)
-		prefix := claims["prefix"].(string)
+	iss, _ := claims["iss"].(string)
+	if isStaticCredential(user) {
+		// API keys and htpasswd users have no registered claims.
+	} else if issuer := config.Issuers.find(iss); issuer != nil {
+		if err := issuer.validate(claims); err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"message": err.Error()})
+		}
 
-		if !strings.HasPrefix(prefix, "/") {
-			prefix = "/" + prefix
+		mapped, err := issuer.mapClaims(claims)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"message": err.Error()})
 		}
 
-		log.Debug("Username", name)
+		claims = mapped
+		user.Claims = mapped
+	} else if err := validateRegisteredClaims(claims, config); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": err.Error()})
+	}
+
+	name, _ := claims["name"].(string)
+
+	if jti, _ := claims["jti"].(string); jti != "" && config.Revocations != nil && config.Revocations.IsRevoked(jti) {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "token has been revoked"})
+	}
 
-		path := c.Request().URL.Path
+	log.Debug("Username", name)
 
-		if strings.HasPrefix(path, prefix) {
+	if c.Path() == WhoamiPath || isCatalogRequest(c) {
+		return next(c)
+	}
+
+	if status, message := authorize(c, claims); status != 0 {
+		// A token which doesn't cover a public artifact may still read it.
+		if config.PublicRead.Allows(c) {
 			return next(c)
 		}
 
-		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "unauthorized to access path " + path})
+		return c.JSON(status, map[string]string{"message": message})
+	}
+
+	return next(c)
+}
+
+// authorize checks the namespace, grants or prefix and permissions of a token against the request.
+// It returns the status code and message to reject the request with, or 0 if it is allowed.
+func authorize(c echo.Context, claims jwt.MapClaims) (int, string) {
+	path := c.Request().URL.Path
+	namespace, artifact := artifactOfPath(path)
+
+	if tokenNamespace, _ := claims["namespace"].(string); tokenNamespace != "" && tokenNamespace != namespace {
+		return http.StatusUnauthorized, "unauthorized to access namespace " + namespace
+	}
+
+	required := RequiredPermissions(c)
+
+	if _, ok := claims["grants"]; ok {
+		grants, err := grantsFromClaims(claims)
+		if err != nil {
+			return http.StatusUnauthorized, err.Error()
+		}
+
+		covered := false
+		var missing core.Permission
+		for _, grant := range grants {
+			if grant.Matches(namespace, artifact) {
+				covered = true
+				if missing = missingPermission(grant.Permissions, required); missing == "" {
+					return 0, ""
+				}
+			}
+		}
+
+		if !covered {
+			return http.StatusUnauthorized, "unauthorized to access path " + path
+		}
+
+		return http.StatusForbidden, "missing permission " + string(missing) + " for path " + path
+	}
+
+	prefix, err := prefixFromClaims(claims)
+	if err != nil {
+		return http.StatusUnauthorized, err.Error()
+	}
+
+	if !pathHasPrefix(path, prefix) {
+		return http.StatusUnauthorized, "unauthorized to access path " + path
+	}
+
+	permissions, err := permissionsFromClaims(claims)
+	if err != nil {
+		return http.StatusUnauthorized, err.Error()
+	}
+
+	if missing := missingPermission(permissions, required); missing != "" {
+		return http.StatusForbidden, "missing permission " + string(missing) + " for path " + path
+	}
+
+	return 0, ""
+}
+
+// missingPermission returns the first required permission, which hasn't been granted, or an empty string.
+func missingPermission(granted []core.Permission, required []core.Permission) core.Permission {
+	for _, permission := range required {
+		if !core.HasPermission(granted, permission) {
+			return permission
+		}
+	}
+
+	return ""
+}
+
+// pathHasPrefix compares whole path segments, so the prefix /team doesn't match /team-secret.
+func pathHasPrefix(path string, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// artifactOfPath returns the first two segments of the path, which are the namespace and name of the artifact.
+func artifactOfPath(path string) (string, string) {
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+
+	if len(segments) < 2 {
+		return segments[0], ""
 	}
+
+	return segments[0], segments[1]
+}
+
+// validateRegisteredClaims checks iss, aud and the presence of exp.
+// exp, nbf and iat themselves are already validated while parsing the token.
+func validateRegisteredClaims(claims jwt.MapClaims, config AuthConfig) error {
+	if config.RequireExpiry {
+		if _, ok := claims["exp"]; !ok {
+			return errors.New("token has no expiry")
+		}
+	}
+
+	if config.Issuer != "" && !claims.VerifyIssuer(config.Issuer, true) {
+		return errors.New("invalid token issuer")
+	}
+
+	if config.Audience != "" && !claims.VerifyAudience(config.Audience, true) {
+		return errors.New("invalid token audience")
+	}
+
+	return nil
+}
+
+// RequiredPermission determines the permission needed for the current request based on its method and route.
+func RequiredPermission(c echo.Context) core.Permission {
+	if strings.HasPrefix(c.Path(), "/_admin/") {
+		return core.PermissionAdmin
+	}
+
+	// Tidying deletes versions.
+	if strings.HasSuffix(c.Path(), "/_tidy") {
+		return core.PermissionDelete
+	}
+
+	// Everything around upload sessions and presigned uploads, including querying and aborting them, is part of pushing.
+	if strings.Contains(c.Path(), "/uploads") || strings.Contains(c.Path(), "/presigned-uploads") {
+		return core.PermissionWrite
+	}
+
+	switch c.Request().Method {
+	case http.MethodGet, http.MethodHead:
+		return core.PermissionRead
+	case http.MethodDelete:
+		return core.PermissionDelete
+	default:
+		return core.PermissionWrite
+	}
+}
+
+// RequiredPermissions determines all permissions needed for the current request.
+// Pushing with the keep parameter deletes old versions, so it needs the delete permission as well.
+func RequiredPermissions(c echo.Context) []core.Permission {
+	required := RequiredPermission(c)
+
+	if keep := c.QueryParam("keep"); required == core.PermissionWrite && keep != "" && keep != "0" {
+		return []core.Permission{required, core.PermissionDelete}
+	}
+
+	return []core.Permission{required}
+}
+
+// prefixFromClaims returns the prefix of a token without grants. A missing prefix claim doesn't grant access to everything,
+// tokens for all artifacts have the prefix / instead.
+func prefixFromClaims(claims jwt.MapClaims) (string, error) {
+	value, ok := claims["prefix"]
+	if !ok {
+		return "", errors.New("token has neither a prefix nor grants")
+	}
+
+	prefix, ok := value.(string)
+	if !ok {
+		return "", errors.New("invalid prefix claim")
+	}
+
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+
+	return prefix, nil
+}
+
+func permissionsFromClaims(claims jwt.MapClaims) ([]core.Permission, error) {
+	value, ok := claims["permissions"]
+	if !ok {
+		return core.DefaultPermissions, nil
+	}
+
+	rawPermissions, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("invalid permissions claim")
+	}
+
+	values := []string{}
+	for _, raw := range rawPermissions {
+		s, ok := raw.(string)
+		if !ok {
+			return nil, errors.New("invalid permissions claim")
+		}
+		values = append(values, s)
+	}
+
+	return core.ParsePermissions(values)
+}
+
+func grantsFromClaims(claims jwt.MapClaims) ([]core.Grant, error) {
+	content, err := json.Marshal(claims["grants"])
+	if err != nil {
+		return nil, errors.New("invalid grants claim")
+	}
+
+	rawGrants := []struct {
+		Pattern     string   `json:"pattern"`
+		Permissions []string `json:"permissions"`
+	}{}
+
+	if err := json.Unmarshal(content, &rawGrants); err != nil {
+		return nil, errors.New("invalid grants claim")
+	}
+
+	grants := []core.Grant{}
+	for _, raw := range rawGrants {
+		permissions, err := core.ParsePermissions(raw.Permissions)
+		if err != nil {
+			return nil, err
+		}
+
+		grant := core.Grant{Pattern: raw.Pattern, Permissions: permissions}
+		if err := grant.Validate(); err != nil {
+			return nil, err
+		}
+
+		grants = append(grants, grant)
+	}
+
+	return grants, nil
 }
@@ -0,0 +1,250 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	echojwt "github.com/labstack/echo-jwt"
+	"github.com/labstack/echo/v4"
+)
+
+const testSecret = "test-secret"
+
+func newTestServer() *echo.Echo {
+	e := echo.New()
+
+	e.Use(echojwt.JWT([]byte(testSecret)))
+	e.Use(ValidateAuth)
+
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+
+	e.GET("/:namespace/:name/:version", ok)
+	e.PUT("/:namespace/:name/:version", ok)
+	e.DELETE("/:namespace/:name/:version", ok)
+	e.DELETE("/:namespace/:name/:version/uploads/:sessionId", ok)
+	e.PUT("/:namespace/:name/:version/uploads/:sessionId", ok)
+	e.GET(WhoamiPath, ok)
+	e.GET(CatalogNamespacePath, ok)
+	e.POST("/:namespace/:name/_tidy", ok)
+
+	return e
+}
+
+func signTestToken(t *testing.T, claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func doRequest(e *echo.Echo, method string, path string, token string) int {
+	request := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+
+	return recorder.Code
+}
+
+func TestPermissions(t *testing.T) {
+	e := newTestServer()
+
+	readOnly := signTestToken(t, jwt.MapClaims{"name": "deploy", "prefix": "/foo", "permissions": []string{"read"}})
+	pushOnly := signTestToken(t, jwt.MapClaims{"name": "ci", "prefix": "/foo", "permissions": []string{"write"}})
+	admin := signTestToken(t, jwt.MapClaims{"name": "admin", "prefix": "/", "permissions": []string{"admin"}})
+	legacy := signTestToken(t, jwt.MapClaims{"name": "legacy", "prefix": "/foo"})
+	noPrefix := signTestToken(t, jwt.MapClaims{"name": "other", "permissions": []string{"read"}})
+	invalidPrefix := signTestToken(t, jwt.MapClaims{"name": "other", "prefix": 1, "permissions": []string{"read"}})
+
+	cases := []struct {
+		method   string
+		path     string
+		token    string
+		expected int
+	}{
+		{"GET", "/foo/bar/1.0.0", readOnly, http.StatusOK},
+		{"PUT", "/foo/bar/1.0.0", readOnly, http.StatusForbidden},
+		{"DELETE", "/foo/bar/1.0.0", readOnly, http.StatusForbidden},
+		{"GET", "/foo/bar/1.0.0", pushOnly, http.StatusForbidden},
+		{"PUT", "/foo/bar/1.0.0", pushOnly, http.StatusOK},
+		{"PUT", "/foo/bar/1.0.0?keep=0", pushOnly, http.StatusOK},
+		{"PUT", "/foo/bar/1.0.0?keep=3", pushOnly, http.StatusForbidden},
+		{"PUT", "/foo/bar/1.0.0/uploads/abc?keep=3", pushOnly, http.StatusForbidden},
+		{"PUT", "/foo/bar/1.0.0?keep=3", legacy, http.StatusOK},
+		{"DELETE", "/foo/bar/1.0.0/uploads/abc", pushOnly, http.StatusOK},
+		{"DELETE", "/foo/bar/1.0.0", pushOnly, http.StatusForbidden},
+		{"DELETE", "/foo/bar/1.0.0", admin, http.StatusOK},
+		{"DELETE", "/foo/bar/1.0.0", legacy, http.StatusOK},
+		{"GET", "/other/bar/1.0.0", readOnly, http.StatusUnauthorized},
+		{"GET", "/foo/bar/1.0.0", noPrefix, http.StatusUnauthorized},
+		{"GET", "/foo/bar/1.0.0", invalidPrefix, http.StatusUnauthorized},
+		{"GET", WhoamiPath, pushOnly, http.StatusOK},
+		{"GET", WhoamiPath, "", http.StatusUnauthorized},
+		{"GET", "/other", readOnly, http.StatusOK},
+		{"POST", "/foo/bar/_tidy", pushOnly, http.StatusForbidden},
+		{"POST", "/foo/bar/_tidy", legacy, http.StatusOK},
+		{"GET", "/other", "", http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		if code := doRequest(e, c.method, c.path, c.token); code != c.expected {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.path, c.expected, code)
+		}
+	}
+}
+
+func TestRegisteredClaims(t *testing.T) {
+	e := echo.New()
+
+	e.Use(echojwt.JWT([]byte(testSecret)))
+	e.Use(ValidateAuthWithConfig(AuthConfig{
+		Issuer:        "tinyrepo",
+		Audience:      "artifacts",
+		RequireExpiry: true,
+	}))
+
+	e.GET("/:namespace/:name", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	exp := time.Now().Add(time.Hour).Unix()
+
+	cases := []struct {
+		claims   jwt.MapClaims
+		expected int
+	}{
+		{jwt.MapClaims{"prefix": "/", "iss": "tinyrepo", "aud": "artifacts", "exp": exp}, http.StatusOK},
+		{jwt.MapClaims{"prefix": "/", "iss": "tinyrepo", "aud": []string{"other", "artifacts"}, "exp": exp}, http.StatusOK},
+		{jwt.MapClaims{"prefix": "/", "iss": "tinyrepo", "aud": "artifacts"}, http.StatusUnauthorized},
+		{jwt.MapClaims{"prefix": "/", "iss": "someone", "aud": "artifacts", "exp": exp}, http.StatusUnauthorized},
+		{jwt.MapClaims{"prefix": "/", "iss": "tinyrepo", "aud": "other", "exp": exp}, http.StatusUnauthorized},
+		{jwt.MapClaims{"prefix": "/", "iss": "tinyrepo", "aud": "artifacts", "exp": time.Now().Add(-time.Hour).Unix()}, http.StatusUnauthorized},
+		{jwt.MapClaims{"prefix": "/", "iss": "tinyrepo", "aud": "artifacts", "exp": exp, "nbf": exp}, http.StatusUnauthorized},
+	}
+
+	for i, c := range cases {
+		if code := doRequest(e, "GET", "/foo/bar", signTestToken(t, c.claims)); code != c.expected {
+			t.Errorf("case %d: expected %d, got %d", i, c.expected, code)
+		}
+	}
+}
+
+func TestRevokedToken(t *testing.T) {
+	t.Setenv("TOKEN_REVOCATION_FILE", t.TempDir()+"/revoked-tokens.json")
+
+	revocations := Revocations(nil)
+
+	e := echo.New()
+
+	e.Use(echojwt.JWT([]byte(testSecret)))
+	e.Use(ValidateAuthWithConfig(AuthConfig{Revocations: revocations}))
+
+	e.GET("/:namespace/:name", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	token := signTestToken(t, jwt.MapClaims{"name": "ci", "prefix": "/", "jti": "abc"})
+
+	if code := doRequest(e, "GET", "/foo/bar", token); code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, code)
+	}
+
+	revokedToken, err := RevokedTokenFromJWT(token, TrustedIssuers{}.KeyFunc([]byte(testSecret)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := revocations.Revoke(revokedToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := doRequest(e, "GET", "/foo/bar", token); code != http.StatusUnauthorized {
+		t.Fatalf("expected %d, got %d", http.StatusUnauthorized, code)
+	}
+}
+
+func TestGrants(t *testing.T) {
+	e := newTestServer()
+
+	team := signTestToken(t, jwt.MapClaims{"name": "team", "prefix": "/team", "permissions": []string{"read"}})
+	namespaced := signTestToken(t, jwt.MapClaims{"name": "ns", "namespace": "team", "prefix": "/"})
+	granted := signTestToken(t, jwt.MapClaims{"name": "ci", "grants": []map[string]interface{}{
+		{"pattern": "team/app-*", "permissions": []string{"read", "write"}},
+		{"pattern": "shared", "permissions": []string{"read"}},
+	}})
+
+	cases := []struct {
+		method   string
+		path     string
+		token    string
+		expected int
+	}{
+		{"GET", "/team/bar/1.0.0", team, http.StatusOK},
+		{"GET", "/team-secret/bar/1.0.0", team, http.StatusUnauthorized},
+		{"GET", "/team/bar/1.0.0", namespaced, http.StatusOK},
+		{"GET", "/other/bar/1.0.0", namespaced, http.StatusUnauthorized},
+		{"PUT", "/team/app-web/1.0.0", granted, http.StatusOK},
+		{"PUT", "/team/app-web/1.0.0?keep=3", granted, http.StatusForbidden},
+		{"PUT", "/team/lib/1.0.0", granted, http.StatusUnauthorized},
+		{"GET", "/shared/lib/1.0.0", granted, http.StatusOK},
+		{"PUT", "/shared/lib/1.0.0", granted, http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		if code := doRequest(e, c.method, c.path, c.token); code != c.expected {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.path, c.expected, code)
+		}
+	}
+}
+
+func TestPublicRead(t *testing.T) {
+	t.Setenv("PUBLIC_READ", "public, tools/cli-*")
+
+	publicRead := LoadPublicRead()
+
+	e := echo.New()
+
+	e.Use(AuthenticateWithConfig(AuthenticationConfig{KeyFunc: TrustedIssuers{}.KeyFunc([]byte(testSecret))}))
+	e.Use(ValidateAuthWithConfig(AuthConfig{PublicRead: publicRead}))
+
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+
+	e.GET("/:namespace/:name/:version", ok)
+	e.PUT("/:namespace/:name/:version", ok)
+
+	other := signTestToken(t, jwt.MapClaims{"name": "other", "prefix": "/other"})
+	writer := signTestToken(t, jwt.MapClaims{"name": "ci", "prefix": "/public", "permissions": []string{"write"}})
+
+	cases := []struct {
+		method   string
+		path     string
+		token    string
+		expected int
+	}{
+		{"GET", "/public/bar/1.0.0", "", http.StatusOK},
+		{"GET", "/tools/cli-linux/1.0.0", "", http.StatusOK},
+		{"GET", "/tools/server/1.0.0", "", http.StatusUnauthorized},
+		{"PUT", "/public/bar/1.0.0", "", http.StatusUnauthorized},
+		{"GET", "/public/bar/1.0.0", other, http.StatusOK},
+		{"PUT", "/public/bar/1.0.0", other, http.StatusUnauthorized},
+		{"PUT", "/public/bar/1.0.0", writer, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		if code := doRequest(e, c.method, c.path, c.token); code != c.expected {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.path, c.expected, code)
+		}
+	}
+}
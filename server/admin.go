@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	myMiddleware "github.com/sevensolutions/tiny-repo/middleware"
+)
+
+type RevokeTokenRequest struct {
+	Id    string `json:"id"`
+	Token string `json:"token"`
+}
+
+func (srv *Server) listRevokedTokens(c echo.Context) error {
+	return c.JSON(http.StatusOK, srv.Revocations.List())
+}
+
+func (srv *Server) revokeToken(c echo.Context) error {
+	request := RevokeTokenRequest{}
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	revokedToken := myMiddleware.RevokedToken{Id: request.Id}
+
+	if request.Token != "" {
+		var err error
+		if srv.KeyFunc == nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "tokens can't be verified, revoke the token by its id")
+		}
+
+		revokedToken, err = myMiddleware.RevokedTokenFromJWT(request.Token, srv.KeyFunc)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "the token can't be verified: "+err.Error())
+		}
+	}
+
+	if revokedToken.Id == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "either id or token is required")
+	}
+
+	if err := srv.Revocations.Revoke(revokedToken); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
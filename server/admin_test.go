@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	myMiddleware "github.com/sevensolutions/tiny-repo/middleware"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+func TestRevokeToken(t *testing.T) {
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+
+	srv := &Server{
+		Revocations: myMiddleware.Revocations(storage.LocalDirectory()),
+	}
+
+	e := echo.New()
+	e.POST("/_admin/revoked-tokens", srv.revokeToken)
+
+	revoke := func(token string) int {
+		request := httptest.NewRequest(http.MethodPost, "/_admin/revoked-tokens", strings.NewReader(`{"token":"`+token+`"}`))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	sign := func(secret string, id string) string {
+		// Expired tokens may be revoked as well.
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"jti": id, "exp": time.Now().Add(-time.Hour).Unix()})
+		signed, _ := token.SignedString([]byte(secret))
+		return signed
+	}
+
+	// Without a JWT_SECRET or trusted issuers, tokens can only be revoked by their id.
+	if code := revoke(sign("secretsecret", "a")); code != http.StatusBadRequest {
+		t.Errorf("expected %d without a key, got %d", http.StatusBadRequest, code)
+	}
+
+	srv.KeyFunc = myMiddleware.TrustedIssuers{}.KeyFunc([]byte("secretsecret"))
+
+	if code := revoke(sign("othersecret", "b")); code != http.StatusBadRequest {
+		t.Errorf("expected %d for a foreign token, got %d", http.StatusBadRequest, code)
+	}
+	if code := revoke(sign("secretsecret", "c")); code != http.StatusNoContent {
+		t.Errorf("expected the token to be revoked, got %d", code)
+	}
+
+	if revoked := srv.Revocations.List(); len(revoked) != 1 || revoked[0].Id != "c" {
+		t.Errorf("unexpected revoked tokens %+v", revoked)
+	}
+}
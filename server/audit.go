@@ -0,0 +1,397 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+const (
+	AuditPush        = "push"
+	AuditDelete      = "delete"
+	AuditTidy        = "tidy"
+	AuditTokenRevoke = "token.revoke"
+	AuditTagSet      = "tag.set"
+	AuditTagDelete   = "tag.delete"
+	AuditReserve     = "version.reserve"
+	AuditAuth        = "auth"
+	AuditAccess      = "access"
+
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// auditDigestKey and auditVersionKey are used by handlers to pass the digest of a pushed blob
+// and the version of a tag to the audit log.
+const auditDigestKey = "auditDigest"
+const auditVersionKey = "auditVersion"
+
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	Action    string    `json:"action"`
+	Outcome   string    `json:"outcome"`
+	Status    int       `json:"status,omitempty"`
+	Token     string    `json:"token,omitempty"`
+	TokenId   string    `json:"tokenId,omitempty"`
+	Subject   string    `json:"subject,omitempty"`
+	SourceIp  string    `json:"sourceIp,omitempty"`
+	Method    string    `json:"method,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	Tag       string    `json:"tag,omitempty"`
+	Digest    string    `json:"digest,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// AuditLog appends entries as JSON lines to AUDIT_LOG_FILE.
+// Without a file, the entries are only written to the server log.
+type AuditLog struct {
+	file  string
+	mutex sync.Mutex
+}
+
+func OpenAuditLog() *AuditLog {
+	return &AuditLog{
+		file: core.GetEnvVar("AUDIT_LOG_FILE", ""),
+	}
+}
+
+func (l *AuditLog) Record(entry AuditEntry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	line, _ := json.Marshal(entry)
+
+	if l.file == "" {
+		log.Println("audit", string(line))
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	file, err := os.OpenFile(l.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Println("Failed to write the audit log:", err, string(line))
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		log.Println("Failed to write the audit log:", err, string(line))
+	}
+}
+
+type AuditFilter struct {
+	Action    string
+	Outcome   string
+	Token     string
+	Namespace string
+	Name      string
+	Version   string
+	Since     time.Time
+	Until     time.Time
+}
+
+func (f AuditFilter) matches(entry AuditEntry) bool {
+	return (f.Action == "" || f.Action == entry.Action) &&
+		(f.Outcome == "" || f.Outcome == entry.Outcome) &&
+		(f.Token == "" || f.Token == entry.Token || f.Token == entry.TokenId || f.Token == entry.Subject) &&
+		(f.Namespace == "" || f.Namespace == entry.Namespace) &&
+		(f.Name == "" || f.Name == entry.Name) &&
+		(f.Version == "" || f.Version == entry.Version) &&
+		(f.Since.IsZero() || !entry.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || entry.Time.Before(f.Until))
+}
+
+var errAuditLogDisabled = errors.New("the audit log is not configured")
+
+// Query calls visit for all matching entries, oldest first, until visit returns false.
+func (l *AuditLog) Query(filter AuditFilter, visit func(AuditEntry) bool) error {
+	if l.file == "" {
+		return errAuditLogDisabled
+	}
+
+	file, err := os.Open(l.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if !visitAuditLine(scanner.Bytes(), filter, visit) {
+			break
+		}
+	}
+
+	return scanner.Err()
+}
+
+// QueryNewest calls visit for all matching entries, newest first, until visit returns false.
+// The file is read backwards in blocks, so only the part of the log up to the last visited entry is read.
+func (l *AuditLog) QueryNewest(filter AuditFilter, visit func(AuditEntry) bool) error {
+	if l.file == "" {
+		return errAuditLogDisabled
+	}
+
+	file, err := os.Open(l.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	offset := info.Size()
+	block := make([]byte, 64*1024)
+	// partial is the end of a line, whose beginning hasn't been read yet.
+	partial := []byte{}
+
+	for offset > 0 {
+		size := min(int64(len(block)), offset)
+		offset -= size
+
+		if _, err := file.ReadAt(block[:size], offset); err != nil {
+			return err
+		}
+
+		lines := bytes.Split(append(block[:size:size], partial...), []byte{'\n'})
+		partial = append([]byte{}, lines[0]...)
+
+		for i := len(lines) - 1; i > 0; i-- {
+			if !visitAuditLine(lines[i], filter, visit) {
+				return nil
+			}
+		}
+	}
+
+	visitAuditLine(partial, filter, visit)
+
+	return nil
+}
+
+// visitAuditLine decodes a line of the log and visits it, if it matches. It returns false, if the query should stop.
+func visitAuditLine(line []byte, filter AuditFilter, visit func(AuditEntry) bool) bool {
+	entry := AuditEntry{}
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return true
+	}
+
+	return !filter.matches(entry) || visit(entry)
+}
+
+// auditRequest records mutating requests, denied requests and all other requests of authenticated clients after they have been handled.
+// It runs before authentication, so rejected credentials are recorded as well.
+func (srv *Server) auditRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			status = http.StatusInternalServerError
+
+			var httpError *echo.HTTPError
+			if errors.As(err, &httpError) {
+				status = httpError.Code
+			}
+		}
+
+		action := auditAction(c)
+		if action == "" && (status == http.StatusUnauthorized || status == http.StatusForbidden) {
+			action = AuditAuth
+		}
+		if _, authenticated := c.Get("user").(*jwt.Token); action == "" && authenticated {
+			action = AuditAccess
+		}
+		if action == "" {
+			return err
+		}
+
+		entry := srv.auditEntry(c, action)
+		entry.Status = status
+		entry.Digest, _ = c.Get(auditDigestKey).(string)
+
+		switch {
+		case status == http.StatusUnauthorized || status == http.StatusForbidden:
+			entry.Outcome = AuditDenied
+		case status >= 400:
+			entry.Outcome = AuditFailure
+		default:
+			entry.Outcome = AuditSuccess
+		}
+
+		if err != nil {
+			entry.Message = err.Error()
+		}
+
+		srv.Audit.Record(entry)
+
+		return err
+	}
+}
+
+// auditAction determines the audited action of a request or an empty string, if it isn't audited.
+// Starting or writing upload sessions isn't audited, only the completion of an upload.
+func auditAction(c echo.Context) string {
+	path := c.Path()
+	method := c.Request().Method
+
+	switch {
+	case path == "/_admin/revoked-tokens" && method == http.MethodPost:
+		return AuditTokenRevoke
+	case strings.HasPrefix(path, "/_admin/"):
+		return ""
+	case strings.Contains(path, "/_tags/") && method == http.MethodPut:
+		return AuditTagSet
+	case strings.Contains(path, "/_tags/") && method == http.MethodDelete:
+		return AuditTagDelete
+	case strings.HasSuffix(path, "/_reserve"):
+		return AuditReserve
+	case strings.HasSuffix(path, "/_tidy"):
+		return AuditTidy
+	case strings.HasSuffix(path, "/presigned-uploads/:uploadId") && method == http.MethodPost:
+		return AuditPush
+	case strings.HasSuffix(path, "/uploads/:sessionId") && method == http.MethodPut:
+		return AuditPush
+	case strings.Contains(path, "/uploads") || strings.Contains(path, "/presigned-uploads"):
+		return ""
+	case method == http.MethodPut:
+		return AuditPush
+	case method == http.MethodDelete:
+		return AuditDelete
+	}
+
+	return ""
+}
+
+// auditEntry creates an entry with the token, source and artifact of the request.
+func (srv *Server) auditEntry(c echo.Context, action string) AuditEntry {
+	entry := AuditEntry{
+		Action:    action,
+		SourceIp:  c.RealIP(),
+		Method:    c.Request().Method,
+		Path:      c.Request().URL.Path,
+		Namespace: c.Param("namespace"),
+		Name:      c.Param("name"),
+		Version:   c.Param("version"),
+		Tag:       c.Param("tag"),
+	}
+
+	if version, ok := c.Get(auditVersionKey).(string); ok {
+		entry.Version = version
+	}
+
+	if user, ok := c.Get("user").(*jwt.Token); ok {
+		if claims, ok := user.Claims.(jwt.MapClaims); ok {
+			entry.Token, _ = claims["name"].(string)
+			entry.TokenId, _ = claims["jti"].(string)
+			entry.Subject, _ = claims["sub"].(string)
+		}
+	}
+
+	return entry
+}
+
+// recordDeletedVersions records a tidy or delete entry for every version deleted on behalf of a request.
+func (srv *Server) recordDeletedVersions(template AuditEntry, action string, versions []*semver.Version) {
+	for _, v := range versions {
+		entry := template
+		entry.Time = time.Time{}
+		entry.Action = action
+		entry.Outcome = AuditSuccess
+		entry.Version = v.String()
+		srv.Audit.Record(entry)
+	}
+}
+
+func (srv *Server) queryAuditLog(c echo.Context) error {
+	if srv.Audit.file == "" {
+		return echo.NewHTTPError(http.StatusNotImplemented, errAuditLogDisabled.Error())
+	}
+
+	filter := AuditFilter{
+		Action:    c.QueryParam("action"),
+		Outcome:   c.QueryParam("outcome"),
+		Token:     c.QueryParam("token"),
+		Namespace: c.QueryParam("namespace"),
+		Name:      c.QueryParam("name"),
+		Version:   c.QueryParam("version"),
+	}
+
+	for param, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.QueryParam(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "invalid "+param+" parameter, expected an RFC3339 timestamp")
+			}
+			*target = t
+		}
+	}
+
+	// Export all matching entries as JSON lines.
+	if c.QueryParam("format") == "jsonl" {
+		c.Response().Header().Set(echo.HeaderContentType, "application/x-ndjson")
+		c.Response().WriteHeader(http.StatusOK)
+
+		encoder := json.NewEncoder(c.Response())
+
+		err := srv.Audit.Query(filter, func(entry AuditEntry) bool {
+			return encoder.Encode(entry) == nil
+		})
+		if err != nil {
+			log.Println(err)
+		}
+
+		return nil
+	}
+
+	limit := 100
+	if value := c.QueryParam("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit parameter")
+		}
+	}
+
+	result := []AuditEntry{}
+
+	err := srv.Audit.QueryNewest(filter, func(entry AuditEntry) bool {
+		result = append(result, entry)
+		return len(result) < limit
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
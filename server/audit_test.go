@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+func TestAuditLog(t *testing.T) {
+	srv := &Server{Audit: &AuditLog{file: filepath.Join(t.TempDir(), "audit.jsonl")}}
+
+	e := echo.New()
+	e.Use(srv.auditRequest)
+	e.PUT("/:namespace/:name/:version", func(c echo.Context) error {
+		c.Set(auditDigestKey, "sha256:abc")
+		return c.NoContent(http.StatusOK)
+	})
+	e.DELETE("/:namespace/:name/:version", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusForbidden, "missing permission delete")
+	})
+	e.GET("/:namespace/:name/:version", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.POST("/:namespace/:name/_tidy", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusForbidden, "missing permission delete")
+	})
+
+	for _, method := range []string{"PUT", "DELETE", "GET"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/ns/app/1.0.0", nil))
+	}
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ns/app/_tidy?keep=1", nil))
+
+	entries := []AuditEntry{}
+	srv.Audit.Query(AuditFilter{Namespace: "ns"}, func(entry AuditEntry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+
+	if e := entries[0]; e.Action != AuditPush || e.Outcome != AuditSuccess || e.Digest != "sha256:abc" || e.Version != "1.0.0" {
+		t.Errorf("unexpected push entry %+v", e)
+	}
+	if e := entries[1]; e.Action != AuditDelete || e.Outcome != AuditDenied || e.Status != http.StatusForbidden {
+		t.Errorf("unexpected delete entry %+v", e)
+	}
+	if e := entries[2]; e.Action != AuditTidy || e.Outcome != AuditDenied || e.Name != "app" {
+		t.Errorf("unexpected tidy entry %+v", e)
+	}
+}
+
+func TestAuditAccess(t *testing.T) {
+	srv := &Server{Audit: &AuditLog{file: filepath.Join(t.TempDir(), "audit.jsonl")}}
+
+	e := echo.New()
+	e.Use(srv.auditRequest)
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if c.Request().Header.Get("Authorization") != "" {
+				c.Set("user", &jwt.Token{Claims: jwt.MapClaims{"name": "ci", "sub": "repo:myorg/app", "jti": "123"}, Valid: true})
+			}
+			return next(c)
+		}
+	})
+	e.GET("/:namespace/:name/:version", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/public/app/1.0.0", nil))
+
+	request := httptest.NewRequest(http.MethodGet, "/ns/app/1.0.0", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	e.ServeHTTP(httptest.NewRecorder(), request)
+
+	entries := []AuditEntry{}
+	srv.Audit.Query(AuditFilter{Token: "repo:myorg/app"}, func(entry AuditEntry) bool {
+		entries = append(entries, entry)
+		return true
+	})
+
+	if len(entries) != 1 {
+		t.Fatalf("expected only the authenticated request to be recorded, got %+v", entries)
+	}
+	if e := entries[0]; e.Action != AuditAccess || e.Outcome != AuditSuccess || e.TokenId != "123" || e.Path != "/ns/app/1.0.0" {
+		t.Errorf("unexpected access entry %+v", e)
+	}
+}
+
+func TestQueryAuditLogNewest(t *testing.T) {
+	srv := &Server{Audit: &AuditLog{file: filepath.Join(t.TempDir(), "audit.jsonl")}}
+
+	// Long messages spread the entries over several blocks.
+	for i := 0; i < 200; i++ {
+		srv.Audit.Record(AuditEntry{Action: AuditPush, Version: "1.0." + strconv.Itoa(i), Message: strings.Repeat("x", 1000)})
+	}
+
+	e := echo.New()
+	e.GET("/_admin/audit", srv.queryAuditLog)
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/_admin/audit?limit=150", nil))
+
+	entries := []AuditEntry{}
+	json.Unmarshal(recorder.Body.Bytes(), &entries)
+
+	if len(entries) != 150 {
+		t.Fatalf("expected 150 entries, got %d", len(entries))
+	}
+	for i, entry := range entries {
+		if expected := "1.0." + strconv.Itoa(199-i); entry.Version != expected {
+			t.Fatalf("expected entry %d to be %s, got %s", i, expected, entry.Version)
+		}
+	}
+
+	count := 0
+	srv.Audit.QueryNewest(AuditFilter{}, func(entry AuditEntry) bool {
+		count++
+		return true
+	})
+	if count != 200 {
+		t.Errorf("expected all 200 entries, got %d", count)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	myMiddleware "github.com/sevensolutions/tiny-repo/middleware"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+type ListNamespacesResponse struct {
+	Count      int      `json:"count"`
+	Namespaces []string `json:"namespaces"`
+}
+
+type ListArtifactsResponse struct {
+	Namespace string            `json:"namespace"`
+	Count     int               `json:"count"`
+	Artifacts []ArtifactSummary `json:"artifacts"`
+}
+
+type ArtifactSummary struct {
+	Name     string `json:"name"`
+	Latest   string `json:"latest"`
+	Versions int    `json:"versions"`
+}
+
+func (srv *Server) catalog() (storage.Catalog, error) {
+	catalog, ok := srv.Storage.(storage.Catalog)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusNotImplemented, storage.ErrCatalogNotSupported.Error())
+	}
+
+	return catalog, nil
+}
+
+// listNamespaces returns all namespaces containing artifacts the caller can read.
+func (srv *Server) listNamespaces(c echo.Context) error {
+	catalog, err := srv.catalog()
+	if err != nil {
+		return err
+	}
+
+	namespaces, err := catalog.ListNamespaces()
+	if err != nil {
+		return err
+	}
+
+	namespaces = core.FilterArray(namespaces, func(namespace string) bool {
+		return myMiddleware.CanRead(c, srv.PublicRead, namespace, "")
+	})
+
+	return c.JSON(http.StatusOK, &ListNamespacesResponse{
+		Count:      len(namespaces),
+		Namespaces: namespaces,
+	})
+}
+
+// listArtifacts returns the artifacts of a namespace the caller can read, along with their latest version.
+func (srv *Server) listArtifacts(c echo.Context) error {
+	namespace := c.Param("namespace")
+	if strings.HasPrefix(namespace, "_") || strings.HasPrefix(namespace, ".") {
+		return echo.NewHTTPError(http.StatusNotFound, "namespace not found")
+	}
+
+	catalog, err := srv.catalog()
+	if err != nil {
+		return err
+	}
+
+	names, err := catalog.ListArtifacts(namespace)
+	if err != nil {
+		return err
+	}
+
+	artifacts := []ArtifactSummary{}
+
+	for _, name := range names {
+		if !myMiddleware.CanRead(c, srv.PublicRead, namespace, name) {
+			continue
+		}
+
+		versions, err := storage.GetSortedVersions(srv.Storage, core.ArtifactSpec{Namespace: namespace, Name: name})
+		if err != nil {
+			return err
+		}
+
+		// Artifacts, whose versions have all been deleted, may leave an empty folder behind.
+		if len(versions) == 0 {
+			continue
+		}
+
+		artifacts = append(artifacts, ArtifactSummary{Name: name, Latest: versions[0].String(), Versions: len(versions)})
+	}
+
+	if len(artifacts) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "namespace not found")
+	}
+
+	return c.JSON(http.StatusOK, &ListArtifactsResponse{
+		Namespace: namespace,
+		Count:     len(artifacts),
+		Artifacts: artifacts,
+	})
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// loadIPExtractor determines how the client IP of a request is found, which is used for rate limits and the audit log.
+// Without TRUSTED_PROXIES, the address of the connection is used and forwarding headers are ignored, as anyone could set them.
+func loadIPExtractor() echo.IPExtractor {
+	value := core.GetEnvVar("TRUSTED_PROXIES", "")
+	if value == "" {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+
+		_, ipRange, err := net.ParseCIDR(item)
+		if err != nil {
+			panic(fmt.Sprintf("Invalid TRUSTED_PROXIES: %s", err))
+		}
+
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...)
+}
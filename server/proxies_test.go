@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPExtractor(t *testing.T) {
+	cases := []struct {
+		proxies  string
+		remote   string
+		expected string
+	}{
+		{"", "10.1.2.3:1234", "10.1.2.3"},
+		{"10.0.0.0/8", "10.1.2.3:1234", "1.2.3.4"},
+		{"10.0.0.0/8", "192.168.1.1:1234", "192.168.1.1"},
+		{"10.1.2.3", "10.1.2.3:1234", "1.2.3.4"},
+		{"10.1.2.4", "10.1.2.3:1234", "10.1.2.3"},
+	}
+
+	for i, c := range cases {
+		t.Setenv("TRUSTED_PROXIES", c.proxies)
+
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = c.remote
+		request.Header.Set("X-Forwarded-For", "1.2.3.4")
+		request.Header.Set("X-Real-Ip", "1.2.3.4")
+
+		if ip := loadIPExtractor()(request); ip != c.expected {
+			t.Errorf("case %d: expected %s, got %s", i, c.expected, ip)
+		}
+	}
+}
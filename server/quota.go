@@ -0,0 +1,221 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	ospath "path"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+// Quota limits the storage of all namespaces matching the glob Namespace. Zero means unlimited.
+type Quota struct {
+	Namespace   string        `json:"namespace"`
+	MaxBytes    core.ByteSize `json:"maxBytes,omitempty"`
+	MaxVersions int           `json:"maxVersions,omitempty"`
+	MaxBlobSize core.ByteSize `json:"maxBlobSize,omitempty"`
+}
+
+// Quotas are matched in order, the first matching quota applies.
+type Quotas []Quota
+
+type UsageResponse struct {
+	Namespace string         `json:"namespace"`
+	Usage     *storage.Usage `json:"usage,omitempty"`
+	Quota     *Quota         `json:"quota,omitempty"`
+}
+
+// LoadQuotas reads the quotas configured in QUOTAS_FILE.
+func LoadQuotas(adapter storage.StorageAdapter) Quotas {
+	file := core.GetEnvVar("QUOTAS_FILE", "")
+	if file == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		panic(err)
+	}
+
+	quotas := Quotas{}
+	if err := json.Unmarshal(content, &quotas); err != nil {
+		panic(fmt.Sprintf("Invalid QUOTAS_FILE: %s", err))
+	}
+
+	for _, quota := range quotas {
+		if _, err := ospath.Match(quota.Namespace, ""); err != nil || quota.Namespace == "" {
+			panic(fmt.Sprintf("Invalid quota namespace %s", quota.Namespace))
+		}
+	}
+
+	if _, ok := adapter.(storage.UsageReporter); !ok && len(quotas) > 0 {
+		panic("The storage backend doesn't support quotas.")
+	}
+
+	return quotas
+}
+
+func (q Quotas) find(namespace string) *Quota {
+	for i, quota := range q {
+		if matched, _ := ospath.Match(quota.Namespace, namespace); matched {
+			return &q[i]
+		}
+	}
+
+	return nil
+}
+
+// usageCacheTtl is how long the usage of a namespace is cached, before it's calculated again to notice changes of other instances.
+const usageCacheTtl = time.Minute
+
+// namespaceUsage is the cached usage of a namespace and the size of the uploads, which have passed the quota check,
+// but haven't been committed yet. Its mutex serializes the quota checks of the namespace.
+type namespaceUsage struct {
+	mutex            sync.Mutex
+	usage            storage.Usage
+	loadedAt         time.Time
+	reservedBytes    int64
+	reservedVersions int
+}
+
+// invalidateUsage drops the cached usage of a namespace after versions have been deleted.
+func (srv *Server) invalidateUsage(namespace string) {
+	if entry, ok := srv.usage.Load(namespace); ok {
+		entry := entry.(*namespaceUsage)
+		entry.mutex.Lock()
+		entry.loadedAt = time.Time{}
+		entry.mutex.Unlock()
+	}
+}
+
+// checkQuota rejects an upload to a version, if it would exceed the quota of its namespace.
+// size is the size of the blob or -1, if it is unknown, eg. when starting a presigned upload or an upload session.
+// Those are checked again, once their size is known.
+func (srv *Server) checkQuota(spec core.ArtifactVersionSpec, size int64) error {
+	release, err := srv.reserveQuota(spec, size)
+	if err != nil {
+		return err
+	}
+
+	release(false)
+
+	return nil
+}
+
+// reserveQuota checks the quota like checkQuota and reserves the size of the upload until release is called,
+// so concurrent uploads can't exceed the quota together. release must be called with whether the upload has been committed.
+func (srv *Server) reserveQuota(spec core.ArtifactVersionSpec, size int64) (release func(committed bool), err error) {
+	release = func(bool) {}
+
+	quota := srv.Quotas.find(spec.Namespace)
+	if quota == nil {
+		return release, nil
+	}
+
+	if quota.MaxBlobSize > 0 && size > int64(quota.MaxBlobSize) {
+		return release, echo.NewHTTPError(http.StatusRequestEntityTooLarge, fmt.Sprintf("the blob exceeds the maximum size of %d bytes", quota.MaxBlobSize))
+	}
+
+	if quota.MaxBytes == 0 && quota.MaxVersions == 0 {
+		return release, nil
+	}
+
+	value, _ := srv.usage.LoadOrStore(spec.Namespace, &namespaceUsage{})
+	entry := value.(*namespaceUsage)
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	if time.Since(entry.loadedAt) > usageCacheTtl {
+		usage, err := srv.Storage.(storage.UsageReporter).NamespaceUsage(spec.Namespace)
+		if err != nil {
+			return release, err
+		}
+
+		entry.usage = usage
+		entry.loadedAt = time.Now()
+	}
+
+	reporter := srv.Storage.(storage.UsageReporter)
+
+	// Overwriting an existing version replaces its bytes and doesn't add a new version.
+	replaced, exists, err := reporter.VersionUsage(spec)
+	if err != nil {
+		return release, err
+	}
+
+	size = max(size, 0)
+	added := 0
+	if !exists {
+		added = 1
+	}
+
+	if quota.MaxBytes > 0 && entry.usage.Bytes+entry.reservedBytes+size-replaced > int64(quota.MaxBytes) {
+		return release, echo.NewHTTPError(http.StatusInsufficientStorage, fmt.Sprintf("the namespace %s exceeds its quota of %d bytes", spec.Namespace, quota.MaxBytes))
+	}
+
+	if quota.MaxVersions > 0 && entry.usage.Versions+entry.reservedVersions+added > quota.MaxVersions {
+		return release, echo.NewHTTPError(http.StatusInsufficientStorage, fmt.Sprintf("the namespace %s exceeds its quota of %d versions", spec.Namespace, quota.MaxVersions))
+	}
+
+	entry.reservedBytes += size
+	entry.reservedVersions += added
+
+	var once sync.Once
+
+	return func(committed bool) {
+		once.Do(func() {
+			entry.mutex.Lock()
+			defer entry.mutex.Unlock()
+
+			entry.reservedBytes -= size
+			entry.reservedVersions -= added
+
+			if !committed {
+				return
+			}
+
+			// The stored size differs from the uploaded one, if the blob has been compressed or encrypted.
+			stored, _, err := reporter.VersionUsage(spec)
+			if err != nil {
+				entry.loadedAt = time.Time{}
+				return
+			}
+
+			entry.usage.Bytes += stored - replaced
+			entry.usage.Versions += added
+		})
+	}, nil
+}
+
+// requiresContentLength reports whether uploads to the namespace must declare their size upfront.
+func (srv *Server) requiresContentLength(namespace string) bool {
+	quota := srv.Quotas.find(namespace)
+
+	return quota != nil && (quota.MaxBytes > 0 || quota.MaxBlobSize > 0)
+}
+
+func (srv *Server) getUsage(c echo.Context) error {
+	namespace := c.Param("namespace")
+
+	response := &UsageResponse{
+		Namespace: namespace,
+		Quota:     srv.Quotas.find(namespace),
+	}
+
+	if reporter, ok := srv.Storage.(storage.UsageReporter); ok {
+		usage, err := reporter.NamespaceUsage(namespace)
+		if err != nil {
+			return err
+		}
+		response.Usage = &usage
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+func TestQuota(t *testing.T) {
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+
+	srv := &Server{
+		Storage: storage.LocalDirectory(),
+		Audit:   &AuditLog{},
+		Quotas: Quotas{
+			{Namespace: "team-*", MaxBytes: 10, MaxVersions: 2, MaxBlobSize: 6},
+		},
+	}
+
+	e := echo.New()
+	e.PUT("/:namespace/:name/:version", srv.upload)
+
+	push := func(path string, body string) int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	cases := []struct {
+		path     string
+		body     string
+		expected int
+	}{
+		{"/team-a/app/1.0.0", "1234567", http.StatusRequestEntityTooLarge},
+		{"/team-a/app/1.0.0", "123456", http.StatusOK},
+		{"/team-a/app/1.0.1", "12345", http.StatusInsufficientStorage},
+		{"/team-a/app/1.0.1", "1234", http.StatusOK},
+		{"/team-a/app/1.0.2", "", http.StatusInsufficientStorage},
+		{"/team-a/app/1.0.1", "", http.StatusOK},
+		{"/team-a/app/1.0.1", "1234", http.StatusOK},
+		// The replaced version doesn't count, when a version is overwritten in a full namespace.
+		{"/team-a/app/1.0.0", "654321", http.StatusOK},
+		{"/team-a/app/1.0.0", "6543210", http.StatusRequestEntityTooLarge},
+		{"/other/app/1.0.0", "12345678901", http.StatusOK},
+	}
+
+	for i, c := range cases {
+		if code := push(c.path, c.body); code != c.expected {
+			t.Errorf("case %d: expected %d, got %d", i, c.expected, code)
+		}
+	}
+
+	usage, _ := srv.Storage.(storage.UsageReporter).NamespaceUsage("team-a")
+	if usage.Bytes != 10 || usage.Versions != 2 {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+}
+
+func TestQuotaReservations(t *testing.T) {
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+
+	srv := &Server{
+		Storage: storage.LocalDirectory(),
+		Quotas: Quotas{
+			{Namespace: "team-*", MaxBytes: 10, MaxVersions: 2},
+		},
+	}
+
+	spec := func(version string) core.ArtifactVersionSpec {
+		return core.ArtifactVersionSpec{ArtifactSpec: core.ArtifactSpec{Namespace: "team-a", Name: "app"}, Version: semver.MustParse(version)}
+	}
+
+	release, err := srv.reserveQuota(spec("1.0.0"), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first upload is still in progress, so its size counts for the second one.
+	if _, err := srv.reserveQuota(spec("1.0.1"), 6); err == nil {
+		t.Error("expected concurrent uploads to be limited by the quota")
+	}
+
+	if err := srv.Storage.Upload(context.Background(), spec("1.0.0"), core.BlobMeta{}, strings.NewReader("123456")); err != nil {
+		t.Fatal(err)
+	}
+	release(true)
+	release(true)
+
+	if err := srv.checkQuota(spec("1.0.1"), 4); err != nil {
+		t.Errorf("expected the remaining bytes to be available, got %v", err)
+	}
+	if err := srv.checkQuota(spec("1.0.1"), 5); err == nil {
+		t.Error("expected the committed upload to be counted")
+	}
+
+	// Deleted versions are noticed without waiting for the cache to expire.
+	srv.Storage.DeleteVersion(spec("1.0.0"))
+	srv.invalidateUsage("team-a")
+
+	if err := srv.checkQuota(spec("1.0.1"), 10); err != nil {
+		t.Errorf("expected the deleted bytes to be available, got %v", err)
+	}
+}
+
+func TestQuotaUploadSessions(t *testing.T) {
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+
+	srv := &Server{
+		Storage: storage.LocalDirectory(),
+		Audit:   &AuditLog{},
+		Quotas: Quotas{
+			{Namespace: "team-*", MaxBytes: 10, MaxBlobSize: 6},
+		},
+	}
+
+	e := echo.New()
+	e.POST("/:namespace/:name/:version/uploads", srv.startUploadSession)
+	e.GET("/:namespace/:name/:version/uploads/:sessionId", srv.getUploadSession)
+	e.PATCH("/:namespace/:name/:version/uploads/:sessionId", srv.writeUploadChunk)
+	e.PUT("/:namespace/:name/:version/uploads/:sessionId", srv.finalizeUploadSession)
+
+	serve := func(method string, path string, body string, header string, value string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if header != "" {
+			request.Header.Set(header, value)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, request)
+		return rec
+	}
+
+	start := func(path string) string {
+		rec := serve(http.MethodPost, path+"/uploads", "", "", "")
+		session := storage.UploadSession{}
+		json.Unmarshal(rec.Body.Bytes(), &session)
+		if rec.Code != http.StatusCreated || session.Id == "" {
+			t.Fatalf("failed to start a session: %d", rec.Code)
+		}
+
+		return path + "/uploads/" + session.Id
+	}
+
+	finalize := func(path string, content string) int {
+		hash := sha256.Sum256([]byte(content))
+		body := `{"hash":"sha256:` + hex.EncodeToString(hash[:]) + `"}`
+
+		return serve(http.MethodPut, path, body, echo.HeaderContentType, echo.MIMEApplicationJSON).Code
+	}
+
+	// A chunk exceeding the maximum blob size is rejected before it is written.
+	first := start("/team-a/app/1.0.0")
+	if code := serve(http.MethodPatch, first, "1234567", "Upload-Offset", "0").Code; code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected %d for an oversized chunk, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+
+	// Both sessions fit into the quota on their own, but not together.
+	second := start("/team-a/app/1.0.1")
+	serve(http.MethodPatch, first, "123456", "Upload-Offset", "0")
+	serve(http.MethodPatch, second, "12345", "Upload-Offset", "0")
+
+	if code := finalize(first, "123456"); code != http.StatusOK {
+		t.Fatalf("expected the first session to be finalized, got %d", code)
+	}
+
+	if code := finalize(second, "12345"); code != http.StatusInsufficientStorage {
+		t.Errorf("expected %d when finalizing exceeds the quota, got %d", http.StatusInsufficientStorage, code)
+	}
+
+	if code := serve(http.MethodGet, second, "", "", "").Code; code != http.StatusNotFound {
+		t.Errorf("expected the rejected session to be aborted, got %d", code)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+type ReserveVersionRequest struct {
+	Bump       string `json:"bump"`
+	Prerelease string `json:"prerelease"`
+}
+
+// reserveVersion computes the next free version of an artifact and reserves it,
+// so concurrent requests never get the same version.
+func (srv *Server) reserveVersion(c echo.Context) error {
+	spec, err := core.ParseArtifactSpecFromEcho(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	request := ReserveVersionRequest{}
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	bump, err := core.ParseBump(request.Bump)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := core.ValidatePrereleaseId(request.Prerelease); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	token := ""
+	if user, ok := c.Get("user").(*jwt.Token); ok {
+		if claims, ok := user.Claims.(jwt.MapClaims); ok {
+			token, _ = claims["name"].(string)
+		}
+	}
+
+	reservation, err := storage.Reserve(srv.Storage, spec, token, srv.ReservationTtl, func(taken []*semver.Version) (*semver.Version, error) {
+		return core.NextVersion(taken, bump, request.Prerelease)
+	})
+	if errors.Is(err, storage.ErrReservationsNotSupported) {
+		return echo.NewHTTPError(http.StatusNotImplemented, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Set(auditVersionKey, reservation.Version)
+
+	return c.JSON(http.StatusCreated, reservation)
+}
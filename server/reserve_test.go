@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+func TestReserveVersion(t *testing.T) {
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+
+	srv := &Server{
+		Storage:        storage.LocalDirectory(),
+		Audit:          &AuditLog{},
+		ReservationTtl: time.Hour,
+	}
+
+	e := echo.New()
+	e.PUT("/:namespace/:name/:version", srv.upload)
+	e.POST("/:namespace/:name/_reserve", srv.reserveVersion)
+
+	reserve := func(body string) (int, string) {
+		request := httptest.NewRequest(http.MethodPost, "/ns/app/_reserve", strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, request)
+
+		reservation := storage.Reservation{}
+		json.Unmarshal(rec.Body.Bytes(), &reservation)
+
+		return rec.Code, reservation.Version
+	}
+
+	for _, version := range []string{"1.2.0", "1.3.0-rc.1"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/ns/app/"+version, strings.NewReader("x")))
+	}
+
+	cases := []struct {
+		body     string
+		expected string
+	}{
+		{`{}`, "1.3.0"},
+		{`{}`, "1.3.1"},
+		{`{"bump":"minor"}`, "1.4.0"},
+		{`{"prerelease":"rc"}`, "1.4.1-rc.1"},
+		{`{"bump":"major","prerelease":"beta"}`, "2.0.0-beta.1"},
+		{`{"bump":"major","prerelease":"beta"}`, "2.0.0-beta.2"},
+	}
+
+	for i, c := range cases {
+		if code, version := reserve(c.body); code != http.StatusCreated || version != c.expected {
+			t.Errorf("case %d: expected %s, got %d %s", i, c.expected, code, version)
+		}
+	}
+
+	if code, _ := reserve(`{"bump":"huge"}`); code != http.StatusBadRequest {
+		t.Errorf("expected %d for an invalid bump, got %d", http.StatusBadRequest, code)
+	}
+
+	// Concurrent reservations never get the same version.
+	versions := sync.Map{}
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, version := reserve(`{"bump":"patch"}`)
+			if _, duplicate := versions.LoadOrStore(version, true); duplicate {
+				t.Errorf("version %s was reserved twice", version)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -1,12 +1,18 @@
 package server
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/Masterminds/semver/v3"
-	echojwt "github.com/labstack/echo-jwt"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/sevensolutions/tiny-repo/core"
@@ -15,7 +21,21 @@ import (
 )
 
 type Server struct {
-	Storage storage.StorageAdapter
+	Storage     storage.StorageAdapter
+	Revocations *myMiddleware.RevocationStore
+	// KeyFunc verifies the signature of tokens, the same way as the authentication does.
+	KeyFunc    jwt.Keyfunc
+	Audit      *AuditLog
+	Quotas     Quotas
+	PublicRead myMiddleware.PublicRead
+	// ReservationTtl is how long a version reserved as next version is kept from being reserved again.
+	ReservationTtl time.Duration
+	// LongPollTimeout limits how long a conditional request waits for a change.
+	LongPollTimeout time.Duration
+
+	changes changeNotifier
+	// usage caches the *namespaceUsage of namespaces with a quota.
+	usage sync.Map
 }
 
 func (srv *Server) upload(c echo.Context) error {
@@ -30,62 +50,247 @@ func (srv *Server) upload(c echo.Context) error {
 
 	ctx := c.Request().Context()
 
-	err = srv.Storage.Upload(ctx, spec, c, c.Request().Body)
+	labels, err := core.ParseLabels(c.Request().Header.Values("X-Label"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	meta := core.BlobMeta{
+		OriginalFilename: c.Param("filename"),
+		ContentType:      c.Request().Header.Get("Content-Type"),
+		Hash:             c.Request().Header.Get(core.HeaderContentHash),
+		Labels:           labels,
+	}
 
+	tidyKeep, err := parseKeepParam(c)
 	if err != nil {
 		return err
 	}
 
-	tidyKeep := 0
-	keepParam := c.QueryParam("keep")
-	if keepParam != "" {
-		keep, err := strconv.ParseInt(keepParam, 10, 32)
-		if err != nil || keep < 0 {
-			return echo.NewHTTPError(http.StatusBadRequest, "invalid keep parameter")
-		}
-		tidyKeep = int(keep)
+	// Quotas are checked before anything is written.
+	size := c.Request().ContentLength
+	if size < 0 && srv.requiresContentLength(spec.Namespace) {
+		return echo.NewHTTPError(http.StatusLengthRequired, "the namespace has a storage quota, uploads need a Content-Length")
+	}
+
+	release, err := srv.reserveQuota(spec, size)
+	if err != nil {
+		return err
 	}
 
-	if tidyKeep > 0 {
-		go func() {
-			err = storage.Tidy(srv.Storage, spec.ArtifactSpec, tidyKeep, &spec)
-			if err != nil {
-				log.Println(err)
-			}
-		}()
+	hasher := sha256.New()
+
+	// The client may send the hash of the content to detect corrupted uploads, which is verified by the storage.
+	err = srv.Storage.Upload(ctx, spec, meta, io.TeeReader(c.Request().Body, hasher))
+	release(err == nil)
+
+	if errors.Is(err, storage.ErrDigestMismatch) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	if err != nil {
+		return err
+	}
+
+	c.Set(auditDigestKey, "sha256:"+hex.EncodeToString(hasher.Sum(nil)))
+
+	srv.tidyAfterUpload(c, spec, tidyKeep)
 
 	return c.NoContent(http.StatusOK)
 }
 
-func (srv *Server) download(c echo.Context) error {
-	ctx := c.Request().Context()
+type PresignUploadResponse struct {
+	UploadId    string    `json:"uploadId"`
+	Method      string    `json:"method"`
+	Url         string    `json:"url"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	CompleteUrl string    `json:"completeUrl"`
+}
 
+type CompleteUploadRequest struct {
+	Filename    string            `json:"filename"`
+	ContentType string            `json:"contentType"`
+	Hash        string            `json:"hash"`
+	Labels      map[string]string `json:"labels"`
+}
+
+func (srv *Server) parsePresignedRequest(c echo.Context) (storage.PresignedUploader, core.ArtifactVersionSpec, error) {
 	spec, err := core.ParseVersionSpecFromEcho(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return nil, spec, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	if spec.Latest {
-		versions, err := storage.GetSortedVersions(srv.Storage, spec.ArtifactSpec)
-		if err != nil {
-			return err
+		return nil, spec, echo.NewHTTPError(http.StatusBadRequest, "uploading to latest version is not allowed")
+	}
+
+	uploader, ok := srv.Storage.(storage.PresignedUploader)
+	if !ok {
+		return nil, spec, echo.NewHTTPError(http.StatusNotImplemented, "the storage backend does not support presigned uploads")
+	}
+
+	return uploader, spec, nil
+}
+
+func (srv *Server) presignUpload(c echo.Context) error {
+	uploader, spec, err := srv.parsePresignedRequest(c)
+	if err != nil {
+		return err
+	}
+
+	if err := srv.checkQuota(spec, -1); err != nil {
+		return err
+	}
+
+	upload, err := uploader.PresignUpload(c.Request().Context(), spec)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage backend does not support presigned uploads")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, &PresignUploadResponse{
+		UploadId:    upload.UploadId,
+		Method:      upload.Method,
+		Url:         upload.Url,
+		ExpiresAt:   upload.ExpiresAt,
+		CompleteUrl: c.Request().URL.Path + "/" + upload.UploadId,
+	})
+}
+
+func (srv *Server) completePresignedUpload(c echo.Context) error {
+	uploader, spec, err := srv.parsePresignedRequest(c)
+	if err != nil {
+		return err
+	}
+
+	request := CompleteUploadRequest{}
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	tidyKeep, err := parseKeepParam(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	uploadId := c.Param("uploadId")
+
+	// The size of a presigned upload is only known after it has been uploaded.
+	size, err := uploader.UploadSize(ctx, spec, uploadId)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage backend does not support presigned uploads")
+	}
+	if errors.Is(err, storage.ErrUploadNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	release, err := srv.reserveQuota(spec, size)
+	if err != nil {
+		if err := uploader.AbortUpload(ctx, spec, uploadId); err != nil {
+			log.Println(err)
 		}
 
-		if len(versions) > 0 {
-			spec = core.ArtifactVersionSpec{
-				ArtifactSpec: spec.ArtifactSpec,
-				Version:      versions[0],
-				Latest:       false,
-			}
-		} else {
-			return c.NoContent(http.StatusNotFound)
+		return err
+	}
+
+	meta, err := uploader.CompleteUpload(ctx, spec, uploadId, core.BlobMeta{
+		OriginalFilename: request.Filename,
+		ContentType:      request.ContentType,
+		Hash:             request.Hash,
+		Size:             size,
+		Labels:           request.Labels,
+	})
+	release(err == nil)
+
+	if errors.Is(err, storage.ErrUploadNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, storage.ErrDigestMismatch) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, storage.ErrSizeMismatch) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Set(auditDigestKey, meta.Hash)
+
+	srv.tidyAfterUpload(c, spec, tidyKeep)
+
+	return c.JSON(http.StatusOK, meta)
+}
+
+func (srv *Server) abortPresignedUpload(c echo.Context) error {
+	uploader, spec, err := srv.parsePresignedRequest(c)
+	if err != nil {
+		return err
+	}
+
+	err = uploader.AbortUpload(c.Request().Context(), spec, c.Param("uploadId"))
+	if errors.Is(err, errors.ErrUnsupported) {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage backend does not support presigned uploads")
+	}
+	if errors.Is(err, storage.ErrUploadNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func parseKeepParam(c echo.Context) (int, error) {
+	keepParam := c.QueryParam("keep")
+	if keepParam == "" {
+		return 0, nil
+	}
+
+	keep, err := strconv.ParseInt(keepParam, 10, 32)
+	if err != nil || keep < 0 {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "invalid keep parameter")
+	}
+
+	return int(keep), nil
+}
+
+func (srv *Server) tidyAfterUpload(c echo.Context, spec core.ArtifactVersionSpec, keep int) {
+	if keep <= 0 {
+		return
+	}
+
+	// The entry must be created before the request completes.
+	audit := srv.auditEntry(c, AuditTidy)
+
+	go func() {
+		deleted, err := storage.Tidy(srv.Storage, spec.ArtifactSpec, keep, &spec)
+		srv.invalidateUsage(spec.Namespace)
+		srv.recordDeletedVersions(audit, AuditTidy, deleted)
+		srv.changes.notify(spec.ArtifactSpec)
+		if err != nil {
+			log.Println(err)
 		}
+	}()
+}
+
+func (srv *Server) download(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	spec, err := srv.resolveVersionSpec(c)
+	if err != nil {
+		return err
 	}
 
 	err = srv.Storage.Download(ctx, spec, c)
 	if err != nil {
-		return nil
+		return err
 	}
 
 	return c.NoContent(http.StatusNotFound)
@@ -103,24 +308,24 @@ func (srv *Server) getVersions(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	versions, err := storage.GetSortedVersions(srv.Storage, spec)
-	if err != nil {
-		return err
-	}
-
-	if len(versions) == 0 {
-		return c.NoContent(http.StatusNotFound)
-	}
+	return srv.conditionalJSON(c, spec, func() (interface{}, error) {
+		versions, err := storage.GetSortedVersions(srv.Storage, spec)
+		if err != nil {
+			return nil, err
+		}
 
-	response := &GetVersionsResponse{
-		Count:  len(versions),
-		Latest: versions[0].String(),
-		Versions: core.MapArray(versions, func(v *semver.Version) string {
-			return v.String()
-		}),
-	}
+		if len(versions) == 0 {
+			return nil, echo.NewHTTPError(http.StatusNotFound)
+		}
 
-	return c.JSON(http.StatusOK, response)
+		return &GetVersionsResponse{
+			Count:  len(versions),
+			Latest: versions[0].String(),
+			Versions: core.MapArray(versions, func(v *semver.Version) string {
+				return v.String()
+			}),
+		}, nil
+	})
 }
 
 func (srv *Server) deleteVersion(c echo.Context) error {
@@ -129,6 +334,8 @@ func (srv *Server) deleteVersion(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	defer srv.invalidateUsage(spec.Namespace)
+
 	return srv.Storage.DeleteVersion(spec)
 }
 
@@ -138,53 +345,130 @@ func (srv *Server) deleteArtifact(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	err = storage.Tidy(srv.Storage, spec, 0, nil)
-	if err != nil {
-		return err
-	}
+	deleted, err := storage.Tidy(srv.Storage, spec, 0, nil)
+	srv.invalidateUsage(spec.Namespace)
+	srv.recordDeletedVersions(srv.auditEntry(c, AuditDelete), AuditDelete, deleted)
 
-	return nil
+	return err
 }
 
-func createStorageAdapter() storage.StorageAdapter {
+func CreateStorageAdapter() storage.StorageAdapter {
 	adapterType := core.GetRequiredEnvVar("STORAGE_TYPE")
 
+	var adapter storage.StorageAdapter
+
 	switch adapterType {
 	case "Local":
-		return storage.LocalDirectory()
+		adapter = storage.LocalDirectory()
 	case "S3":
-		return storage.MinIO()
+		adapter = storage.MinIO()
 	default:
 		panic("Invalid STORAGE_TYPE. Only Local or S3 are supported.")
 	}
+
+	if core.GetEnvVar("UPSTREAM_URL", "") != "" {
+		adapter = storage.Proxy(adapter)
+	}
+
+	return adapter
 }
 
 func (srv *Server) Run() {
 	printBanner()
 
-	srv.Storage = createStorageAdapter()
+	srv.Storage = CreateStorageAdapter()
+
+	srv.Revocations = myMiddleware.Revocations(srv.Storage)
+	srv.Audit = OpenAuditLog()
+	srv.Quotas = LoadQuotas(srv.Storage)
+	srv.ReservationTtl = core.GetEnvVarDuration("VERSION_RESERVATION_TTL", time.Hour)
+	srv.LongPollTimeout = core.GetEnvVarDuration("LONG_POLL_TIMEOUT", time.Minute)
+
+	go srv.cleanupUploadSessions()
 
 	e := echo.New()
 	e.HideBanner = true
+	e.IPExtractor = loadIPExtractor()
 
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
-	e.Use(echojwt.JWT([]byte(core.GetRequiredEnvVar("JWT_SECRET"))))
-	e.Use(myMiddleware.ValidateAuth)
+	secret := core.GetEnvVar("JWT_SECRET", "")
+	issuers := myMiddleware.LoadTrustedIssuers()
+	apiKeys := myMiddleware.LoadApiKeys()
+	htpasswd := myMiddleware.LoadHtpasswd()
+
+	if secret == "" && len(issuers) == 0 && len(apiKeys) == 0 && htpasswd == nil {
+		panic("Either JWT_SECRET, JWT_TRUSTED_ISSUERS_FILE, API_KEYS_FILE or HTPASSWD_FILE must be configured.")
+	}
 
+	srv.PublicRead = myMiddleware.LoadPublicRead()
+	srv.KeyFunc = issuers.KeyFunc([]byte(secret))
+
+	e.Use(myMiddleware.RateLimitByIp())
+	e.Use(srv.auditRequest)
+	e.Use(myMiddleware.AuthenticateWithConfig(myMiddleware.AuthenticationConfig{
+		KeyFunc:  srv.KeyFunc,
+		ApiKeys:  apiKeys,
+		Htpasswd: htpasswd,
+
+		ClientCertificates: myMiddleware.LoadClientCertificateMappings(),
+	}))
+	e.Use(myMiddleware.RateLimitByToken())
+	e.Use(myMiddleware.ValidateAuthWithConfig(myMiddleware.AuthConfig{
+		Issuer:        core.GetEnvVar("JWT_ISSUER", ""),
+		Audience:      core.GetEnvVar("JWT_AUDIENCE", ""),
+		RequireExpiry: core.GetEnvVarBool("JWT_REQUIRE_EXPIRY", false),
+		Revocations:   srv.Revocations,
+		Issuers:       issuers,
+		PublicRead:    srv.PublicRead,
+	}))
+	e.Use(srv.notifyChanges)
+
+	e.GET(myMiddleware.WhoamiPath, srv.whoami)
+
+	e.GET("/_admin/revoked-tokens", srv.listRevokedTokens)
+	e.POST("/_admin/revoked-tokens", srv.revokeToken)
+	e.GET("/_admin/audit", srv.queryAuditLog)
+	e.GET("/_admin/usage/:namespace", srv.getUsage)
+
+	e.GET(myMiddleware.CatalogPath, srv.listNamespaces)
+	e.GET(myMiddleware.CatalogNamespacePath, srv.listArtifacts)
 	e.GET("/:namespace/:name", srv.getVersions)
+	e.GET("/:namespace/:name/_tags", srv.getTags)
+	e.PUT("/:namespace/:name/_tags/:tag", srv.setTag)
+	e.DELETE("/:namespace/:name/_tags/:tag", srv.deleteTag)
+	e.GET("/:namespace/:name/:version/_meta", srv.getMetadata)
 	e.GET("/:namespace/:name/:version/:filename", srv.download)
 	e.GET("/:namespace/:name/:version", srv.download)
 
 	e.PUT("/:namespace/:name/:version/:filename", srv.upload)
 	e.PUT("/:namespace/:name/:version", srv.upload)
 
+	e.POST("/:namespace/:name/:version/presigned-uploads", srv.presignUpload)
+	e.POST("/:namespace/:name/:version/presigned-uploads/:uploadId", srv.completePresignedUpload)
+	e.DELETE("/:namespace/:name/:version/presigned-uploads/:uploadId", srv.abortPresignedUpload)
+
+	e.POST("/:namespace/:name/:version/uploads", srv.startUploadSession)
+	e.GET("/:namespace/:name/:version/uploads/:sessionId", srv.getUploadSession)
+	e.HEAD("/:namespace/:name/:version/uploads/:sessionId", srv.getUploadSession)
+	e.PATCH("/:namespace/:name/:version/uploads/:sessionId", srv.writeUploadChunk)
+	e.PUT("/:namespace/:name/:version/uploads/:sessionId", srv.finalizeUploadSession)
+	e.DELETE("/:namespace/:name/:version/uploads/:sessionId", srv.abortUploadSession)
+
+	e.POST("/:namespace/:name/_tidy", srv.tidy)
+	e.POST("/:namespace/:name/_reserve", srv.reserveVersion)
 	e.DELETE("/:namespace/:name", srv.deleteArtifact)
 	e.DELETE("/:namespace/:name/:version/:filename", srv.deleteVersion)
 	e.DELETE("/:namespace/:name/:version", srv.deleteVersion)
 
-	e.Logger.Fatal(e.Start(":8080"))
+	address := core.GetEnvVar("LISTEN_ADDRESS", ":8080")
+
+	if tlsConfig := loadTLSConfig(); tlsConfig != nil {
+		e.Logger.Fatal(e.StartServer(&http.Server{Addr: address, TLSConfig: tlsConfig}))
+	} else {
+		e.Logger.Fatal(e.Start(address))
+	}
 }
 
 func printBanner() {
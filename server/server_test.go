@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+// presignedStorage stages presigned uploads in memory, like a bucket would, and verifies them like the MinIO adapter.
+type presignedStorage struct {
+	*storage.LocalDirectoryAdapter
+	staged map[string][]byte
+	// beforeComplete simulates a client replacing the upload after its size has been checked.
+	beforeComplete func(uploadId string)
+}
+
+func (s *presignedStorage) PresignUpload(ctx context.Context, spec core.ArtifactVersionSpec) (storage.PresignedUpload, error) {
+	uploadId := core.RandomId()
+	s.staged[uploadId] = nil
+
+	return storage.PresignedUpload{UploadId: uploadId, Method: http.MethodPut, Url: "https://bucket.example.com/" + uploadId, ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (s *presignedStorage) UploadSize(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string) (int64, error) {
+	content, ok := s.staged[uploadId]
+	if !ok {
+		return 0, storage.ErrUploadNotFound
+	}
+
+	return int64(len(content)), nil
+}
+
+func (s *presignedStorage) CompleteUpload(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string, meta core.BlobMeta) (core.BlobMeta, error) {
+	if s.beforeComplete != nil {
+		s.beforeComplete(uploadId)
+	}
+
+	content, ok := s.staged[uploadId]
+	if !ok {
+		return meta, storage.ErrUploadNotFound
+	}
+	delete(s.staged, uploadId)
+
+	if meta.Size > 0 && meta.Size != int64(len(content)) {
+		return meta, fmt.Errorf("%w: expected %d bytes but got %d", storage.ErrSizeMismatch, meta.Size, len(content))
+	}
+
+	if err := s.Upload(ctx, spec, meta, bytes.NewReader(content)); err != nil {
+		return meta, err
+	}
+
+	hash := sha256.Sum256(content)
+	meta.Hash = "sha256:" + hex.EncodeToString(hash[:])
+
+	return meta, nil
+}
+
+func (s *presignedStorage) AbortUpload(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string) error {
+	if _, ok := s.staged[uploadId]; !ok {
+		return storage.ErrUploadNotFound
+	}
+
+	delete(s.staged, uploadId)
+
+	return nil
+}
+
+func TestPresignedUploads(t *testing.T) {
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+
+	bucket := &presignedStorage{LocalDirectoryAdapter: storage.LocalDirectory(), staged: map[string][]byte{}}
+
+	srv := &Server{
+		Storage: bucket,
+		Audit:   &AuditLog{},
+		Quotas: Quotas{
+			{Namespace: "team-*", MaxBytes: 10},
+		},
+	}
+
+	e := echo.New()
+	e.POST("/:namespace/:name/:version/presigned-uploads", srv.presignUpload)
+	e.POST("/:namespace/:name/:version/presigned-uploads/:uploadId", srv.completePresignedUpload)
+	e.DELETE("/:namespace/:name/:version/presigned-uploads/:uploadId", srv.abortPresignedUpload)
+
+	serve := func(method string, path string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, request)
+		return rec
+	}
+
+	// presign starts an upload and stores the content, as the client would with the presigned URL.
+	presign := func(version string, content string) string {
+		rec := serve(http.MethodPost, "/team-a/app/"+version+"/presigned-uploads", "")
+		response := PresignUploadResponse{}
+		json.Unmarshal(rec.Body.Bytes(), &response)
+
+		if rec.Code != http.StatusOK || response.UploadId == "" || response.CompleteUrl != "/team-a/app/"+version+"/presigned-uploads/"+response.UploadId {
+			t.Fatalf("failed to presign an upload: %d %+v", rec.Code, response)
+		}
+
+		bucket.staged[response.UploadId] = []byte(content)
+
+		return response.CompleteUrl
+	}
+
+	complete := func(path string, content string) int {
+		hash := sha256.Sum256([]byte(content))
+		body := `{"filename":"app.txt","hash":"sha256:` + hex.EncodeToString(hash[:]) + `"}`
+
+		return serve(http.MethodPost, path, body).Code
+	}
+
+	if code := complete(presign("1.0.0", "hello"), "hello"); code != http.StatusOK {
+		t.Fatalf("expected the upload to be completed, got %d", code)
+	}
+
+	cases := []struct {
+		name     string
+		content  string
+		hashOf   string
+		replace  string
+		expected int
+	}{
+		{"digest mismatch", "hello", "other", "", http.StatusBadRequest},
+		{"replaced after the size check", "hello", "hello", "hello world", http.StatusConflict},
+		{"quota exceeded", "123456", "123456", "", http.StatusInsufficientStorage},
+	}
+
+	for _, c := range cases {
+		path := presign("1.0.1", c.content)
+
+		bucket.beforeComplete = func(uploadId string) {
+			if c.replace != "" {
+				bucket.staged[uploadId] = []byte(c.replace)
+			}
+		}
+
+		if code := complete(path, c.hashOf); code != c.expected {
+			t.Errorf("%s: expected %d, got %d", c.name, c.expected, code)
+		}
+		if len(bucket.staged) != 0 {
+			t.Errorf("%s: expected the staged upload to be discarded", c.name)
+		}
+	}
+
+	versions, _ := srv.Storage.GetVersions(core.ArtifactSpec{Namespace: "team-a", Name: "app"})
+	if len(versions) != 1 {
+		t.Errorf("expected only the completed version, got %v", versions)
+	}
+
+	path := presign("1.0.2", "")
+	if code := serve(http.MethodDelete, path, "").Code; code != http.StatusNoContent {
+		t.Errorf("expected the upload to be aborted, got %d", code)
+	}
+	if code := serve(http.MethodDelete, path, "").Code; code != http.StatusNotFound {
+		t.Errorf("expected %d for an aborted upload, got %d", http.StatusNotFound, code)
+	}
+
+	srv.Storage = bucket.LocalDirectoryAdapter
+	if code := serve(http.MethodPost, "/team-a/app/1.0.3/presigned-uploads", "").Code; code != http.StatusNotImplemented {
+		t.Errorf("expected %d without support for presigned uploads, got %d", http.StatusNotImplemented, code)
+	}
+}
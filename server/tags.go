@@ -0,0 +1,217 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+type SetTagRequest struct {
+	Version string `json:"version"`
+}
+
+type MetadataResponse struct {
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Filename    string            `json:"filename,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Hash        string            `json:"hash,omitempty"`
+	Size        int64             `json:"size"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// resolveVersionSpec parses the version of the request, which may also be latest, a tag or a constraint, and resolves it to an exact version.
+func (srv *Server) resolveVersionSpec(c echo.Context) (core.ArtifactVersionSpec, error) {
+	artifactSpec, err := core.ParseArtifactSpecFromEcho(c)
+	if err != nil {
+		return core.ArtifactVersionSpec{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// Constraints may contain characters, which are escaped in the path.
+	version, err := url.PathUnescape(c.Param("version"))
+	if err != nil {
+		return core.ArtifactVersionSpec{}, echo.NewHTTPError(http.StatusBadRequest, "invalid version "+c.Param("version"))
+	}
+
+	if version == "latest" {
+		versions, err := storage.GetSortedVersions(srv.Storage, artifactSpec)
+		if err != nil {
+			return core.ArtifactVersionSpec{}, err
+		}
+		if len(versions) == 0 {
+			return core.ArtifactVersionSpec{}, echo.NewHTTPError(http.StatusNotFound, "the artifact has no versions")
+		}
+
+		return core.ArtifactVersionSpec{ArtifactSpec: artifactSpec, Version: versions[0]}, nil
+	}
+
+	if parsed, err := semver.NewVersion(version); err == nil {
+		return core.ArtifactVersionSpec{ArtifactSpec: artifactSpec, Version: parsed}, nil
+	}
+
+	if core.IsConstraint(version) {
+		constraint, err := semver.NewConstraint(version)
+		if err != nil {
+			return core.ArtifactVersionSpec{}, echo.NewHTTPError(http.StatusBadRequest, "invalid constraint "+version)
+		}
+
+		versions, err := storage.GetSortedVersions(srv.Storage, artifactSpec)
+		if err != nil {
+			return core.ArtifactVersionSpec{}, err
+		}
+
+		for _, v := range versions {
+			if constraint.Check(v) {
+				return core.ArtifactVersionSpec{ArtifactSpec: artifactSpec, Version: v}, nil
+			}
+		}
+
+		return core.ArtifactVersionSpec{}, echo.NewHTTPError(http.StatusNotFound, "no version matches "+version)
+	}
+
+	if core.ValidateTag(version) != nil {
+		return core.ArtifactVersionSpec{}, echo.NewHTTPError(http.StatusBadRequest, "invalid version or tag "+version)
+	}
+
+	tags, err := storage.GetTags(srv.Storage, artifactSpec)
+	if err != nil {
+		return core.ArtifactVersionSpec{}, err
+	}
+
+	tagged, ok := tags[version]
+	if !ok {
+		return core.ArtifactVersionSpec{}, echo.NewHTTPError(http.StatusNotFound, "tag "+version+" not found")
+	}
+
+	parsed, err := semver.NewVersion(tagged)
+	if err != nil {
+		return core.ArtifactVersionSpec{}, err
+	}
+
+	return core.ArtifactVersionSpec{ArtifactSpec: artifactSpec, Version: parsed}, nil
+}
+
+func (srv *Server) getMetadata(c echo.Context) error {
+	artifactSpec, err := core.ParseArtifactSpecFromEcho(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	reader, ok := srv.Storage.(storage.MetadataReader)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage backend does not support metadata")
+	}
+
+	// Latest, tags and constraints are resolved again, whenever a long-polling request reloads the metadata.
+	return srv.conditionalJSON(c, artifactSpec, func() (interface{}, error) {
+		spec, err := srv.resolveVersionSpec(c)
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err := reader.GetMeta(c.Request().Context(), spec)
+		if errors.Is(err, storage.ErrVersionNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return &MetadataResponse{
+			Namespace:   spec.Namespace,
+			Name:        spec.Name,
+			Version:     spec.Version.String(),
+			Filename:    meta.OriginalFilename,
+			ContentType: meta.ContentType,
+			Hash:        meta.Hash,
+			Size:        meta.Size,
+			Labels:      meta.Labels,
+		}, nil
+	})
+}
+
+func (srv *Server) getTags(c echo.Context) error {
+	spec, err := core.ParseArtifactSpecFromEcho(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return srv.conditionalJSON(c, spec, func() (interface{}, error) {
+		tags, err := storage.GetTags(srv.Storage, spec)
+		if errors.Is(err, storage.ErrTagsNotSupported) {
+			return nil, echo.NewHTTPError(http.StatusNotImplemented, err.Error())
+		}
+
+		return tags, err
+	})
+}
+
+func (srv *Server) setTag(c echo.Context) error {
+	spec, err := core.ParseArtifactSpecFromEcho(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	tag := c.Param("tag")
+	if err := core.ValidateTag(tag); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	request := SetTagRequest{}
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	version, err := semver.NewVersion(request.Version)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid version "+request.Version)
+	}
+
+	versions, err := srv.Storage.GetVersions(spec)
+	if err != nil {
+		return err
+	}
+
+	exists := false
+	for _, v := range versions {
+		exists = exists || (v != nil && v.Equal(version))
+	}
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "version "+version.String()+" not found")
+	}
+
+	c.Set(auditVersionKey, version.String())
+
+	err = storage.SetTag(srv.Storage, spec, tag, version)
+	if errors.Is(err, storage.ErrTagsNotSupported) {
+		return echo.NewHTTPError(http.StatusNotImplemented, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (srv *Server) deleteTag(c echo.Context) error {
+	spec, err := core.ParseArtifactSpecFromEcho(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = storage.SetTag(srv.Storage, spec, c.Param("tag"), nil)
+	if errors.Is(err, storage.ErrTagsNotSupported) {
+		return echo.NewHTTPError(http.StatusNotImplemented, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+type TidyResponse struct {
+	DryRun  bool     `json:"dryRun"`
+	Deleted []string `json:"deleted"`
+	Kept    []string `json:"kept"`
+}
+
+// tidy deletes all but the newest keep versions of an artifact.
+// With dryRun=true, it only returns the versions which would be deleted.
+func (srv *Server) tidy(c echo.Context) error {
+	spec, err := core.ParseArtifactSpecFromEcho(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	keep, err := parseKeepParam(c)
+	if err != nil {
+		return err
+	}
+	if keep <= 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "the keep parameter must be at least 1, use DELETE to delete all versions")
+	}
+
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dryRun"))
+
+	versions, err := storage.GetSortedVersions(srv.Storage, spec)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "the artifact has no versions")
+	}
+
+	deleted := storage.TidyCandidates(versions, keep, nil)
+
+	if !dryRun {
+		deleted, err = storage.Tidy(srv.Storage, spec, keep, nil)
+		srv.invalidateUsage(spec.Namespace)
+		srv.recordDeletedVersions(srv.auditEntry(c, AuditTidy), AuditTidy, deleted)
+		if err != nil {
+			return err
+		}
+	}
+
+	isDeleted := map[string]bool{}
+	for _, v := range deleted {
+		isDeleted[v.String()] = true
+	}
+
+	response := &TidyResponse{
+		DryRun:  dryRun,
+		Deleted: core.MapArray(deleted, func(v *semver.Version) string { return v.String() }),
+		Kept:    []string{},
+	}
+
+	for _, v := range versions {
+		if !isDeleted[v.String()] {
+			response.Kept = append(response.Kept, v.String())
+		}
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
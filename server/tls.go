@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// loadTLSConfig creates the TLS configuration from TLS_CERT_FILE and TLS_KEY_FILE.
+// It returns nil, if no certificate is configured and plain HTTP should be served.
+func loadTLSConfig() *tls.Config {
+	certFile := core.GetEnvVar("TLS_CERT_FILE", "")
+	if certFile == "" {
+		return nil
+	}
+
+	reloader := &certificateReloader{
+		certFile: certFile,
+		keyFile:  core.GetRequiredEnvVar("TLS_KEY_FILE"),
+	}
+
+	if err := reloader.reload(); err != nil {
+		panic(err)
+	}
+
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	if caFile := core.GetEnvVar("TLS_CLIENT_CA_FILE", ""); caFile != "" {
+		content, err := os.ReadFile(caFile)
+		if err != nil {
+			panic(err)
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(content) {
+			panic("No certificates found in TLS_CLIENT_CA_FILE.")
+		}
+
+		switch core.GetEnvVar("TLS_CLIENT_AUTH", "optional") {
+		case "optional":
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		case "required":
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		default:
+			panic("Invalid TLS_CLIENT_AUTH. Only optional or required are supported.")
+		}
+	}
+
+	return config
+}
+
+// certificateReloader serves the server certificate and loads it again, once the files have been changed, eg. by certbot.
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+	checkedAt   time.Time
+}
+
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Checking the files on every handshake is unnecessary.
+	if time.Since(r.checkedAt) > 10*time.Second {
+		r.checkedAt = time.Now()
+
+		if modTime := r.latestModTime(); modTime.After(r.modTime) {
+			if err := r.load(); err != nil {
+				// Keep serving the old certificate, the new one may be only partially written.
+				log.Println("Failed to reload the TLS certificate:", err)
+			} else {
+				log.Println("Reloaded the TLS certificate")
+			}
+		}
+	}
+
+	return r.certificate, nil
+}
+
+func (r *certificateReloader) reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.load()
+}
+
+func (r *certificateReloader) load() error {
+	modTime := r.latestModTime()
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.certificate = &certificate
+	r.modTime = modTime
+
+	return nil
+}
+
+func (r *certificateReloader) latestModTime() time.Time {
+	latest := time.Time{}
+
+	for _, file := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest
+}
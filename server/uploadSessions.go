@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+func (srv *Server) parseSessionRequest(c echo.Context) (storage.ChunkedUploader, core.ArtifactVersionSpec, error) {
+	spec, err := core.ParseVersionSpecFromEcho(c)
+	if err != nil {
+		return nil, spec, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if spec.Latest {
+		return nil, spec, echo.NewHTTPError(http.StatusBadRequest, "uploading to latest version is not allowed")
+	}
+
+	uploader, ok := srv.Storage.(storage.ChunkedUploader)
+	if !ok {
+		return nil, spec, echo.NewHTTPError(http.StatusNotImplemented, "the storage backend does not support upload sessions")
+	}
+
+	return uploader, spec, nil
+}
+
+func sessionResponse(c echo.Context, status int, session storage.UploadSession, err error) error {
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+
+	switch {
+	case errors.Is(err, storage.ErrUploadNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, storage.ErrOffsetMismatch):
+		return echo.NewHTTPError(http.StatusConflict, "expected offset "+strconv.FormatInt(session.Offset, 10))
+	case errors.Is(err, storage.ErrChunkTooSmall):
+		return echo.NewHTTPError(http.StatusBadRequest, "all chunks except the last one must be at least 5 MiB")
+	case errors.Is(err, storage.ErrLengthRequired):
+		return echo.NewHTTPError(http.StatusLengthRequired, err.Error())
+	case errors.Is(err, errors.ErrUnsupported):
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage backend does not support upload sessions")
+	case err != nil:
+		return err
+	}
+
+	if c.Request().Method == http.MethodHead || c.Request().Method == http.MethodPatch {
+		return c.NoContent(status)
+	}
+
+	return c.JSON(status, session)
+}
+
+func (srv *Server) startUploadSession(c echo.Context) error {
+	uploader, spec, err := srv.parseSessionRequest(c)
+	if err != nil {
+		return err
+	}
+
+	if err := srv.checkQuota(spec, -1); err != nil {
+		return err
+	}
+
+	session, err := uploader.StartSession(c.Request().Context(), spec)
+	if err == nil {
+		c.Response().Header().Set("Location", c.Request().URL.Path+"/"+session.Id)
+	}
+
+	return sessionResponse(c, http.StatusCreated, session, err)
+}
+
+func (srv *Server) getUploadSession(c echo.Context) error {
+	uploader, spec, err := srv.parseSessionRequest(c)
+	if err != nil {
+		return err
+	}
+
+	session, err := uploader.GetSession(c.Request().Context(), spec, c.Param("sessionId"))
+
+	return sessionResponse(c, http.StatusOK, session, err)
+}
+
+func (srv *Server) writeUploadChunk(c echo.Context) error {
+	uploader, spec, err := srv.parseSessionRequest(c)
+	if err != nil {
+		return err
+	}
+
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing or invalid Upload-Offset header")
+	}
+
+	// Quotas are checked for every chunk, as the size of the blob isn't known when the session is started.
+	size := c.Request().ContentLength
+	if size < 0 && srv.requiresContentLength(spec.Namespace) {
+		return echo.NewHTTPError(http.StatusLengthRequired, "the namespace has a storage quota, chunks need a Content-Length")
+	}
+
+	if err := srv.checkQuota(spec, offset+max(size, 0)); err != nil {
+		return err
+	}
+
+	session, err := uploader.WriteChunk(c.Request().Context(), spec, c.Param("sessionId"), offset, c.Request().Body, size)
+
+	return sessionResponse(c, http.StatusNoContent, session, err)
+}
+
+func (srv *Server) finalizeUploadSession(c echo.Context) error {
+	uploader, spec, err := srv.parseSessionRequest(c)
+	if err != nil {
+		return err
+	}
+
+	request := CompleteUploadRequest{}
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	if request.Hash == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "missing hash")
+	}
+
+	tidyKeep, err := parseKeepParam(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	sessionId := c.Param("sessionId")
+
+	// Other uploads may have filled the namespace in the meantime.
+	session, err := uploader.GetSession(ctx, spec, sessionId)
+	if err != nil {
+		return sessionResponse(c, http.StatusOK, session, err)
+	}
+
+	release, err := srv.reserveQuota(spec, session.Offset)
+	if err != nil {
+		if err := uploader.AbortSession(ctx, spec, sessionId); err != nil {
+			log.Println(err)
+		}
+
+		return err
+	}
+
+	meta, err := uploader.FinalizeSession(ctx, spec, sessionId, core.BlobMeta{
+		OriginalFilename: request.Filename,
+		ContentType:      request.ContentType,
+		Hash:             request.Hash,
+		Size:             session.Offset,
+		Labels:           request.Labels,
+	})
+	release(err == nil)
+
+	if errors.Is(err, storage.ErrUploadNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if errors.Is(err, storage.ErrDigestMismatch) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, storage.ErrSizeMismatch) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	c.Set(auditDigestKey, meta.Hash)
+
+	srv.tidyAfterUpload(c, spec, tidyKeep)
+
+	return c.JSON(http.StatusOK, meta)
+}
+
+func (srv *Server) abortUploadSession(c echo.Context) error {
+	uploader, spec, err := srv.parseSessionRequest(c)
+	if err != nil {
+		return err
+	}
+
+	err = uploader.AbortSession(c.Request().Context(), spec, c.Param("sessionId"))
+	if errors.Is(err, errors.ErrUnsupported) {
+		return echo.NewHTTPError(http.StatusNotImplemented, "the storage backend does not support upload sessions")
+	}
+	if errors.Is(err, storage.ErrUploadNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (srv *Server) cleanupUploadSessions() {
+	uploader, ok := srv.Storage.(storage.ChunkedUploader)
+	if !ok {
+		return
+	}
+
+	interval := core.GetEnvVarDuration("UPLOAD_SESSION_CLEANUP_INTERVAL", time.Hour)
+
+	for {
+		err := uploader.CleanupExpiredSessions(context.Background())
+		if errors.Is(err, errors.ErrUnsupported) {
+			return
+		}
+		if err != nil {
+			log.Println(err)
+		}
+
+		time.Sleep(interval)
+	}
+}
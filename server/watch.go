@@ -0,0 +1,142 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// longPollRecheckInterval is how often a long-polling request reloads its response,
+// to notice changes made by other instances or in the upstream repository.
+const longPollRecheckInterval = 10 * time.Second
+
+// changeNotifier wakes up long-polling requests, when an artifact has been changed on this instance.
+// The zero value is ready to use.
+type changeNotifier struct {
+	mutex   sync.Mutex
+	waiting map[core.ArtifactSpec]chan struct{}
+}
+
+// changed returns a channel, which is closed on the next change of the artifact.
+func (n *changeNotifier) changed(spec core.ArtifactSpec) <-chan struct{} {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.waiting == nil {
+		n.waiting = map[core.ArtifactSpec]chan struct{}{}
+	}
+
+	channel, ok := n.waiting[spec]
+	if !ok {
+		channel = make(chan struct{})
+		n.waiting[spec] = channel
+	}
+
+	return channel
+}
+
+func (n *changeNotifier) notify(spec core.ArtifactSpec) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if channel, ok := n.waiting[spec]; ok {
+		close(channel)
+		delete(n.waiting, spec)
+	}
+}
+
+// notifyChanges wakes up long-polling requests after successful requests modifying an artifact.
+// Some of them, like starting an upload session, don't change anything visible, which only causes a needless reload.
+func (srv *Server) notifyChanges(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+
+		method := c.Request().Method
+		if err != nil || method == http.MethodGet || method == http.MethodHead || c.Response().Status >= 400 {
+			return err
+		}
+
+		if spec, specErr := core.ParseArtifactSpecFromEcho(c); specErr == nil {
+			srv.changes.notify(spec)
+		}
+
+		return err
+	}
+}
+
+// conditionalJSON responds with the JSON returned by load and its ETag. If the If-None-Match header of the request
+// matches the ETag, it waits up to the wait query parameter for the response to change, before responding with 304 Not Modified.
+func (srv *Server) conditionalJSON(c echo.Context, spec core.ArtifactSpec, load func() (interface{}, error)) error {
+	wait := time.Duration(0)
+	if value := c.QueryParam("wait"); value != "" {
+		var err error
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid wait parameter, expected a duration like 30s")
+		}
+	}
+
+	if wait > srv.LongPollTimeout {
+		wait = srv.LongPollTimeout
+	}
+
+	deadline := time.Now().Add(wait)
+	ctx := c.Request().Context()
+
+	for {
+		// Subscribe before loading, so a change in between isn't missed.
+		changed := srv.changes.changed(spec)
+
+		result, err := load()
+		if err != nil {
+			return err
+		}
+
+		content, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(content)
+		etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+
+		c.Response().Header().Set("ETag", etag)
+
+		if !etagMatches(c.Request().Header.Get("If-None-Match"), etag) {
+			return c.JSONBlob(http.StatusOK, content)
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return c.NoContent(http.StatusNotModified)
+		}
+		if remaining > longPollRecheckInterval {
+			remaining = longPollRecheckInterval
+		}
+
+		select {
+		case <-changed:
+		case <-time.After(remaining):
+		case <-ctx.Done():
+			return c.NoContent(http.StatusNotModified)
+		}
+	}
+}
+
+func etagMatches(header string, etag string) bool {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimPrefix(strings.TrimSpace(value), "W/")
+		if value == etag || value == "*" {
+			return true
+		}
+	}
+
+	return false
+}
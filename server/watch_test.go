@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/storage"
+)
+
+func TestLongPolling(t *testing.T) {
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+
+	srv := &Server{
+		Storage:         storage.LocalDirectory(),
+		Audit:           &AuditLog{},
+		LongPollTimeout: time.Minute,
+	}
+
+	e := echo.New()
+	e.Use(srv.notifyChanges)
+	e.GET("/:namespace/:name", srv.getVersions)
+	e.GET("/:namespace/:name/:version/_meta", srv.getMetadata)
+	e.PUT("/:namespace/:name/:version", srv.upload)
+
+	push := func(version string) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/ns/app/"+version, strings.NewReader(version)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("failed to push %s: %d", version, rec.Code)
+		}
+	}
+
+	get := func(path string, etag string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		if etag != "" {
+			request.Header.Set("If-None-Match", etag)
+		}
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, request)
+		return rec
+	}
+
+	push("1.0.0")
+
+	rec := get("/ns/app", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected a response with an ETag, got %d %q", rec.Code, etag)
+	}
+
+	if rec := get("/ns/app", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("expected %d for a matching ETag, got %d", http.StatusNotModified, rec.Code)
+	}
+
+	// A waiting request returns as soon as a new version has been pushed.
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		push("1.1.0")
+	}()
+
+	start := time.Now()
+	rec = get("/ns/app?wait=5s", etag)
+	if rec.Code != http.StatusOK || time.Since(start) > 3*time.Second {
+		t.Fatalf("expected the waiting request to return the new version, got %d after %s", rec.Code, time.Since(start))
+	}
+
+	response := GetVersionsResponse{}
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	if response.Latest != "1.1.0" || rec.Header().Get("ETag") == etag {
+		t.Errorf("expected 1.1.0 with a new ETag, got %s", response.Latest)
+	}
+
+	// Constraints are resolved for metadata as well.
+	push("2.0.0")
+
+	rec = get("/ns/app/%5E1.0/_meta", "")
+	metadata := MetadataResponse{}
+	json.Unmarshal(rec.Body.Bytes(), &metadata)
+	if rec.Code != http.StatusOK || metadata.Version != "1.1.0" {
+		t.Errorf("expected ^1.0 to resolve to 1.1.0, got %d %s", rec.Code, metadata.Version)
+	}
+
+	if rec := get("/ns/app/%5E3.0/_meta", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected %d for an unmatched constraint, got %d", http.StatusNotFound, rec.Code)
+	}
+}
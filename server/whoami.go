@@ -0,0 +1,46 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/labstack/echo/v4"
+	myMiddleware "github.com/sevensolutions/tiny-repo/middleware"
+)
+
+type WhoamiResponse struct {
+	Name      string     `json:"name"`
+	TokenId   string     `json:"tokenId,omitempty"`
+	Issuer    string     `json:"issuer,omitempty"`
+	Subject   string     `json:"subject,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	myMiddleware.Access
+}
+
+func (srv *Server) whoami(c echo.Context) error {
+	user, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	claims := user.Claims.(jwt.MapClaims)
+
+	access, err := myMiddleware.AccessOfClaims(claims)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	response := &WhoamiResponse{Access: access}
+	response.Name, _ = claims["name"].(string)
+	response.TokenId, _ = claims["jti"].(string)
+	response.Issuer, _ = claims["iss"].(string)
+	response.Subject, _ = claims["sub"].(string)
+
+	if exp, ok := claims["exp"].(float64); ok {
+		expiresAt := time.Unix(int64(exp), 0).UTC()
+		response.ExpiresAt = &expiresAt
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
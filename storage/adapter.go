@@ -2,8 +2,10 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"io"
 	"sort"
+	"time"
 
 	"github.com/sevensolutions/tiny-repo/core"
 
@@ -12,12 +14,42 @@ import (
 )
 
 type StorageAdapter interface {
-	Upload(ctx context.Context, spec core.ArtifactVersionSpec, target echo.Context, source io.Reader) error
+	// Upload verifies meta.Hash, if it is set, before the blob replaces an existing one.
+	Upload(ctx context.Context, spec core.ArtifactVersionSpec, meta core.BlobMeta, source io.Reader) error
 	Download(ctx context.Context, spec core.ArtifactVersionSpec, target echo.Context) error
 	GetVersions(artifactSpec core.ArtifactSpec) ([]*semver.Version, error)
 	DeleteVersion(spec core.ArtifactVersionSpec) error
 }
 
+// DocumentStore is implemented by adapters which can persist small internal documents, like the list of revoked tokens.
+// ReadDocument returns nil, if the document doesn't exist.
+type DocumentStore interface {
+	ReadDocument(name string) ([]byte, error)
+	WriteDocument(name string, content []byte) error
+}
+
+var ErrUploadNotFound = errors.New("upload not found")
+var ErrDigestMismatch = errors.New("digest mismatch")
+var ErrSizeMismatch = errors.New("size mismatch")
+
+// PresignedUploader is implemented by adapters which allow clients to upload blobs
+// directly to the storage backend, bypassing the server.
+// CompleteUpload verifies meta.Hash and meta.Size, if they are set, before the blob becomes visible.
+type PresignedUploader interface {
+	PresignUpload(ctx context.Context, spec core.ArtifactVersionSpec) (PresignedUpload, error)
+	// UploadSize returns the size of the uploaded, but not yet completed blob.
+	UploadSize(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string) (int64, error)
+	CompleteUpload(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string, meta core.BlobMeta) (core.BlobMeta, error)
+	AbortUpload(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string) error
+}
+
+type PresignedUpload struct {
+	UploadId  string    `json:"uploadId"`
+	Method    string    `json:"method"`
+	Url       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
 func GetSortedVersions(storage StorageAdapter, artifactSpec core.ArtifactSpec) ([]*semver.Version, error) {
 	versions, err := storage.GetVersions(artifactSpec)
 	if err != nil {
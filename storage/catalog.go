@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	ospath "path"
+	"sort"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var ErrCatalogNotSupported = errors.New("the storage backend doesn't support listing artifacts")
+
+// Catalog is implemented by adapters which can list the stored namespaces and artifacts.
+// Internal folders, like .system and .uploads, are never listed.
+type Catalog interface {
+	ListNamespaces() ([]string, error)
+	ListArtifacts(namespace string) ([]string, error)
+}
+
+func (a *LocalDirectoryAdapter) ListNamespaces() ([]string, error) {
+	return a.listFolders(a.rootDirectory)
+}
+
+func (a *LocalDirectoryAdapter) ListArtifacts(namespace string) ([]string, error) {
+	return a.listFolders(ospath.Join(a.rootDirectory, namespace))
+}
+
+func (a *LocalDirectoryAdapter) listFolders(path string) ([]string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entries, err := os.ReadDir(path)
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			result = append(result, entry.Name())
+		}
+	}
+
+	return result, nil
+}
+
+func (a *MinioAdapter) ListNamespaces() ([]string, error) {
+	return a.listPrefixes("")
+}
+
+func (a *MinioAdapter) ListArtifacts(namespace string) ([]string, error) {
+	return a.listPrefixes(namespace + "/")
+}
+
+func (a *MinioAdapter) listPrefixes(prefix string) ([]string, error) {
+	objects := a.client.ListObjects(context.Background(), a.bucketName, minio.ListObjectsOptions{
+		Prefix: prefix,
+	})
+
+	result := []string{}
+	for object := range objects {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		// Without recursion, folders are returned as common prefixes ending with a slash.
+		name := strings.TrimSuffix(strings.TrimPrefix(object.Key, prefix), "/")
+		if strings.HasSuffix(object.Key, "/") && name != "" && !strings.HasPrefix(name, ".") {
+			result = append(result, name)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// The proxy only lists artifacts, which have been cached or pushed locally.
+func (a *ProxyAdapter) ListNamespaces() ([]string, error) {
+	catalog, ok := a.inner.(Catalog)
+	if !ok {
+		return nil, ErrCatalogNotSupported
+	}
+
+	return catalog.ListNamespaces()
+}
+
+func (a *ProxyAdapter) ListArtifacts(namespace string) ([]string, error) {
+	catalog, ok := a.inner.(Catalog)
+	if !ok {
+		return nil, ErrCatalogNotSupported
+	}
+
+	return catalog.ListArtifacts(namespace)
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	ospath "path"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// compressionRules decide which blobs are compressed before they are stored.
+// A blob is compressed if either its namespace or its content type matches one of the configured globs.
+type compressionRules struct {
+	encoding     string
+	namespaces   []string
+	contentTypes []string
+}
+
+func loadCompressionRules() *compressionRules {
+	rules := &compressionRules{
+		encoding:     core.GetEnvVar("STORAGE_COMPRESSION", "zstd"),
+		namespaces:   splitList(core.GetEnvVar("STORAGE_COMPRESSION_NAMESPACES", "")),
+		contentTypes: splitList(core.GetEnvVar("STORAGE_COMPRESSION_CONTENT_TYPES", "")),
+	}
+
+	// The algorithm is only validated, if compression is enabled at all.
+	if len(rules.namespaces) == 0 && len(rules.contentTypes) == 0 {
+		return nil
+	}
+
+	if rules.encoding != "zstd" && rules.encoding != "gzip" {
+		panic("Invalid STORAGE_COMPRESSION. Only zstd or gzip are supported.")
+	}
+
+	return rules
+}
+
+func splitList(value string) []string {
+	result := []string{}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+
+	return result
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := ospath.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encodingFor returns the content encoding to use for a new blob or an empty string, if it should not be compressed.
+func (r *compressionRules) encodingFor(namespace string, contentType string) string {
+	if r == nil {
+		return ""
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+
+	if matchesAny(r.namespaces, namespace) || matchesAny(r.contentTypes, strings.TrimSpace(mediaType)) {
+		return r.encoding
+	}
+
+	return ""
+}
+
+func newCompressor(target io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "zstd":
+		return zstd.NewWriter(target)
+	case "gzip":
+		return gzip.NewWriter(target), nil
+	}
+
+	return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+}
+
+func newDecompressor(source io.Reader, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "zstd":
+		decoder, err := zstd.NewReader(source)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case "gzip":
+		return gzip.NewReader(source)
+	}
+
+	return nil, fmt.Errorf("unsupported content encoding %s", encoding)
+}
+
+// acceptsEncoding checks whether the client advertised the given encoding in its Accept-Encoding header.
+func acceptsEncoding(request *http.Request, encoding string) bool {
+	for _, header := range request.Header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(header, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+
+			if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+				continue
+			}
+
+			quality, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+			if !found {
+				return true
+			}
+
+			q, err := strconv.ParseFloat(quality, 64)
+			return err == nil && q > 0
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http/httptest"
+	ospath "path"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+func TestCompressedBlobs(t *testing.T) {
+	directory := t.TempDir()
+
+	t.Setenv("STORAGE_DIRECTORY", directory)
+	t.Setenv("STORAGE_COMPRESSION_CONTENT_TYPES", "text/*")
+
+	adapter := LocalDirectory()
+	spec := core.ArtifactVersionSpec{
+		ArtifactSpec: core.ArtifactSpec{Namespace: "foo", Name: "bar"},
+		Version:      semver.MustParse("1.0.0"),
+	}
+
+	content := strings.Repeat("tiny repo ", 10000)
+
+	err := adapter.Upload(context.Background(), spec, core.BlobMeta{ContentType: "text/plain; charset=utf-8"}, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := readMeta(ospath.Join(directory, "foo", "bar", "1.0.0", "meta.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	if meta.ContentEncoding != "zstd" || meta.Hash != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected meta %+v", meta)
+	}
+
+	if body := download(t, adapter, spec); body != content {
+		t.Fatal("decompressed content doesn't match")
+	}
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip, zstd")
+	recorder := httptest.NewRecorder()
+
+	if err := adapter.Download(request.Context(), spec, echo.New().NewContext(request, recorder)); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Header().Get("Content-Encoding") != "zstd" || recorder.Body.Len() >= len(content) {
+		t.Fatal("expected the compressed stream")
+	}
+
+	decoder, err := zstd.NewReader(bytes.NewReader(recorder.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decompressed, err := io.ReadAll(decoder)
+	if err != nil || string(decompressed) != content {
+		t.Fatal("compressed stream doesn't match")
+	}
+}
+
+func TestCompressionConfig(t *testing.T) {
+	panics := func(load func()) (panicked bool) {
+		defer func() { panicked = recover() != nil }()
+		load()
+		return false
+	}
+
+	t.Setenv("STORAGE_COMPRESSION", "brotli")
+
+	if panics(func() { loadCompressionRules() }) {
+		t.Error("expected the algorithm to be ignored without compression rules")
+	}
+
+	t.Setenv("STORAGE_COMPRESSION_NAMESPACES", "logs")
+
+	if !panics(func() { loadCompressionRules() }) {
+		t.Error("expected an invalid algorithm to be rejected")
+	}
+
+	for name, value := range map[string]string{"S3_ENDPOINT": "localhost:9000", "S3_ACCESSKEY": "key", "S3_SECRETKEY": "secret", "S3_USESSL": "false", "S3_BUCKETNAME": "bucket"} {
+		t.Setenv(name, value)
+	}
+
+	if !panics(func() { MinIO() }) {
+		t.Error("expected compression to be rejected for S3")
+	}
+
+	t.Setenv("STORAGE_COMPRESSION_NAMESPACES", "")
+
+	if panics(func() { MinIO() }) {
+		t.Error("expected S3 to start without compression")
+	}
+}
@@ -0,0 +1,417 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+const encryptionAlgorithm = "AES-256-GCM-CHUNKED"
+const encryptionChunkSize = 64 * 1024
+
+var ErrUnknownEncryptionKey = errors.New("blob is encrypted with an unknown master key")
+
+// blobEncryption implements envelope encryption. Every blob is encrypted with its own random data key,
+// which is wrapped by a master key and stored in the blob meta.
+// The blob is split into chunks, which are sealed separately, so any range can be decrypted without reading the whole blob.
+type blobEncryption struct {
+	current  masterKey
+	previous *masterKey
+}
+
+type masterKey struct {
+	id  string
+	key []byte
+}
+
+func loadBlobEncryption() (*blobEncryption, error) {
+	current, err := loadMasterKey("STORAGE_ENCRYPTION_KEY")
+	if err != nil || current == nil {
+		return nil, err
+	}
+
+	previous, err := loadMasterKey("STORAGE_ENCRYPTION_PREVIOUS_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	return &blobEncryption{
+		current:  *current,
+		previous: previous,
+	}, nil
+}
+
+// loadMasterKey reads a base64 encoded 256 bit key either from the given environment variable,
+// or from the file referenced by the environment variable with a _FILE suffix.
+func loadMasterKey(name string) (*masterKey, error) {
+	encoded := core.GetEnvVar(name, "")
+
+	if keyFile := core.GetEnvVar(name+"_FILE", ""); keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = string(content)
+	}
+
+	if encoded == "" {
+		return nil, nil
+	}
+
+	return parseMasterKey(encoded)
+}
+
+func parseMasterKey(encoded string) (*masterKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+
+	if len(key) != 32 {
+		return nil, errors.New("invalid encryption key: the key must be 32 bytes long")
+	}
+
+	fingerprint := sha256.Sum256(key)
+
+	return &masterKey{
+		id:  hex.EncodeToString(fingerprint[:8]),
+		key: key,
+	}, nil
+}
+
+func (e *blobEncryption) findKey(keyId string) (*masterKey, error) {
+	if e.current.id == keyId {
+		return &e.current, nil
+	}
+	if e.previous != nil && e.previous.id == keyId {
+		return e.previous, nil
+	}
+
+	return nil, fmt.Errorf("%w %s", ErrUnknownEncryptionKey, keyId)
+}
+
+func (e *blobEncryption) newDataKey() ([]byte, *core.EncryptionMeta, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	wrappedKey, err := seal(e.current.key, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dataKey, &core.EncryptionMeta{
+		Algorithm:  encryptionAlgorithm,
+		ChunkSize:  encryptionChunkSize,
+		KeyId:      e.current.id,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrappedKey),
+	}, nil
+}
+
+func (e *blobEncryption) unwrapDataKey(meta *core.EncryptionMeta) ([]byte, error) {
+	if meta.Algorithm != encryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm %s", meta.Algorithm)
+	}
+
+	key, err := e.findKey(meta.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := base64.StdEncoding.DecodeString(meta.WrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(key.key, wrappedKey)
+}
+
+// rewrap wraps the data key with the current master key. It returns false, if this was already the case.
+func (e *blobEncryption) rewrap(meta *core.EncryptionMeta) (bool, error) {
+	if meta.KeyId == e.current.id {
+		return false, nil
+	}
+
+	dataKey, err := e.unwrapDataKey(meta)
+	if err != nil {
+		return false, err
+	}
+
+	wrappedKey, err := seal(e.current.key, dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	meta.KeyId = e.current.id
+	meta.WrappedKey = base64.StdEncoding.EncodeToString(wrappedKey)
+
+	return true, nil
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, ciphertext []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("invalid wrapped key")
+	}
+
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// Data keys are never reused, so the chunk index can safely be used as the nonce.
+// The additional data marks the last chunk, which prevents truncation of the blob.
+func chunkNonceAndAdditionalData(aead cipher.AEAD, index int64, last bool) ([]byte, []byte) {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[aead.NonceSize()-8:], uint64(index))
+
+	additionalData := []byte{0}
+	if last {
+		additionalData[0] = 1
+	}
+
+	return nonce, additionalData
+}
+
+type encryptingWriter struct {
+	target io.Writer
+	aead   cipher.AEAD
+	buffer []byte
+	index  int64
+}
+
+func newEncryptingWriter(target io.Writer, dataKey []byte) (*encryptingWriter, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptingWriter{
+		target: target,
+		aead:   aead,
+		buffer: make([]byte, 0, encryptionChunkSize),
+	}, nil
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		// A full chunk is only flushed once more data arrives, because the last chunk must be marked as such.
+		if len(w.buffer) == encryptionChunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(w.buffer[len(w.buffer):encryptionChunkSize], p)
+		w.buffer = w.buffer[:len(w.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+func (w *encryptingWriter) flush(last bool) error {
+	nonce, additionalData := chunkNonceAndAdditionalData(w.aead, w.index, last)
+
+	_, err := w.target.Write(w.aead.Seal(nil, nonce, w.buffer, additionalData))
+	if err != nil {
+		return err
+	}
+
+	w.buffer = w.buffer[:0]
+	w.index++
+
+	return nil
+}
+
+func (w *encryptingWriter) Close() error {
+	return w.flush(true)
+}
+
+// decryptingReader provides random access to the plaintext of an encrypted blob.
+type decryptingReader struct {
+	source     io.ReaderAt
+	aead       cipher.AEAD
+	size       int64
+	position   int64
+	chunkIndex int64
+	chunk      []byte
+}
+
+func newDecryptingReader(source io.ReaderAt, dataKey []byte, encryptedSize int64) (*decryptingReader, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every chunk, including an empty last one, carries the authentication tag.
+	sealedChunkSize := int64(encryptionChunkSize + aead.Overhead())
+	chunkCount := (encryptedSize + sealedChunkSize - 1) / sealedChunkSize
+
+	return &decryptingReader{
+		source:     source,
+		aead:       aead,
+		size:       encryptedSize - chunkCount*int64(aead.Overhead()),
+		chunkIndex: -1,
+	}, nil
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	if r.position >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.position / encryptionChunkSize
+
+	if index != r.chunkIndex {
+		if err := r.loadChunk(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.chunk[r.position-index*encryptionChunkSize:])
+	r.position += int64(n)
+
+	return n, nil
+}
+
+func (r *decryptingReader) loadChunk(index int64) error {
+	sealedChunkSize := int64(encryptionChunkSize + r.aead.Overhead())
+	lastIndex := r.size / encryptionChunkSize
+	if r.size > 0 && r.size%encryptionChunkSize == 0 {
+		lastIndex--
+	}
+
+	sealed := make([]byte, sealedChunkSize)
+
+	n, err := r.source.ReadAt(sealed, index*sealedChunkSize)
+	if err != nil && !(errors.Is(err, io.EOF) && index == lastIndex) {
+		return err
+	}
+
+	nonce, additionalData := chunkNonceAndAdditionalData(r.aead, index, index == lastIndex)
+
+	chunk, err := r.aead.Open(nil, nonce, sealed[:n], additionalData)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", index, err)
+	}
+
+	r.chunk = chunk
+	r.chunkIndex = index
+
+	return nil
+}
+
+func (r *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.position
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.position = offset
+
+	return offset, nil
+}
+
+// RotateEncryptionKeys re-wraps the data keys of all blobs with the current master key.
+// The blobs themselves are not re-encrypted.
+func (a *LocalDirectoryAdapter) RotateEncryptionKeys() (int, error) {
+	if a.encryption == nil {
+		return 0, errors.New("encryption is not configured")
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	metaPaths, err := filepath.Glob(filepath.Join(a.rootDirectory, "*", "*", "*", "meta.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+
+	for _, metaPath := range metaPaths {
+		meta, err := readMeta(metaPath)
+		if err != nil {
+			return rotated, err
+		}
+
+		if meta.Encryption == nil {
+			continue
+		}
+
+		changed, err := a.encryption.rewrap(meta.Encryption)
+		if err != nil {
+			return rotated, fmt.Errorf("%s: %w", metaPath, err)
+		}
+
+		if !changed {
+			continue
+		}
+
+		// Write to a temporary file first, so an interrupted rotation never leaves a blob without its key.
+		err = saveMeta(metaPath+".tmp", meta)
+		if err != nil {
+			return rotated, err
+		}
+
+		err = os.Rename(metaPath+".tmp", metaPath)
+		if err != nil {
+			return rotated, err
+		}
+
+		rotated++
+	}
+
+	return rotated, nil
+}
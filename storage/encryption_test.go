@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http/httptest"
+	"os"
+	ospath "path"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+func TestEncryptedBlobs(t *testing.T) {
+	directory := t.TempDir()
+	oldKey := randomKey(t)
+	newKey := randomKey(t)
+
+	t.Setenv("STORAGE_DIRECTORY", directory)
+	t.Setenv("STORAGE_ENCRYPTION_KEY", oldKey)
+
+	spec := core.ArtifactVersionSpec{
+		ArtifactSpec: core.ArtifactSpec{Namespace: "foo", Name: "bar"},
+		Version:      semver.MustParse("1.0.0"),
+	}
+
+	content := make([]byte, 3*encryptionChunkSize+123)
+	rand.Read(content)
+
+	err := LocalDirectory().Upload(context.Background(), spec, core.BlobMeta{}, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := os.ReadFile(ospath.Join(directory, "foo", "bar", "1.0.0", "blob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, content[:64]) {
+		t.Fatal("the blob is stored in plaintext")
+	}
+
+	// Rotate to the new key, afterwards the old one must not be required anymore.
+	t.Setenv("STORAGE_ENCRYPTION_KEY", newKey)
+	t.Setenv("STORAGE_ENCRYPTION_PREVIOUS_KEY", oldKey)
+
+	rotated, err := LocalDirectory().RotateEncryptionKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 1 {
+		t.Fatalf("expected 1 rotated blob, got %d", rotated)
+	}
+
+	t.Setenv("STORAGE_ENCRYPTION_PREVIOUS_KEY", "")
+
+	adapter := LocalDirectory()
+
+	if body := download(t, adapter, spec); body != string(content) {
+		t.Fatal("decrypted content doesn't match")
+	}
+
+	start := encryptionChunkSize - 5
+	request := httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Range", "bytes=65531-65540")
+	recorder := httptest.NewRecorder()
+
+	if err := adapter.Download(request.Context(), spec, echo.New().NewContext(request, recorder)); err != nil {
+		t.Fatal(err)
+	}
+
+	if recorder.Code != 206 || !bytes.Equal(recorder.Body.Bytes(), content[start:start+10]) {
+		t.Fatalf("unexpected range response %d", recorder.Code)
+	}
+}
+
+func randomKey(t *testing.T) string {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key)
+}
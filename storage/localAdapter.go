@@ -5,12 +5,15 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	ospath "path"
-	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/sevensolutions/tiny-repo/core"
 
@@ -21,28 +24,80 @@ import (
 type LocalDirectoryAdapter struct {
 	rootDirectory string
 	mutex         sync.Mutex
+	sessionTtl    time.Duration
+	sessionLocks  sync.Map
+	encryption    *blobEncryption
+	compression   *compressionRules
 }
 
 func LocalDirectory() *LocalDirectoryAdapter {
 	adapter := new(LocalDirectoryAdapter)
 	adapter.rootDirectory = core.GetRequiredEnvVar("STORAGE_DIRECTORY")
+	adapter.sessionTtl = core.GetEnvVarDuration("UPLOAD_SESSION_TTL", 24*time.Hour)
+
+	encryption, err := loadBlobEncryption()
+	if err != nil {
+		panic(err)
+	}
+	adapter.encryption = encryption
+	adapter.compression = loadCompressionRules()
 
 	return adapter
 }
 
-func (a *LocalDirectoryAdapter) Upload(ctx context.Context, spec core.ArtifactVersionSpec, echo echo.Context, source io.Reader) error {
+func (a *LocalDirectoryAdapter) Upload(ctx context.Context, spec core.ArtifactVersionSpec, meta core.BlobMeta, source io.Reader) error {
+	// The blob is staged outside of the version, so a failed upload doesn't replace an existing one.
+	// Only moving it into place is locked, so slow uploads don't block other requests.
+	stagingPath := ospath.Join(a.rootDirectory, ".uploads")
+
+	err := os.MkdirAll(stagingPath, 0777)
+	if err != nil {
+		return err
+	}
+
+	stagingPath = ospath.Join(stagingPath, core.RandomId()+".blob")
+	defer os.Remove(stagingPath)
+
+	expected := meta.Hash
+
+	err = a.writeBlob(stagingPath, spec.Namespace, source, &meta)
+	if err != nil {
+		return err
+	}
+
+	if expected != "" && expected != meta.Hash {
+		return fmt.Errorf("%w: expected %s but got %s", ErrDigestMismatch, expected, meta.Hash)
+	}
+
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
 
 	fullPath := ospath.Join(a.rootDirectory, spec.Namespace, spec.Name, spec.Version.String())
 
-	err := os.MkdirAll(fullPath, 0777)
+	err = os.MkdirAll(fullPath, 0777)
 	if err != nil {
 		return err
 	}
 
-	blobPath := ospath.Join(fullPath, "blob")
+	err = os.Rename(stagingPath, ospath.Join(fullPath, "blob"))
+	if err != nil {
+		return err
+	}
+
+	metaPath := ospath.Join(fullPath, "meta.json")
+
+	err = saveMeta(metaPath, meta)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
 
+// writeBlob stores the content of source, compressing and encrypting it if configured.
+// Hash, size and the applied transformations are recorded in meta.
+func (a *LocalDirectoryAdapter) writeBlob(blobPath string, namespace string, source io.Reader, meta *core.BlobMeta) error {
 	f, err := os.Create(blobPath)
 	if err != nil {
 		return err
@@ -50,39 +105,67 @@ func (a *LocalDirectoryAdapter) Upload(ctx context.Context, spec core.ArtifactVe
 
 	defer f.Close()
 
-	io.Copy(f, source)
+	var target io.Writer = f
+	var encryptor *encryptingWriter
+	var compressor io.WriteCloser
 
-	f.Seek(0, 0)
+	meta.Encryption = nil
+	meta.ContentEncoding = a.compression.encodingFor(namespace, meta.ContentType)
 
-	hasher := sha256.New()
+	if a.encryption != nil {
+		dataKey, encryptionMeta, err := a.encryption.newDataKey()
+		if err != nil {
+			return err
+		}
 
-	if _, err := io.Copy(hasher, f); err != nil {
-		return err
-	}
+		encryptor, err = newEncryptingWriter(target, dataKey)
+		if err != nil {
+			return err
+		}
 
-	hash := fmt.Sprintf("sha256:%s", hex.EncodeToString(hasher.Sum(nil)))
+		target = encryptor
+		meta.Encryption = encryptionMeta
+	}
 
-	metaPath := ospath.Join(fullPath, "meta.json")
+	if meta.ContentEncoding != "" {
+		compressor, err = newCompressor(target, meta.ContentEncoding)
+		if err != nil {
+			return err
+		}
 
-	contentTypeHeaders := echo.Request().Header["Content-Type"]
-	var contentType string
-	if len(contentTypeHeaders) > 0 {
-		contentType = contentTypeHeaders[0]
+		target = compressor
 	}
 
-	err = saveMeta(metaPath, core.BlobMeta{
-		OriginalFilename: echo.Param("filename"),
-		ContentType:      contentType,
-		Hash:             hash,
-	})
+	hasher := sha256.New()
 
+	size, err := io.Copy(target, io.TeeReader(source, hasher))
 	if err != nil {
 		return err
 	}
 
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return err
+		}
+	}
+
+	if encryptor != nil {
+		if err := encryptor.Close(); err != nil {
+			return err
+		}
+	}
+
+	meta.Hash = fmt.Sprintf("sha256:%s", hex.EncodeToString(hasher.Sum(nil)))
+	meta.Size = size
+
 	return nil
 }
 
+// needsTransformation reports whether a blob must be compressed or encrypted, instead of being stored as is.
+func (a *LocalDirectoryAdapter) needsTransformation(namespace string, meta core.BlobMeta) bool {
+	return a.encryption != nil || a.compression.encodingFor(namespace, meta.ContentType) != ""
+}
+
 func (a *LocalDirectoryAdapter) Download(ctx context.Context, spec core.ArtifactVersionSpec, target echo.Context) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
@@ -111,12 +194,85 @@ func (a *LocalDirectoryAdapter) Download(ctx context.Context, spec core.Artifact
 	if meta.ContentType != "" {
 		target.Response().Header().Add("Content-Type", meta.ContentType)
 	}
+	if meta.Hash != "" {
+		target.Response().Header().Set(core.HeaderContentHash, meta.Hash)
+	}
+
+	if meta.Encryption != nil || meta.ContentEncoding != "" {
+		return a.serveStoredBlob(target, blobPath, meta, filename)
+	}
 
 	target.Attachment(blobPath, filename)
 
 	return nil
 }
 
+// serveStoredBlob serves a blob which has been compressed and/or encrypted.
+// Compressed blobs are served as is, if the client accepts the encoding.
+func (a *LocalDirectoryAdapter) serveStoredBlob(target echo.Context, blobPath string, meta core.BlobMeta, filename string) error {
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return target.NoContent(http.StatusNotFound)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	var stored io.ReadSeeker = f
+
+	if meta.Encryption != nil {
+		if a.encryption == nil {
+			return errors.New("the blob is encrypted, but no encryption key is configured")
+		}
+
+		dataKey, err := a.encryption.unwrapDataKey(meta.Encryption)
+		if err != nil {
+			return err
+		}
+
+		stored, err = newDecryptingReader(f, dataKey, info.Size())
+		if err != nil {
+			return err
+		}
+	}
+
+	header := target.Response().Header()
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+
+	if meta.ContentType == "" {
+		header.Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	}
+
+	if meta.ContentEncoding != "" {
+		header.Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+
+		if !acceptsEncoding(target.Request(), meta.ContentEncoding) {
+			decompressor, err := newDecompressor(stored, meta.ContentEncoding)
+			if err != nil {
+				return err
+			}
+			defer decompressor.Close()
+
+			header.Set(echo.HeaderContentLength, strconv.FormatInt(meta.Size, 10))
+			target.Response().WriteHeader(http.StatusOK)
+
+			_, err = io.Copy(target.Response(), decompressor)
+
+			return err
+		}
+
+		header.Set(echo.HeaderContentEncoding, meta.ContentEncoding)
+	}
+
+	// ServeContent handles Range and conditional requests for us.
+	http.ServeContent(target.Response(), target.Request(), filename, info.ModTime(), stored)
+
+	return nil
+}
+
 func (a *LocalDirectoryAdapter) GetVersions(artifactSpec core.ArtifactSpec) ([]*semver.Version, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
@@ -148,6 +304,32 @@ func (a *LocalDirectoryAdapter) GetVersions(artifactSpec core.ArtifactSpec) ([]*
 	return result, nil
 }
 
+func (a *LocalDirectoryAdapter) ReadDocument(name string) ([]byte, error) {
+	content, err := os.ReadFile(ospath.Join(a.rootDirectory, ".system", name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return content, err
+}
+
+func (a *LocalDirectoryAdapter) WriteDocument(name string, content []byte) error {
+	documentPath := ospath.Join(a.rootDirectory, ".system", name)
+
+	err := os.MkdirAll(ospath.Dir(documentPath), 0777)
+	if err != nil {
+		return err
+	}
+
+	// Replace the document atomically, so readers never see a partially written one.
+	err = os.WriteFile(documentPath+".tmp", content, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(documentPath+".tmp", documentPath)
+}
+
 func folderExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if err == nil {
@@ -195,14 +377,5 @@ func readMeta(metaPath string) (core.BlobMeta, error) {
 }
 
 func removeAll(dir string) error {
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		return os.RemoveAll(path)
-	})
-	if err != nil {
-		return err
-	}
-	return nil
+	return os.RemoveAll(dir)
 }
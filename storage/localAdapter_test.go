@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	ospath "path"
+	"strings"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+func TestUploadDigestMismatch(t *testing.T) {
+	directory := t.TempDir()
+
+	t.Setenv("STORAGE_DIRECTORY", directory)
+
+	adapter := LocalDirectory()
+	spec := core.ArtifactVersionSpec{
+		ArtifactSpec: core.ArtifactSpec{Namespace: "foo", Name: "bar"},
+		Version:      semver.MustParse("1.0.0"),
+	}
+
+	sum := sha256.Sum256([]byte("original"))
+	hash := "sha256:" + hex.EncodeToString(sum[:])
+
+	if err := adapter.Upload(context.Background(), spec, core.BlobMeta{Hash: hash}, strings.NewReader("original")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A corrupted upload neither replaces the existing version nor creates a new one.
+	err := adapter.Upload(context.Background(), spec, core.BlobMeta{Hash: hash}, strings.NewReader("corrupted"))
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+
+	if body := download(t, adapter, spec); body != "original" {
+		t.Errorf("expected the existing blob to be kept, got %q", body)
+	}
+
+	spec.Version = semver.MustParse("2.0.0")
+
+	err = adapter.Upload(context.Background(), spec, core.BlobMeta{Hash: hash}, strings.NewReader("corrupted"))
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+
+	if _, err := os.Stat(ospath.Join(directory, "foo", "bar", "2.0.0")); !os.IsNotExist(err) {
+		t.Error("expected no version to be created")
+	}
+
+	staged, _ := os.ReadDir(ospath.Join(directory, ".uploads"))
+	if len(staged) != 0 {
+		t.Errorf("expected the staged blobs to be removed, got %d", len(staged))
+	}
+}
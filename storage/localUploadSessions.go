@@ -0,0 +1,269 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	ospath "path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+func (a *LocalDirectoryAdapter) sessionPath(sessionId string) string {
+	return ospath.Join(a.rootDirectory, ".uploads", sessionId)
+}
+
+func (a *LocalDirectoryAdapter) lockSession(sessionId string) func() {
+	lock, _ := a.sessionLocks.LoadOrStore(sessionId, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+
+	return lock.(*sync.Mutex).Unlock
+}
+
+func (a *LocalDirectoryAdapter) StartSession(ctx context.Context, spec core.ArtifactVersionSpec) (UploadSession, error) {
+	state, err := newUploadSessionState(spec, a.sessionTtl)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	sessionPath := a.sessionPath(state.Id)
+
+	// The chunks are stored unencrypted until the session is finalized, so only the server may read them.
+	err = os.MkdirAll(sessionPath, 0700)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	f, err := os.OpenFile(ospath.Join(sessionPath, "data"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return UploadSession{}, err
+	}
+	f.Close()
+
+	return state.session(), a.saveSession(state)
+}
+
+func (a *LocalDirectoryAdapter) GetSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string) (UploadSession, error) {
+	state, err := a.loadSession(spec, sessionId)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	return state.session(), nil
+}
+
+func (a *LocalDirectoryAdapter) WriteChunk(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string, offset int64, chunk io.Reader, size int64) (UploadSession, error) {
+	unlock := a.lockSession(sessionId)
+	defer unlock()
+
+	state, err := a.loadSession(spec, sessionId)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	if offset != state.Offset {
+		return state.session(), ErrOffsetMismatch
+	}
+
+	hasher, err := state.restoreHash()
+	if err != nil {
+		return state.session(), err
+	}
+
+	f, err := os.OpenFile(ospath.Join(a.sessionPath(sessionId), "data"), os.O_WRONLY, 0)
+	if err != nil {
+		return state.session(), err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return state.session(), err
+	}
+
+	n, err := io.Copy(f, io.TeeReader(chunk, hasher))
+	if err == nil && size >= 0 && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		// Discard the partially written chunk, so the client can retry it from the last offset.
+		f.Truncate(offset)
+
+		return state.session(), err
+	}
+
+	state.Offset += n
+	state.ExpiresAt = time.Now().Add(a.sessionTtl)
+
+	if err := state.saveHash(hasher); err != nil {
+		return state.session(), err
+	}
+
+	return state.session(), a.saveSession(state)
+}
+
+func (a *LocalDirectoryAdapter) FinalizeSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string, meta core.BlobMeta) (core.BlobMeta, error) {
+	unlock := a.lockSession(sessionId)
+	defer unlock()
+
+	state, err := a.loadSession(spec, sessionId)
+	if err != nil {
+		return meta, err
+	}
+
+	if err := state.verifySize(meta.Size); err != nil {
+		return meta, err
+	}
+
+	hash, err := state.verifyDigest(meta.Hash)
+	if err != nil {
+		return meta, err
+	}
+
+	meta.Hash = hash
+	meta.Size = state.Offset
+
+	// Compressing or encrypting the data may take a while, so it's done before locking.
+	// The blob is written next to the data and only moved into place under the lock, like an upload.
+	dataPath := ospath.Join(a.sessionPath(sessionId), "data")
+
+	if a.needsTransformation(spec.Namespace, meta) {
+		blobPath := ospath.Join(a.sessionPath(sessionId), "blob")
+
+		if err := a.storeSessionData(dataPath, blobPath, spec.Namespace, &meta); err != nil {
+			os.Remove(blobPath)
+			return meta, err
+		}
+
+		dataPath = blobPath
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	fullPath := ospath.Join(a.rootDirectory, spec.Namespace, spec.Name, spec.Version.String())
+
+	err = os.MkdirAll(fullPath, 0777)
+	if err != nil {
+		return meta, err
+	}
+
+	err = os.Rename(dataPath, ospath.Join(fullPath, "blob"))
+	if err != nil {
+		return meta, err
+	}
+
+	err = saveMeta(ospath.Join(fullPath, "meta.json"), meta)
+	if err != nil {
+		return meta, err
+	}
+
+	a.sessionLocks.Delete(sessionId)
+
+	return meta, removeAll(a.sessionPath(sessionId))
+}
+
+func (a *LocalDirectoryAdapter) storeSessionData(dataPath string, blobPath string, namespace string, meta *core.BlobMeta) error {
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return a.writeBlob(blobPath, namespace, f, meta)
+}
+
+func (a *LocalDirectoryAdapter) AbortSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string) error {
+	unlock := a.lockSession(sessionId)
+	defer unlock()
+
+	_, err := a.loadSession(spec, sessionId)
+	if err != nil {
+		return err
+	}
+
+	a.sessionLocks.Delete(sessionId)
+
+	return removeAll(a.sessionPath(sessionId))
+}
+
+func (a *LocalDirectoryAdapter) CleanupExpiredSessions(ctx context.Context) error {
+	sessionsPath := ospath.Join(a.rootDirectory, ".uploads")
+
+	exists, err := folderExists(sessionsPath)
+	if err != nil || !exists {
+		return err
+	}
+
+	sessionFolders, err := os.ReadDir(sessionsPath)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range sessionFolders {
+		state, err := a.readSession(f.Name())
+		if err != nil || !state.isExpired() {
+			continue
+		}
+
+		log.Println("Removing expired upload session", f.Name())
+
+		unlock := a.lockSession(f.Name())
+		err = removeAll(a.sessionPath(f.Name()))
+		unlock()
+
+		a.sessionLocks.Delete(f.Name())
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *LocalDirectoryAdapter) readSession(sessionId string) (*uploadSessionState, error) {
+	jsonBytes, err := os.ReadFile(ospath.Join(a.sessionPath(sessionId), "session.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	state := &uploadSessionState{}
+
+	err = json.Unmarshal(jsonBytes, state)
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (a *LocalDirectoryAdapter) loadSession(spec core.ArtifactVersionSpec, sessionId string) (*uploadSessionState, error) {
+	if sessionId == "" || strings.ContainsAny(sessionId, "/\\.") {
+		return nil, ErrUploadNotFound
+	}
+
+	state, err := a.readSession(sessionId)
+	if os.IsNotExist(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !state.belongsTo(spec) || state.isExpired() {
+		return nil, ErrUploadNotFound
+	}
+
+	return state, nil
+}
+
+func (a *LocalDirectoryAdapter) saveSession(state *uploadSessionState) error {
+	jsonBytes, _ := json.MarshalIndent(state, "", "  ")
+
+	return os.WriteFile(ospath.Join(a.sessionPath(state.Id), "session.json"), jsonBytes, 0644)
+}
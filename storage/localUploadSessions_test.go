@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	ospath "path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+func TestLocalUploadSession(t *testing.T) {
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+
+	adapter := LocalDirectory()
+	ctx := context.Background()
+	spec := core.ArtifactVersionSpec{
+		ArtifactSpec: core.ArtifactSpec{Namespace: "foo", Name: "bar"},
+		Version:      semver.MustParse("1.0.0"),
+	}
+
+	session, err := adapter.StartSession(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err = adapter.WriteChunk(ctx, spec, session.Id, 0, strings.NewReader("hello "), 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = adapter.WriteChunk(ctx, spec, session.Id, 0, strings.NewReader("again"), 5)
+	if !errors.Is(err, ErrOffsetMismatch) {
+		t.Fatalf("expected an offset mismatch, got %v", err)
+	}
+
+	session, err = adapter.WriteChunk(ctx, spec, session.Id, session.Offset, strings.NewReader("world"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.Offset != 11 {
+		t.Fatalf("unexpected offset %d", session.Offset)
+	}
+
+	_, err = adapter.FinalizeSession(ctx, spec, session.Id, core.BlobMeta{Hash: "sha256:0000"})
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+
+	sum := sha256.Sum256([]byte("hello world"))
+
+	meta, err := adapter.FinalizeSession(ctx, spec, session.Id, core.BlobMeta{Hash: "sha256:" + hex.EncodeToString(sum[:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if body := download(t, adapter, spec); body != "hello world" {
+		t.Fatalf("unexpected body %q with hash %s", body, meta.Hash)
+	}
+
+	if _, err := adapter.GetSession(ctx, spec, session.Id); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("expected the session to be gone, got %v", err)
+	}
+}
+
+func TestLocalUploadSessionTransformedOutsideLock(t *testing.T) {
+	directory := t.TempDir()
+
+	t.Setenv("STORAGE_DIRECTORY", directory)
+	t.Setenv("STORAGE_COMPRESSION_CONTENT_TYPES", "text/*")
+
+	adapter := LocalDirectory()
+	ctx := context.Background()
+	spec := core.ArtifactVersionSpec{
+		ArtifactSpec: core.ArtifactSpec{Namespace: "foo", Name: "bar"},
+		Version:      semver.MustParse("1.0.0"),
+	}
+
+	content := strings.Repeat("tiny repo ", 10000)
+
+	session, err := adapter.StartSession(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(ospath.Join(adapter.sessionPath(session.Id), "data")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected the unencrypted data to be private, got %v %v", info.Mode(), err)
+	}
+
+	session, err = adapter.WriteChunk(ctx, spec, session.Id, 0, strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256([]byte(content))
+	finalized := make(chan error)
+
+	// While the adapter is locked, the blob must still be compressed.
+	adapter.mutex.Lock()
+	go func() {
+		_, err := adapter.FinalizeSession(ctx, spec, session.Id, core.BlobMeta{ContentType: "text/plain", Hash: "sha256:" + hex.EncodeToString(sum[:])})
+		finalized <- err
+	}()
+
+	blobPath := ospath.Join(adapter.sessionPath(session.Id), "blob")
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if info, err := os.Stat(blobPath); err == nil && info.Size() > 0 && info.Size() < int64(len(content)) {
+			break
+		}
+		if time.Now().After(deadline) {
+			adapter.mutex.Unlock()
+			t.Fatal("expected the blob to be compressed before locking")
+		}
+	}
+	adapter.mutex.Unlock()
+
+	if err := <-finalized; err != nil {
+		t.Fatal(err)
+	}
+
+	if body := download(t, adapter, spec); body != content {
+		t.Fatal("decompressed content doesn't match")
+	}
+
+	if _, err := os.Stat(adapter.sessionPath(session.Id)); !os.IsNotExist(err) {
+		t.Errorf("expected the session to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	ospath "path"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+var ErrVersionNotFound = errors.New("version not found")
+
+// MetadataReader is implemented by adapters which can return the metadata of a blob without downloading it.
+type MetadataReader interface {
+	GetMeta(ctx context.Context, spec core.ArtifactVersionSpec) (core.BlobMeta, error)
+}
+
+func (a *LocalDirectoryAdapter) GetMeta(ctx context.Context, spec core.ArtifactVersionSpec) (core.BlobMeta, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	fullPath := ospath.Join(a.rootDirectory, spec.Namespace, spec.Name, spec.Version.String())
+
+	info, err := os.Stat(ospath.Join(fullPath, "blob"))
+	if os.IsNotExist(err) {
+		return core.BlobMeta{}, ErrVersionNotFound
+	}
+	if err != nil {
+		return core.BlobMeta{}, err
+	}
+
+	meta, err := readMeta(ospath.Join(fullPath, "meta.json"))
+	if err != nil {
+		return meta, err
+	}
+
+	// Blobs stored before the size was recorded are never transformed.
+	if meta.Size == 0 {
+		meta.Size = info.Size()
+	}
+
+	return meta, nil
+}
+
+func (a *MinioAdapter) GetMeta(ctx context.Context, spec core.ArtifactVersionSpec) (core.BlobMeta, error) {
+	info, err := a.client.StatObject(ctx, a.bucketName, versionPrefix(spec)+"blob", minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return core.BlobMeta{}, ErrVersionNotFound
+	}
+	if err != nil {
+		return core.BlobMeta{}, err
+	}
+
+	meta, err := a.readMeta(ctx, spec)
+	if err != nil {
+		return meta, err
+	}
+
+	if meta.Size == 0 {
+		meta.Size = info.Size
+	}
+
+	return meta, nil
+}
+
+func (a *ProxyAdapter) GetMeta(ctx context.Context, spec core.ArtifactVersionSpec) (core.BlobMeta, error) {
+	reader, ok := a.inner.(MetadataReader)
+	if !ok {
+		return core.BlobMeta{}, errors.New("the storage backend doesn't support metadata")
+	}
+
+	exists, err := a.hasLocalVersion(spec)
+	if err != nil {
+		return core.BlobMeta{}, err
+	}
+
+	if !exists {
+		err = a.fetchBlob(ctx, spec)
+		if errors.Is(err, errUpstreamNotFound) {
+			return core.BlobMeta{}, ErrVersionNotFound
+		}
+		if err != nil {
+			return core.BlobMeta{}, err
+		}
+	}
+
+	return reader.GetMeta(ctx, spec)
+}
@@ -1,11 +1,20 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	ospath "path"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sevensolutions/tiny-repo/core"
@@ -17,13 +26,20 @@ import (
 )
 
 type MinioAdapter struct {
-	client     *minio.Client
-	bucketName string
+	client            *minio.Client
+	bucketName        string
+	uploadUrlLifetime time.Duration
+	sessionTtl        time.Duration
+	sessionLocks      sync.Map
 }
 
 func MinIO() *MinioAdapter {
 	adapter := new(MinioAdapter)
 
+	if loadCompressionRules() != nil {
+		panic("Compression is only supported by the Local storage backend.")
+	}
+
 	endpoint := core.GetRequiredEnvVar("S3_ENDPOINT")
 	accessKeyID := core.GetRequiredEnvVar("S3_ACCESSKEY")
 	secretAccessKey := core.GetRequiredEnvVar("S3_SECRETKEY")
@@ -40,25 +56,95 @@ func MinIO() *MinioAdapter {
 
 	adapter.client = minioClient
 	adapter.bucketName = core.GetRequiredEnvVar("S3_BUCKETNAME")
+	adapter.uploadUrlLifetime = core.GetEnvVarDuration("S3_UPLOAD_URL_LIFETIME", time.Hour)
+	adapter.sessionTtl = core.GetEnvVarDuration("UPLOAD_SESSION_TTL", 24*time.Hour)
 
 	return adapter
 }
 
-func (a *MinioAdapter) Upload(ctx context.Context, spec core.ArtifactVersionSpec, target echo.Context, source io.Reader) error {
-	objectName := spec.Namespace + "/" + spec.Name + spec.Version.String() + "/blob"
+func versionPrefix(spec core.ArtifactVersionSpec) string {
+	return spec.Namespace + "/" + spec.Name + "/" + spec.Version.String() + "/"
+}
+
+func stagingObjectName(spec core.ArtifactVersionSpec, uploadId string) string {
+	return ".uploads/" + versionPrefix(spec) + uploadId
+}
+
+func (a *MinioAdapter) Upload(ctx context.Context, spec core.ArtifactVersionSpec, meta core.BlobMeta, source io.Reader) error {
+	objectName := versionPrefix(spec) + "blob"
+
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	// The blob is staged, so a failed upload doesn't replace an existing one.
+	stagingName := stagingObjectName(spec, core.RandomId())
+	defer a.client.RemoveObject(context.Background(), a.bucketName, stagingName, minio.RemoveObjectOptions{})
+
+	hasher := sha256.New()
 
-	_, err := a.client.PutObject(ctx, a.bucketName, objectName, source, -1, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	_, err := a.client.PutObject(ctx, a.bucketName, stagingName, io.TeeReader(source, hasher), -1, minio.PutObjectOptions{ContentType: contentType})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	hash := fmt.Sprintf("sha256:%s", hex.EncodeToString(hasher.Sum(nil)))
+
+	if meta.Hash != "" && meta.Hash != hash {
+		return fmt.Errorf("%w: expected %s but got %s", ErrDigestMismatch, meta.Hash, hash)
+	}
+
+	meta.Hash = hash
+
+	_, err = a.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: a.bucketName, Object: objectName},
+		minio.CopySrcOptions{Bucket: a.bucketName, Object: stagingName})
+	if err != nil {
+		return err
+	}
+
+	return a.saveMeta(ctx, spec, meta)
 }
 
 func (a *MinioAdapter) Download(ctx context.Context, spec core.ArtifactVersionSpec, target echo.Context) error {
-	objectName := spec.Namespace + "/" + spec.Name + spec.Version.String() + "/blob"
+	objectName := versionPrefix(spec) + "blob"
 
-	signedUrl, err := a.client.Presign(ctx, "GET", a.bucketName, objectName, time.Duration(5)*time.Minute, url.Values{})
+	_, err := a.client.StatObject(ctx, a.bucketName, objectName, minio.StatObjectOptions{})
+	if err != nil {
+		return target.String(http.StatusNotFound, "Not found")
+	}
+
+	meta, err := a.readMeta(ctx, spec)
+	if err != nil {
+		log.Println(err)
+	}
+
+	filename := meta.OriginalFilename
+
+	requestedFilename := target.Param("filename")
+
+	if requestedFilename != "" {
+		filename = requestedFilename
+	}
+
+	if filename == "" {
+		filename = "blob"
+	}
+
+	params := url.Values{}
+	params.Set("response-content-disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if meta.ContentType != "" {
+		params.Set("response-content-type", meta.ContentType)
+	}
+
+	// The hash is sent along with the redirect, because clients can't read it from the bucket.
+	if meta.Hash != "" {
+		target.Response().Header().Set(core.HeaderContentHash, meta.Hash)
+	}
+
+	signedUrl, err := a.client.Presign(ctx, "GET", a.bucketName, objectName, time.Duration(5)*time.Minute, params)
 
 	if err != nil {
 		log.Println(err)
@@ -70,9 +156,204 @@ func (a *MinioAdapter) Download(ctx context.Context, spec core.ArtifactVersionSp
 }
 
 func (a *MinioAdapter) GetVersions(artifactSpec core.ArtifactSpec) ([]*semver.Version, error) {
-	panic("Not implemented")
+	ctx := context.Background()
+
+	result := []*semver.Version{}
+
+	objects := a.client.ListObjects(ctx, a.bucketName, minio.ListObjectsOptions{
+		Prefix: artifactSpec.Namespace + "/" + artifactSpec.Name + "/",
+	})
+
+	for object := range objects {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		v, err := semver.NewVersion(ospath.Base(strings.TrimSuffix(object.Key, "/")))
+		if err == nil {
+			result = append(result, v)
+		}
+	}
+
+	return result, nil
 }
 
 func (a *MinioAdapter) DeleteVersion(spec core.ArtifactVersionSpec) error {
-	panic("Not implemented")
+	ctx := context.Background()
+
+	objects := a.client.ListObjects(ctx, a.bucketName, minio.ListObjectsOptions{
+		Prefix:    versionPrefix(spec),
+		Recursive: true,
+	})
+
+	for object := range objects {
+		if object.Err != nil {
+			return object.Err
+		}
+
+		err := a.client.RemoveObject(ctx, a.bucketName, object.Key, minio.RemoveObjectOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *MinioAdapter) ReadDocument(name string) ([]byte, error) {
+	object, err := a.client.GetObject(context.Background(), a.bucketName, ".system/"+name, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	content, err := io.ReadAll(object)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, nil
+	}
+
+	return content, err
+}
+
+func (a *MinioAdapter) WriteDocument(name string, content []byte) error {
+	_, err := a.client.PutObject(context.Background(), a.bucketName, ".system/"+name, bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{ContentType: "application/json"})
+
+	return err
+}
+
+func (a *MinioAdapter) PresignUpload(ctx context.Context, spec core.ArtifactVersionSpec) (PresignedUpload, error) {
+	uploadId := core.RandomId()
+	expiresAt := time.Now().Add(a.uploadUrlLifetime)
+
+	signedUrl, err := a.client.PresignedPutObject(ctx, a.bucketName, stagingObjectName(spec, uploadId), a.uploadUrlLifetime)
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+
+	return PresignedUpload{
+		UploadId:  uploadId,
+		Method:    "PUT",
+		Url:       signedUrl.String(),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+func (a *MinioAdapter) UploadSize(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string) (int64, error) {
+	if uploadId == "" || strings.ContainsAny(uploadId, "/.") {
+		return 0, ErrUploadNotFound
+	}
+
+	info, err := a.client.StatObject(ctx, a.bucketName, stagingObjectName(spec, uploadId), minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return 0, ErrUploadNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return info.Size, nil
+}
+
+func (a *MinioAdapter) AbortUpload(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string) error {
+	if uploadId == "" || strings.ContainsAny(uploadId, "/.") {
+		return ErrUploadNotFound
+	}
+
+	return a.client.RemoveObject(ctx, a.bucketName, stagingObjectName(spec, uploadId), minio.RemoveObjectOptions{})
+}
+
+func (a *MinioAdapter) CompleteUpload(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string, meta core.BlobMeta) (core.BlobMeta, error) {
+	if uploadId == "" || strings.ContainsAny(uploadId, "/.") {
+		return meta, ErrUploadNotFound
+	}
+
+	stagingName := stagingObjectName(spec, uploadId)
+
+	object, err := a.client.GetObject(ctx, a.bucketName, stagingName, minio.GetObjectOptions{})
+	if err != nil {
+		return meta, err
+	}
+	defer object.Close()
+
+	info, err := object.Stat()
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return meta, ErrUploadNotFound
+		}
+		return meta, err
+	}
+
+	// The object may have been replaced since its size has been checked against the quotas.
+	if meta.Size > 0 && meta.Size != info.Size {
+		a.client.RemoveObject(ctx, a.bucketName, stagingName, minio.RemoveObjectOptions{})
+
+		return meta, fmt.Errorf("%w: expected %d bytes but got %d", ErrSizeMismatch, meta.Size, info.Size)
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, object); err != nil {
+		return meta, err
+	}
+
+	hash := fmt.Sprintf("sha256:%s", hex.EncodeToString(hasher.Sum(nil)))
+
+	if meta.Hash != "" && meta.Hash != hash {
+		a.client.RemoveObject(ctx, a.bucketName, stagingName, minio.RemoveObjectOptions{})
+
+		return meta, fmt.Errorf("%w: expected %s but got %s", ErrDigestMismatch, meta.Hash, hash)
+	}
+
+	meta.Hash = hash
+
+	if meta.ContentType == "" {
+		meta.ContentType = info.ContentType
+	}
+
+	// The presigned URL is still valid, so the object must not be replaced after it has been verified.
+	_, err = a.client.ComposeObject(ctx,
+		minio.CopyDestOptions{Bucket: a.bucketName, Object: versionPrefix(spec) + "blob"},
+		minio.CopySrcOptions{Bucket: a.bucketName, Object: stagingName, MatchETag: info.ETag})
+	if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+		a.client.RemoveObject(ctx, a.bucketName, stagingName, minio.RemoveObjectOptions{})
+
+		return meta, fmt.Errorf("%w: the upload has been replaced while it was verified", ErrDigestMismatch)
+	}
+	if err != nil {
+		return meta, err
+	}
+
+	err = a.client.RemoveObject(ctx, a.bucketName, stagingName, minio.RemoveObjectOptions{})
+	if err != nil {
+		log.Println(err)
+	}
+
+	return meta, a.saveMeta(ctx, spec, meta)
+}
+
+func (a *MinioAdapter) saveMeta(ctx context.Context, spec core.ArtifactVersionSpec, meta core.BlobMeta) error {
+	jsonBytes, _ := json.MarshalIndent(meta, "", "  ")
+
+	_, err := a.client.PutObject(ctx, a.bucketName, versionPrefix(spec)+"meta.json", bytes.NewReader(jsonBytes), int64(len(jsonBytes)), minio.PutObjectOptions{ContentType: "application/json"})
+
+	return err
+}
+
+func (a *MinioAdapter) readMeta(ctx context.Context, spec core.ArtifactVersionSpec) (core.BlobMeta, error) {
+	meta := core.BlobMeta{}
+
+	object, err := a.client.GetObject(ctx, a.bucketName, versionPrefix(spec)+"meta.json", minio.GetObjectOptions{})
+	if err != nil {
+		return meta, err
+	}
+	defer object.Close()
+
+	err = json.NewDecoder(object).Decode(&meta)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" || errors.Is(err, io.EOF) {
+		return meta, nil
+	}
+	if err != nil {
+		return meta, err
+	}
+
+	return meta, nil
 }
@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sevensolutions/tiny-repo/core"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// S3 requires every part of a multipart upload, except the last one, to be at least 5 MiB.
+const minPartSize = 5 * 1024 * 1024
+
+func sessionObjectName(sessionId string) string {
+	return ".uploads/sessions/" + sessionId + ".json"
+}
+
+func (a *MinioAdapter) lockSession(sessionId string) func() {
+	lock, _ := a.sessionLocks.LoadOrStore(sessionId, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+
+	return lock.(*sync.Mutex).Unlock
+}
+
+func (a *MinioAdapter) StartSession(ctx context.Context, spec core.ArtifactVersionSpec) (UploadSession, error) {
+	state, err := newUploadSessionState(spec, a.sessionTtl)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	minioCore := minio.Core{Client: a.client}
+
+	uploadId, err := minioCore.NewMultipartUpload(ctx, a.bucketName, versionPrefix(spec)+"blob", minio.PutObjectOptions{})
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	state.MultipartUploadId = uploadId
+
+	return state.session(), a.saveSession(ctx, state)
+}
+
+func (a *MinioAdapter) GetSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string) (UploadSession, error) {
+	state, err := a.loadSession(ctx, spec, sessionId)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	return state.session(), nil
+}
+
+func (a *MinioAdapter) WriteChunk(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string, offset int64, chunk io.Reader, size int64) (UploadSession, error) {
+	unlock := a.lockSession(sessionId)
+	defer unlock()
+
+	state, err := a.loadSession(ctx, spec, sessionId)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	if offset != state.Offset {
+		return state.session(), ErrOffsetMismatch
+	}
+
+	if size < 0 {
+		return state.session(), ErrLengthRequired
+	}
+
+	if len(state.Parts) > 0 && state.Parts[len(state.Parts)-1].Size < minPartSize {
+		return state.session(), ErrChunkTooSmall
+	}
+
+	hasher, err := state.restoreHash()
+	if err != nil {
+		return state.session(), err
+	}
+
+	minioCore := minio.Core{Client: a.client}
+	partNumber := len(state.Parts) + 1
+
+	part, err := minioCore.PutObjectPart(ctx, a.bucketName, versionPrefix(spec)+"blob", state.MultipartUploadId, partNumber, io.TeeReader(chunk, hasher), size, minio.PutObjectPartOptions{})
+	if err != nil {
+		return state.session(), err
+	}
+
+	state.Parts = append(state.Parts, sessionPart{
+		PartNumber: partNumber,
+		ETag:       part.ETag,
+		Size:       size,
+	})
+	state.Offset += size
+	state.ExpiresAt = time.Now().Add(a.sessionTtl)
+
+	if err := state.saveHash(hasher); err != nil {
+		return state.session(), err
+	}
+
+	return state.session(), a.saveSession(ctx, state)
+}
+
+func (a *MinioAdapter) FinalizeSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string, meta core.BlobMeta) (core.BlobMeta, error) {
+	unlock := a.lockSession(sessionId)
+	defer unlock()
+
+	state, err := a.loadSession(ctx, spec, sessionId)
+	if err != nil {
+		return meta, err
+	}
+
+	if err := state.verifySize(meta.Size); err != nil {
+		return meta, err
+	}
+
+	hash, err := state.verifyDigest(meta.Hash)
+	if err != nil {
+		return meta, err
+	}
+
+	meta.Hash = hash
+
+	objectName := versionPrefix(spec) + "blob"
+	minioCore := minio.Core{Client: a.client}
+
+	contentType := meta.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	if len(state.Parts) == 0 {
+		// S3 doesn't allow completing a multipart upload without any parts.
+		minioCore.AbortMultipartUpload(ctx, a.bucketName, objectName, state.MultipartUploadId)
+
+		_, err = a.client.PutObject(ctx, a.bucketName, objectName, bytes.NewReader([]byte{}), 0, minio.PutObjectOptions{ContentType: contentType})
+	} else {
+		_, err = minioCore.CompleteMultipartUpload(ctx, a.bucketName, objectName, state.MultipartUploadId,
+			core.MapArray(state.Parts, func(p sessionPart) minio.CompletePart {
+				return minio.CompletePart{PartNumber: p.PartNumber, ETag: p.ETag}
+			}),
+			minio.PutObjectOptions{ContentType: contentType})
+	}
+	if err != nil {
+		return meta, err
+	}
+
+	err = a.saveMeta(ctx, spec, meta)
+	if err != nil {
+		return meta, err
+	}
+
+	a.sessionLocks.Delete(sessionId)
+
+	return meta, a.client.RemoveObject(ctx, a.bucketName, sessionObjectName(sessionId), minio.RemoveObjectOptions{})
+}
+
+func (a *MinioAdapter) AbortSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string) error {
+	unlock := a.lockSession(sessionId)
+	defer unlock()
+
+	state, err := a.loadSession(ctx, spec, sessionId)
+	if err != nil {
+		return err
+	}
+
+	a.sessionLocks.Delete(sessionId)
+
+	return a.removeSession(ctx, state)
+}
+
+func (a *MinioAdapter) CleanupExpiredSessions(ctx context.Context) error {
+	objects := a.client.ListObjects(ctx, a.bucketName, minio.ListObjectsOptions{
+		Prefix: ".uploads/sessions/",
+	})
+
+	for object := range objects {
+		if object.Err != nil {
+			return object.Err
+		}
+
+		sessionId := strings.TrimSuffix(strings.TrimPrefix(object.Key, ".uploads/sessions/"), ".json")
+
+		state, err := a.readSession(ctx, sessionId)
+		if err != nil || !state.isExpired() {
+			continue
+		}
+
+		log.Println("Removing expired upload session", sessionId)
+
+		unlock := a.lockSession(sessionId)
+		err = a.removeSession(ctx, state)
+		unlock()
+
+		a.sessionLocks.Delete(sessionId)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *MinioAdapter) removeSession(ctx context.Context, state *uploadSessionState) error {
+	minioCore := minio.Core{Client: a.client}
+
+	err := minioCore.AbortMultipartUpload(ctx, a.bucketName, state.Namespace+"/"+state.Name+"/"+state.Version+"/blob", state.MultipartUploadId)
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchUpload" {
+		return err
+	}
+
+	return a.client.RemoveObject(ctx, a.bucketName, sessionObjectName(state.Id), minio.RemoveObjectOptions{})
+}
+
+func (a *MinioAdapter) readSession(ctx context.Context, sessionId string) (*uploadSessionState, error) {
+	object, err := a.client.GetObject(ctx, a.bucketName, sessionObjectName(sessionId), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer object.Close()
+
+	state := &uploadSessionState{}
+
+	err = json.NewDecoder(object).Decode(state)
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (a *MinioAdapter) loadSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string) (*uploadSessionState, error) {
+	if sessionId == "" || strings.ContainsAny(sessionId, "/\\.") {
+		return nil, ErrUploadNotFound
+	}
+
+	state, err := a.readSession(ctx, sessionId)
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !state.belongsTo(spec) || state.isExpired() {
+		return nil, ErrUploadNotFound
+	}
+
+	return state, nil
+}
+
+func (a *MinioAdapter) saveSession(ctx context.Context, state *uploadSessionState) error {
+	jsonBytes, _ := json.MarshalIndent(state, "", "  ")
+
+	_, err := a.client.PutObject(ctx, a.bucketName, sessionObjectName(state.Id), bytes.NewReader(jsonBytes), int64(len(jsonBytes)), minio.PutObjectOptions{ContentType: "application/json"})
+
+	return err
+}
@@ -0,0 +1,398 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sevensolutions/tiny-repo/core"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/labstack/echo/v4"
+)
+
+var errUpstreamNotFound = errors.New("not found on upstream")
+
+// ProxyAdapter turns a TinyRepo instance into a read-through cache of an upstream TinyRepo.
+// Blobs missing locally are fetched from the upstream and stored in the wrapped adapter.
+type ProxyAdapter struct {
+	inner        StorageAdapter
+	upstreamUrl  string
+	token        string
+	cacheTtl     time.Duration
+	client       *http.Client
+	mutex        sync.Mutex
+	fetchLocks   map[string]*fetchLock
+	versionCache map[core.ArtifactSpec]cachedVersions
+}
+
+// fetchLock is removed once no request holds or waits for it anymore.
+type fetchLock struct {
+	mutex sync.Mutex
+	users int
+}
+
+type cachedVersions struct {
+	versions  []*semver.Version
+	fetchedAt time.Time
+}
+
+type upstreamVersionsResponse struct {
+	Versions []string `json:"versions"`
+}
+
+func Proxy(inner StorageAdapter) *ProxyAdapter {
+	adapter := new(ProxyAdapter)
+	adapter.inner = inner
+	adapter.upstreamUrl = strings.TrimSuffix(core.GetRequiredEnvVar("UPSTREAM_URL"), "/")
+	adapter.token = core.GetEnvVar("UPSTREAM_TOKEN", "")
+	adapter.cacheTtl = core.GetEnvVarDuration("UPSTREAM_CACHE_TTL", 5*time.Minute)
+	adapter.client = &http.Client{}
+	adapter.fetchLocks = map[string]*fetchLock{}
+	adapter.versionCache = map[core.ArtifactSpec]cachedVersions{}
+
+	return adapter
+}
+
+func (a *ProxyAdapter) Upload(ctx context.Context, spec core.ArtifactVersionSpec, meta core.BlobMeta, source io.Reader) error {
+	return a.inner.Upload(ctx, spec, meta, source)
+}
+
+func (a *ProxyAdapter) Download(ctx context.Context, spec core.ArtifactVersionSpec, target echo.Context) error {
+	exists, err := a.hasLocalVersion(spec)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		err = a.fetchBlob(ctx, spec)
+
+		if errors.Is(err, errUpstreamNotFound) {
+			return target.NoContent(http.StatusNotFound)
+		}
+		if err != nil {
+			log.Println(err)
+
+			return echo.NewHTTPError(http.StatusBadGateway, "artifact is not cached and the upstream is unavailable")
+		}
+	}
+
+	return a.inner.Download(ctx, spec, target)
+}
+
+func (a *ProxyAdapter) GetVersions(artifactSpec core.ArtifactSpec) ([]*semver.Version, error) {
+	localVersions, err := a.inner.GetVersions(artifactSpec)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mutex.Lock()
+	cached, isCached := a.versionCache[artifactSpec]
+	a.mutex.Unlock()
+
+	if isCached && time.Since(cached.fetchedAt) < a.cacheTtl {
+		return mergeVersions(localVersions, cached.versions), nil
+	}
+
+	remoteVersions, err := a.fetchVersions(artifactSpec)
+	if err != nil {
+		// Offline operation: serve whatever we know, even if the cache entry is stale.
+		log.Println(err)
+
+		if isCached {
+			return mergeVersions(localVersions, cached.versions), nil
+		}
+
+		return localVersions, nil
+	}
+
+	a.mutex.Lock()
+	a.versionCache[artifactSpec] = cachedVersions{
+		versions:  remoteVersions,
+		fetchedAt: time.Now(),
+	}
+	a.mutex.Unlock()
+
+	return mergeVersions(localVersions, remoteVersions), nil
+}
+
+func (a *ProxyAdapter) DeleteVersion(spec core.ArtifactVersionSpec) error {
+	return a.inner.DeleteVersion(spec)
+}
+
+func (a *ProxyAdapter) ReadDocument(name string) ([]byte, error) {
+	documents, ok := a.inner.(DocumentStore)
+	if !ok {
+		return nil, errors.New("the storage backend doesn't support documents")
+	}
+
+	return documents.ReadDocument(name)
+}
+
+func (a *ProxyAdapter) WriteDocument(name string, content []byte) error {
+	documents, ok := a.inner.(DocumentStore)
+	if !ok {
+		return errors.New("the storage backend doesn't support documents")
+	}
+
+	return documents.WriteDocument(name, content)
+}
+
+// The upload methods are forwarded to the inner adapter, as uploads aren't proxied.
+// If it doesn't support them, errors.ErrUnsupported is returned.
+
+func (a *ProxyAdapter) PresignUpload(ctx context.Context, spec core.ArtifactVersionSpec) (PresignedUpload, error) {
+	uploader, ok := a.inner.(PresignedUploader)
+	if !ok {
+		return PresignedUpload{}, errors.ErrUnsupported
+	}
+
+	return uploader.PresignUpload(ctx, spec)
+}
+
+func (a *ProxyAdapter) UploadSize(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string) (int64, error) {
+	uploader, ok := a.inner.(PresignedUploader)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+
+	return uploader.UploadSize(ctx, spec, uploadId)
+}
+
+func (a *ProxyAdapter) CompleteUpload(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string, meta core.BlobMeta) (core.BlobMeta, error) {
+	uploader, ok := a.inner.(PresignedUploader)
+	if !ok {
+		return meta, errors.ErrUnsupported
+	}
+
+	return uploader.CompleteUpload(ctx, spec, uploadId, meta)
+}
+
+func (a *ProxyAdapter) AbortUpload(ctx context.Context, spec core.ArtifactVersionSpec, uploadId string) error {
+	uploader, ok := a.inner.(PresignedUploader)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+
+	return uploader.AbortUpload(ctx, spec, uploadId)
+}
+
+func (a *ProxyAdapter) StartSession(ctx context.Context, spec core.ArtifactVersionSpec) (UploadSession, error) {
+	uploader, ok := a.inner.(ChunkedUploader)
+	if !ok {
+		return UploadSession{}, errors.ErrUnsupported
+	}
+
+	return uploader.StartSession(ctx, spec)
+}
+
+func (a *ProxyAdapter) GetSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string) (UploadSession, error) {
+	uploader, ok := a.inner.(ChunkedUploader)
+	if !ok {
+		return UploadSession{}, errors.ErrUnsupported
+	}
+
+	return uploader.GetSession(ctx, spec, sessionId)
+}
+
+func (a *ProxyAdapter) WriteChunk(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string, offset int64, chunk io.Reader, size int64) (UploadSession, error) {
+	uploader, ok := a.inner.(ChunkedUploader)
+	if !ok {
+		return UploadSession{}, errors.ErrUnsupported
+	}
+
+	return uploader.WriteChunk(ctx, spec, sessionId, offset, chunk, size)
+}
+
+func (a *ProxyAdapter) FinalizeSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string, meta core.BlobMeta) (core.BlobMeta, error) {
+	uploader, ok := a.inner.(ChunkedUploader)
+	if !ok {
+		return meta, errors.ErrUnsupported
+	}
+
+	return uploader.FinalizeSession(ctx, spec, sessionId, meta)
+}
+
+func (a *ProxyAdapter) AbortSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string) error {
+	uploader, ok := a.inner.(ChunkedUploader)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+
+	return uploader.AbortSession(ctx, spec, sessionId)
+}
+
+func (a *ProxyAdapter) CleanupExpiredSessions(ctx context.Context) error {
+	uploader, ok := a.inner.(ChunkedUploader)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+
+	return uploader.CleanupExpiredSessions(ctx)
+}
+
+func (a *ProxyAdapter) hasLocalVersion(spec core.ArtifactVersionSpec) (bool, error) {
+	versions, err := a.inner.GetVersions(spec.ArtifactSpec)
+	if err != nil {
+		return false, err
+	}
+
+	for _, v := range versions {
+		if v != nil && v.Equal(spec.Version) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (a *ProxyAdapter) newUpstreamRequest(ctx context.Context, path string) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", a.upstreamUrl+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.token != "" {
+		request.Header.Add("Authorization", "Bearer "+a.token)
+	}
+
+	return request, nil
+}
+
+func (a *ProxyAdapter) fetchVersions(artifactSpec core.ArtifactSpec) ([]*semver.Version, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	request, err := a.newUpstreamRequest(ctx, "/"+artifactSpec.Namespace+"/"+artifactSpec.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := a.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return []*semver.Version{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream returned %s for versions of %s/%s", resp.Status, artifactSpec.Namespace, artifactSpec.Name)
+	}
+
+	response := upstreamVersionsResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	versions := []*semver.Version{}
+	for _, raw := range response.Versions {
+		v, err := semver.NewVersion(raw)
+		if err == nil {
+			versions = append(versions, v)
+		}
+	}
+
+	return versions, nil
+}
+
+// lockFetch serializes fetches of the same version, while other versions are fetched concurrently.
+func (a *ProxyAdapter) lockFetch(spec core.ArtifactVersionSpec) func() {
+	key := versionPrefix(spec)
+
+	a.mutex.Lock()
+	lock, ok := a.fetchLocks[key]
+	if !ok {
+		lock = &fetchLock{}
+		a.fetchLocks[key] = lock
+	}
+	lock.users++
+	a.mutex.Unlock()
+
+	lock.mutex.Lock()
+
+	return func() {
+		a.mutex.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(a.fetchLocks, key)
+		}
+		a.mutex.Unlock()
+
+		lock.mutex.Unlock()
+	}
+}
+
+func (a *ProxyAdapter) fetchBlob(ctx context.Context, spec core.ArtifactVersionSpec) error {
+	unlock := a.lockFetch(spec)
+	defer unlock()
+
+	// Another request may have fetched the blob while we were waiting.
+	exists, err := a.hasLocalVersion(spec)
+	if err != nil || exists {
+		return err
+	}
+
+	request, err := a.newUpstreamRequest(ctx, "/"+spec.Namespace+"/"+spec.Name+"/"+spec.Version.String())
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errUpstreamNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("upstream returned %s for %s/%s/%s", resp.Status, spec.Namespace, spec.Name, spec.Version)
+	}
+
+	// The inner adapter verifies the hash of the upstream, so a truncated transfer isn't cached.
+	meta := core.BlobMeta{
+		ContentType: resp.Header.Get("Content-Type"),
+		Hash:        resp.Header.Get(core.HeaderContentHash),
+	}
+
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		meta.OriginalFilename = params["filename"]
+	}
+
+	// A failed upload doesn't leave anything behind, as the adapters stage blobs before committing them.
+	err = a.inner.Upload(ctx, spec, meta, resp.Body)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Cached version", spec.Version, "of", spec.Namespace+"/"+spec.Name, "from upstream")
+
+	return nil
+}
+
+func mergeVersions(a []*semver.Version, b []*semver.Version) []*semver.Version {
+	result := []*semver.Version{}
+	seen := map[string]bool{}
+
+	for _, v := range append(append([]*semver.Version{}, a...), b...) {
+		if v == nil || seen[v.String()] {
+			continue
+		}
+
+		seen[v.String()] = true
+		result = append(result, v)
+	}
+
+	return result
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/labstack/echo/v4"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+func TestProxyCachesUpstream(t *testing.T) {
+	blobRequests := 0
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer service-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/foo/bar":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"count":2,"latest":"1.1.0","versions":["1.1.0","1.0.0"]}`))
+		case "/foo/bar/1.1.0":
+			blobRequests++
+			w.Header().Set("Content-Disposition", `attachment; filename="bar.txt"`)
+			w.Write([]byte("hello"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+	t.Setenv("UPSTREAM_URL", upstream.URL)
+	t.Setenv("UPSTREAM_TOKEN", "service-token")
+	t.Setenv("UPSTREAM_CACHE_TTL", "1h")
+
+	proxy := Proxy(LocalDirectory())
+	artifact := core.ArtifactSpec{Namespace: "foo", Name: "bar"}
+
+	versions, err := GetSortedVersions(proxy, artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[0].String() != "1.1.0" {
+		t.Fatalf("unexpected versions %v", versions)
+	}
+
+	spec := core.ArtifactVersionSpec{ArtifactSpec: artifact, Version: semver.MustParse("1.1.0")}
+
+	for i := 0; i < 2; i++ {
+		if body := download(t, proxy, spec); body != "hello" {
+			t.Fatalf("unexpected body %q", body)
+		}
+	}
+
+	if blobRequests != 1 {
+		t.Fatalf("expected the blob to be fetched once, got %d", blobRequests)
+	}
+
+	// Offline: cached blobs and version lists must still be served.
+	upstream.Close()
+	proxy.cacheTtl = 0
+
+	versions, err = GetSortedVersions(proxy, artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("expected stale versions while offline, got %v", versions)
+	}
+
+	if body := download(t, proxy, spec); body != "hello" {
+		t.Fatalf("unexpected body %q while offline", body)
+	}
+}
+
+func download(t *testing.T, adapter StorageAdapter, spec core.ArtifactVersionSpec) string {
+	request := httptest.NewRequest("GET", "/", nil)
+	recorder := httptest.NewRecorder()
+	c := echo.New().NewContext(request, recorder)
+
+	if err := adapter.Download(request.Context(), spec, c); err != nil {
+		t.Fatal(err)
+	}
+
+	return recorder.Body.String()
+}
+
+func TestProxyFetchesConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	requests := make(chan string, 10)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.Path
+
+		// The large blob is slow.
+		if r.URL.Path == "/foo/bar/1.0.0" {
+			w.Write([]byte("large"))
+			w.(http.Flusher).Flush()
+			<-release
+			return
+		}
+
+		w.Write([]byte("small"))
+	}))
+	defer upstream.Close()
+
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+	t.Setenv("UPSTREAM_URL", upstream.URL)
+
+	proxy := Proxy(LocalDirectory())
+	artifact := core.ArtifactSpec{Namespace: "foo", Name: "bar"}
+	large := core.ArtifactVersionSpec{ArtifactSpec: artifact, Version: semver.MustParse("1.0.0")}
+	small := core.ArtifactVersionSpec{ArtifactSpec: artifact, Version: semver.MustParse("1.1.0")}
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- proxy.fetchBlob(context.Background(), large) }()
+	}
+	<-requests
+
+	// Other versions don't wait for the slow one.
+	if err := proxy.fetchBlob(context.Background(), small); err != nil {
+		t.Fatal(err)
+	}
+	if path := <-requests; path != "/foo/bar/1.1.0" {
+		t.Fatalf("unexpected request %s", path)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Concurrent requests for the same version fetch it only once.
+	if len(requests) != 0 {
+		t.Errorf("expected the large blob to be fetched once, got %d more requests", len(requests))
+	}
+
+	if body := download(t, proxy, large); body != "large" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	if len(proxy.fetchLocks) != 0 {
+		t.Errorf("expected the fetch locks to be removed, got %d", len(proxy.fetchLocks))
+	}
+}
+
+func TestProxyVerifiesUpstreamHash(t *testing.T) {
+	truncated := true
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256([]byte("hello"))
+		w.Header().Set(core.HeaderContentHash, "sha256:"+hex.EncodeToString(sum[:]))
+
+		if truncated {
+			w.Write([]byte("hel"))
+			return
+		}
+
+		w.Write([]byte("hello"))
+	}))
+	defer upstream.Close()
+
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+	t.Setenv("UPSTREAM_URL", upstream.URL)
+
+	proxy := Proxy(LocalDirectory())
+	spec := core.ArtifactVersionSpec{ArtifactSpec: core.ArtifactSpec{Namespace: "foo", Name: "bar"}, Version: semver.MustParse("1.0.0")}
+
+	if err := proxy.fetchBlob(context.Background(), spec); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("expected a digest mismatch, got %v", err)
+	}
+	if exists, _ := proxy.hasLocalVersion(spec); exists {
+		t.Fatal("expected the corrupted blob not to be cached")
+	}
+
+	truncated = false
+
+	if body := download(t, proxy, spec); body != "hello" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestProxyForwardsUploads(t *testing.T) {
+	t.Setenv("STORAGE_DIRECTORY", t.TempDir())
+	t.Setenv("UPSTREAM_URL", "http://upstream.invalid")
+
+	proxy := Proxy(LocalDirectory())
+	ctx := context.Background()
+	spec := core.ArtifactVersionSpec{ArtifactSpec: core.ArtifactSpec{Namespace: "foo", Name: "bar"}, Version: semver.MustParse("1.0.0")}
+
+	session, err := proxy.StartSession(ctx, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proxy.GetSession(ctx, spec, session.Id); err != nil {
+		t.Errorf("expected the session of the inner adapter, got %v", err)
+	}
+
+	// The local adapter has no presigned uploads.
+	if _, err := proxy.PresignUpload(ctx, spec); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("expected presigned uploads to be unsupported, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+var ErrReservationsNotSupported = errors.New("the storage backend doesn't support version reservations")
+
+var reservationsMutex sync.Mutex
+
+// Reservation keeps a version from being computed as next version again, until it is pushed or expires.
+type Reservation struct {
+	Version   string    `json:"version"`
+	Token     string    `json:"token,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func reservationsDocument(spec core.ArtifactSpec) string {
+	return "reservations/" + spec.Namespace + "/" + spec.Name + ".json"
+}
+
+// Reserve computes the next version using next, which gets all existing and reserved versions, and reserves it for ttl.
+// The computation and the reservation are atomic within the server.
+func Reserve(adapter StorageAdapter, spec core.ArtifactSpec, token string, ttl time.Duration, next func(taken []*semver.Version) (*semver.Version, error)) (Reservation, error) {
+	documents, ok := adapter.(DocumentStore)
+	if !ok {
+		return Reservation{}, ErrReservationsNotSupported
+	}
+
+	reservationsMutex.Lock()
+	defer reservationsMutex.Unlock()
+
+	content, err := documents.ReadDocument(reservationsDocument(spec))
+	if err != nil {
+		return Reservation{}, err
+	}
+
+	reservations := []Reservation{}
+	if content != nil {
+		if err := json.Unmarshal(content, &reservations); err != nil {
+			return Reservation{}, err
+		}
+	}
+
+	taken, err := GetSortedVersions(adapter, spec)
+	if err != nil {
+		return Reservation{}, err
+	}
+
+	existing := map[string]bool{}
+	for _, v := range taken {
+		existing[v.String()] = true
+	}
+
+	// Drop expired reservations and those, which have been pushed in the meantime.
+	now := time.Now().UTC()
+	reservations = core.FilterArray(reservations, func(r Reservation) bool {
+		return r.ExpiresAt.After(now) && !existing[r.Version]
+	})
+
+	for _, r := range reservations {
+		if v, err := semver.NewVersion(r.Version); err == nil {
+			taken = append(taken, v)
+		}
+	}
+
+	version, err := next(taken)
+	if err != nil {
+		return Reservation{}, err
+	}
+
+	reservation := Reservation{Version: version.String(), Token: token, ExpiresAt: now.Add(ttl)}
+	reservations = append(reservations, reservation)
+
+	content, _ = json.MarshalIndent(reservations, "", "  ")
+
+	if err := documents.WriteDocument(reservationsDocument(spec), content); err != nil {
+		return Reservation{}, err
+	}
+
+	return reservation, nil
+}
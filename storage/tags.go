@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+var ErrTagsNotSupported = errors.New("the storage backend doesn't support tags")
+
+var tagsMutex sync.Mutex
+
+func tagsDocument(spec core.ArtifactSpec) string {
+	return "tags/" + spec.Namespace + "/" + spec.Name + ".json"
+}
+
+// GetTags returns the tags of an artifact and the versions they point to.
+func GetTags(adapter StorageAdapter, spec core.ArtifactSpec) (map[string]string, error) {
+	documents, ok := adapter.(DocumentStore)
+	if !ok {
+		return nil, ErrTagsNotSupported
+	}
+
+	content, err := documents.ReadDocument(tagsDocument(spec))
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{}
+	if content != nil {
+		if err := json.Unmarshal(content, &tags); err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+// SetTag points a tag to a version. A nil version deletes the tag.
+func SetTag(adapter StorageAdapter, spec core.ArtifactSpec, tag string, version *semver.Version) error {
+	tagsMutex.Lock()
+	defer tagsMutex.Unlock()
+
+	tags, err := GetTags(adapter, spec)
+	if err != nil {
+		return err
+	}
+
+	if version == nil {
+		delete(tags, tag)
+	} else {
+		tags[tag] = version.String()
+	}
+
+	content, _ := json.MarshalIndent(tags, "", "  ")
+
+	return adapter.(DocumentStore).WriteDocument(tagsDocument(spec), content)
+}
@@ -3,35 +3,60 @@ package storage
 import (
 	"log"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/sevensolutions/tiny-repo/core"
 )
 
-func Tidy(storage StorageAdapter, artifactSpec core.ArtifactSpec, keep int, belowVersion *core.ArtifactVersionSpec) error {
+// Tidy deletes all but the newest keep versions and returns the deleted versions.
+func Tidy(storage StorageAdapter, artifactSpec core.ArtifactSpec, keep int, belowVersion *core.ArtifactVersionSpec) ([]*semver.Version, error) {
 	versions, err := GetSortedVersions(storage, artifactSpec)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var below *semver.Version
+	if belowVersion != nil {
+		below = belowVersion.Version
+	}
+
+	deleted := []*semver.Version{}
+
+	for _, v := range TidyCandidates(versions, keep, below) {
+		log.Println("Deleting version", v)
+
+		spec := core.ArtifactVersionSpec{
+			ArtifactSpec: artifactSpec,
+			Version:      v,
+		}
+
+		if err := storage.DeleteVersion(spec); err != nil {
+			log.Println(err)
+		} else {
+			deleted = append(deleted, v)
+		}
+	}
+
+	return deleted, nil
+}
+
+// TidyCandidates returns the versions Tidy deletes, given the versions sorted newest first.
+// Versions above belowVersion are neither deleted nor counted.
+func TidyCandidates(versions []*semver.Version, keep int, belowVersion *semver.Version) []*semver.Version {
+	candidates := []*semver.Version{}
+
 	i := 0
 
 	for _, v := range versions {
-		if belowVersion != nil && v.Compare(belowVersion.Version) > 0 {
+		if belowVersion != nil && v.Compare(belowVersion) > 0 {
 			continue
 		}
 
 		if i >= keep {
-			log.Println("Deleting version", v)
-
-			spec := core.ArtifactVersionSpec{
-				ArtifactSpec: artifactSpec,
-				Version:      v,
-			}
-
-			storage.DeleteVersion(spec)
+			candidates = append(candidates, v)
 		}
 
 		i++
 	}
 
-	return nil
+	return candidates
 }
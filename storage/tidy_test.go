@@ -22,7 +22,29 @@ func TestTidy(t *testing.T) {
 	}, 3, nil)
 }
 
-func (s *testStorage) Upload(ctx context.Context, spec core.ArtifactVersionSpec, target echo.Context, source io.Reader) error {
+func TestTidyCandidates(t *testing.T) {
+	versions := []*semver.Version{
+		semver.MustParse("2.0.0"),
+		semver.MustParse("1.2.0"),
+		semver.MustParse("1.1.0"),
+		semver.MustParse("1.0.0"),
+	}
+
+	if candidates := TidyCandidates(versions, 2, nil); len(candidates) != 2 || candidates[0].String() != "1.1.0" {
+		t.Errorf("unexpected candidates %v", candidates)
+	}
+
+	// Versions above 1.2.0 aren't counted.
+	if candidates := TidyCandidates(versions, 2, semver.MustParse("1.2.0")); len(candidates) != 1 || candidates[0].String() != "1.0.0" {
+		t.Errorf("unexpected candidates %v", candidates)
+	}
+
+	if candidates := TidyCandidates(versions, 0, nil); len(candidates) != 4 {
+		t.Errorf("unexpected candidates %v", candidates)
+	}
+}
+
+func (s *testStorage) Upload(ctx context.Context, spec core.ArtifactVersionSpec, meta core.BlobMeta, source io.Reader) error {
 	return nil
 }
 func (s *testStorage) Download(ctx context.Context, spec core.ArtifactVersionSpec, target echo.Context) error {
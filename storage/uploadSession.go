@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+var ErrOffsetMismatch = errors.New("offset mismatch")
+var ErrChunkTooSmall = errors.New("chunk too small")
+var ErrLengthRequired = errors.New("chunk length required")
+
+// ChunkedUploader is implemented by adapters which support resumable upload sessions.
+// Chunks must be written in order, a failed chunk is discarded and can simply be retried.
+type ChunkedUploader interface {
+	StartSession(ctx context.Context, spec core.ArtifactVersionSpec) (UploadSession, error)
+	GetSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string) (UploadSession, error)
+	WriteChunk(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string, offset int64, chunk io.Reader, size int64) (UploadSession, error)
+	FinalizeSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string, meta core.BlobMeta) (core.BlobMeta, error)
+	AbortSession(ctx context.Context, spec core.ArtifactVersionSpec, sessionId string) error
+	CleanupExpiredSessions(ctx context.Context) error
+}
+
+type UploadSession struct {
+	Id        string    `json:"id"`
+	Offset    int64     `json:"offset"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type sessionPart struct {
+	PartNumber int    `json:"partNumber"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size"`
+}
+
+// uploadSessionState is persisted by the adapters between requests.
+type uploadSessionState struct {
+	Id                string        `json:"id"`
+	Namespace         string        `json:"namespace"`
+	Name              string        `json:"name"`
+	Version           string        `json:"version"`
+	Offset            int64         `json:"offset"`
+	HashState         []byte        `json:"hashState"`
+	ExpiresAt         time.Time     `json:"expiresAt"`
+	MultipartUploadId string        `json:"multipartUploadId,omitempty"`
+	Parts             []sessionPart `json:"parts,omitempty"`
+}
+
+func newUploadSessionState(spec core.ArtifactVersionSpec, ttl time.Duration) (*uploadSessionState, error) {
+	state := &uploadSessionState{
+		Id:        core.RandomId(),
+		Namespace: spec.Namespace,
+		Name:      spec.Name,
+		Version:   spec.Version.String(),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	err := state.saveHash(sha256.New())
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+func (s *uploadSessionState) belongsTo(spec core.ArtifactVersionSpec) bool {
+	version, err := semver.NewVersion(s.Version)
+	if err != nil {
+		return false
+	}
+
+	return s.Namespace == spec.Namespace && s.Name == spec.Name && version.Equal(spec.Version)
+}
+
+func (s *uploadSessionState) isExpired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+func (s *uploadSessionState) session() UploadSession {
+	return UploadSession{
+		Id:        s.Id,
+		Offset:    s.Offset,
+		ExpiresAt: s.ExpiresAt,
+	}
+}
+
+// The running sha256 state is persisted, so the digest doesn't need to be recomputed when finalizing.
+func (s *uploadSessionState) restoreHash() (hash.Hash, error) {
+	hasher := sha256.New()
+
+	err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(s.HashState)
+	if err != nil {
+		return nil, err
+	}
+
+	return hasher, nil
+}
+
+func (s *uploadSessionState) saveHash(hasher hash.Hash) error {
+	hashState, err := hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	s.HashState = hashState
+
+	return nil
+}
+
+// verifySize compares the size of the session with the size, which has been checked against the quotas, if there is one.
+func (s *uploadSessionState) verifySize(expected int64) error {
+	if expected > 0 && expected != s.Offset {
+		return fmt.Errorf("%w: expected %d bytes but got %d", ErrSizeMismatch, expected, s.Offset)
+	}
+
+	return nil
+}
+
+func (s *uploadSessionState) verifyDigest(expected string) (string, error) {
+	hasher, err := s.restoreHash()
+	if err != nil {
+		return "", err
+	}
+
+	actual := fmt.Sprintf("sha256:%s", hex.EncodeToString(hasher.Sum(nil)))
+
+	if expected != actual {
+		return actual, fmt.Errorf("%w: expected %s but got %s", ErrDigestMismatch, expected, actual)
+	}
+
+	return actual, nil
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	ospath "path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/sevensolutions/tiny-repo/core"
+)
+
+// Usage is the storage used by the blobs of a namespace.
+type Usage struct {
+	Bytes    int64 `json:"bytes"`
+	Versions int   `json:"versions"`
+}
+
+// UsageReporter is implemented by adapters which can calculate the usage of a namespace, which is needed for quotas.
+type UsageReporter interface {
+	NamespaceUsage(namespace string) (Usage, error)
+	// VersionUsage returns the stored size of the blob of a version, counted like NamespaceUsage, and whether it exists.
+	VersionUsage(spec core.ArtifactVersionSpec) (int64, bool, error)
+}
+
+// NamespaceUsage sums up the stored size of all blobs in the namespace.
+// Compressed or encrypted blobs are counted with their stored size.
+func (a *LocalDirectoryAdapter) NamespaceUsage(namespace string) (Usage, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	usage := Usage{}
+
+	artifacts, err := os.ReadDir(ospath.Join(a.rootDirectory, namespace))
+	if os.IsNotExist(err) {
+		return usage, nil
+	}
+	if err != nil {
+		return usage, err
+	}
+
+	for _, artifact := range artifacts {
+		if !artifact.IsDir() {
+			continue
+		}
+
+		versions, err := os.ReadDir(ospath.Join(a.rootDirectory, namespace, artifact.Name()))
+		if err != nil {
+			return usage, err
+		}
+
+		for _, version := range versions {
+			info, err := os.Stat(ospath.Join(a.rootDirectory, namespace, artifact.Name(), version.Name(), "blob"))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return usage, err
+			}
+
+			usage.Bytes += info.Size()
+			usage.Versions++
+		}
+	}
+
+	return usage, nil
+}
+
+// VersionUsage doesn't need the mutex, as blobs are replaced by renaming them.
+func (a *LocalDirectoryAdapter) VersionUsage(spec core.ArtifactVersionSpec) (int64, bool, error) {
+	info, err := os.Stat(ospath.Join(a.rootDirectory, spec.Namespace, spec.Name, spec.Version.String(), "blob"))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return info.Size(), true, nil
+}
+
+func (a *MinioAdapter) NamespaceUsage(namespace string) (Usage, error) {
+	usage := Usage{}
+
+	objects := a.client.ListObjects(context.Background(), a.bucketName, minio.ListObjectsOptions{
+		Prefix:    namespace + "/",
+		Recursive: true,
+	})
+
+	for object := range objects {
+		if object.Err != nil {
+			return usage, object.Err
+		}
+
+		if strings.HasSuffix(object.Key, "/blob") {
+			usage.Bytes += object.Size
+			usage.Versions++
+		}
+	}
+
+	return usage, nil
+}
+
+func (a *MinioAdapter) VersionUsage(spec core.ArtifactVersionSpec) (int64, bool, error) {
+	info, err := a.client.StatObject(context.Background(), a.bucketName, versionPrefix(spec)+"blob", minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	return info.Size, true, nil
+}
+
+func (a *ProxyAdapter) NamespaceUsage(namespace string) (Usage, error) {
+	reporter, ok := a.inner.(UsageReporter)
+	if !ok {
+		return Usage{}, errors.New("the storage backend doesn't support usage reporting")
+	}
+
+	return reporter.NamespaceUsage(namespace)
+}
+
+// VersionUsage only reports the cached versions, like NamespaceUsage.
+func (a *ProxyAdapter) VersionUsage(spec core.ArtifactVersionSpec) (int64, bool, error) {
+	reporter, ok := a.inner.(UsageReporter)
+	if !ok {
+		return 0, false, errors.New("the storage backend doesn't support usage reporting")
+	}
+
+	return reporter.VersionUsage(spec)
+}